@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/facilities"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/dryrun"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/lifecycle"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/state"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+const usage string = `usage: integration-cip-sdl [command] [flags]
+
+Without a command the service polls all enabled integrations and serves its api.
+
+commands:
+  sync      run one or more integrations, once with --once or else on their schedule
+  validate  report the features in a saved facilities feed that can not be converted
+  diff      compare the source of one or more integrations with the context broker
+
+Run integration-cip-sdl [command] -h for the flags of each command.
+`
+
+// errUsage is returned by commands that are invoked with invalid flags
+var errUsage = errors.New("invalid usage")
+
+// runCommand runs a subcommand and returns the exit code of the process
+func runCommand(ctx context.Context, command string, args []string) int {
+	commands := map[string]func(context.Context, []string) error{
+		"sync":     syncCommand,
+		"validate": validateCommand,
+		"diff":     diffCommand,
+	}
+
+	cmd, ok := commands[command]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		if command == "-h" || command == "--help" || command == "help" {
+			return 0
+		}
+		return 2
+	}
+
+	err := cmd(ctx, args)
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	default:
+		fmt.Fprintln(os.Stderr, "error:", err.Error())
+		return 1
+	}
+}
+
+// listFlag collects the values of a flag that is given more than once or as a
+// comma separated list
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for v := range strings.SplitSeq(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+type selection struct {
+	integrations listFlag
+	entityTypes  listFlag
+}
+
+func (s *selection) addFlags(fs *flag.FlagSet) {
+	fs.Var(&s.integrations, "integration", "integration to run, can be repeated (default all enabled integrations)")
+	fs.Var(&s.entityTypes, "type", "limit the run to an entity type, can be repeated (requires a single --integration)")
+}
+
+func (s *selection) validate() error {
+	if len(s.entityTypes) > 0 && len(s.integrations) != 1 {
+		return fmt.Errorf("%w: --type can only be used together with a single --integration", errUsage)
+	}
+	return nil
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(os.Stderr)
+
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return fmt.Errorf("%w: %s", errUsage, err.Error())
+	}
+
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%w: unexpected arguments %v", errUsage, fs.Args())
+	}
+
+	return nil
+}
+
+// syncCommand runs the selected integrations. With --once every integration is run a single
+// time and the command fails if any of the runs fail, which makes it suitable for a CronJob.
+func syncCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	once := fs.Bool("once", false, "run a single pass and exit instead of polling")
+	sel := &selection{}
+	sel.addFlags(fs)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := sel.validate(); err != nil {
+		return err
+	}
+
+	if !*once {
+		serve(ctx, sel.integrations...)
+		return nil
+	}
+
+	cfg, configErr := loadConfig()
+	breaker, breakerErr := setupCircuitBreaker(cfg)
+	tenants, closeBroker, brokerErr := setupContextBroker(ctx, cfg, breaker)
+	defer closeBroker()
+
+	store, storeErr := setupStateStore(ctx, cfg)
+
+	if err := errors.Join(configErr, breakerErr, brokerErr, storeErr); err != nil {
+		return err
+	}
+
+	return runOnce(ctx, cfg, tenants, store, sel)
+}
+
+// runOnce runs the selected integrations one after the other and reports all failures. The
+// state of each integration is restored from, and saved to, the store unless it is nil.
+func runOnce(ctx context.Context, cfg integrations.Config, tenants *integrations.Tenants, store state.Store, sel *selection) error {
+	configured, err := createIntegrations(ctx, cfg, tenants, sel.integrations...)
+	if err != nil {
+		return err
+	}
+
+	if len(configured) == 0 {
+		return errors.New("no integrations are enabled")
+	}
+
+	// let a run that is interrupted finish its current write, as the daemon does
+	runCtx, cancel := lifecycle.WithDrainPeriod(ctx, lifecycle.DefaultDrainPeriod)
+	defer cancel()
+
+	logger := logging.GetFromContext(ctx)
+	errs := []error{}
+
+	for _, c := range configured {
+		i := c.integration
+
+		entityTypes := c.settings.EntityTypes
+		if len(sel.entityTypes) > 0 {
+			if err := integrations.SupportsEntityTypes(i, sel.entityTypes...); err != nil {
+				return fmt.Errorf("%w: %s", errUsage, err.Error())
+			}
+			entityTypes = sel.entityTypes
+		}
+
+		if err := integrations.RestoreState(ctx, i, store); err != nil {
+			logger.Error("failed to restore state", "integration", i.Name(), "err", err.Error())
+		}
+
+		err := i.Run(logging.NewContextWithLogger(runCtx, logger, "integration", i.Name()), entityTypes...)
+
+		if stateErr := integrations.CheckpointState(i, store); stateErr != nil {
+			errs = append(errs, stateErr)
+		}
+
+		for entityType, status := range i.Status().EntityTypes {
+			logger.Info("sync finished", "integration", i.Name(), "entityType", entityType, "counters", status.Counters)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", i.Name(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateCommand parses a saved facilities feed and reports every feature that would be rejected
+func validateCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	file := fs.String("file", "", "a FeatureCollection saved from the facilities api, or - to read from stdin")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		return fmt.Errorf("%w: --file is required", errUsage)
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	fc := domain.FeatureCollection{}
+	if err := json.NewDecoder(r).Decode(&fc); err != nil {
+		return fmt.Errorf("failed to decode %s as a FeatureCollection: %w", *file, err)
+	}
+
+	checked, failures := facilities.ValidateFeatures(quietContext(ctx), fc)
+
+	for _, f := range failures {
+		fmt.Println(f.Error())
+	}
+
+	fmt.Printf("%d of %d features are valid (%d features in the feed)\n", checked-len(failures), checked, len(fc.Features))
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d features failed validation", len(failures))
+	}
+
+	return nil
+}
+
+// diffCommand runs the selected integrations against a dry run client that compares every write
+// with the context broker, and prints the writes that would change the broker as NDJSON.
+func diffCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	all := fs.Bool("all", false, "also print entities that are unchanged")
+	sel := &selection{}
+	sel.addFlags(fs)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := sel.validate(); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	contextBrokerURL := cfg.Get("CONTEXT_BROKER_URL")
+	if contextBrokerURL == "" {
+		return fmt.Errorf("%w: CONTEXT_BROKER_URL must be set to compare with the context broker", errUsage)
+	}
+
+	limiter, err := setupRateLimiter(cfg)
+	if err != nil {
+		return err
+	}
+
+	clients, err := brokerClients(cfg, limiter)
+	if err != nil {
+		return err
+	}
+
+	sink := &diffSink{next: dryrun.NDJSON(nopCloser{os.Stdout}), all: *all, counts: map[string]int{}}
+	tenants := integrations.NewTenants(cfg, func(tenant string) client.ContextBrokerClient {
+		return dryrun.New(sink, dryrun.CompareWith(clients.create(tenant)))
+	})
+
+	// a diff does not write anything, so it must not record any progress either
+	err = runOnce(quietContext(ctx), diffConfig{cfg}, tenants, nil, sel)
+
+	fmt.Fprintf(os.Stderr, "new: %d, changed: %d, deleted: %d, unchanged: %d, not compared: %d\n",
+		sink.counts[dryrun.StatusNew], sink.counts[dryrun.StatusChanged], sink.counts[dryrun.OperationDelete],
+		sink.counts[dryrun.StatusUnchanged], sink.counts[""])
+
+	return err
+}
+
+// diffConfig lets a diff show the deletions that the integrations would carry out once they
+// have been confirmed. A diff starts without any state, so a deletion that has to be requested
+// in more than one run would otherwise never be shown.
+type diffConfig struct {
+	integrations.Config
+}
+
+func (c diffConfig) Get(key string) string {
+	if strings.HasSuffix(key, "_DELETE_CONFIRMATIONS") {
+		return "1"
+	}
+	return c.Config.Get(key)
+}
+
+// diffSink counts the records of a diff and passes them on unless they are unchanged
+type diffSink struct {
+	next   dryrun.Sink
+	all    bool
+	counts map[string]int
+	mu     sync.Mutex
+}
+
+func (s *diffSink) Write(r dryrun.Record) error {
+	s.mu.Lock()
+	if r.Operation == dryrun.OperationDelete && r.Status == dryrun.StatusChanged {
+		s.counts[dryrun.OperationDelete]++
+	} else {
+		s.counts[r.Status]++
+	}
+	s.mu.Unlock()
+
+	if r.Status == dryrun.StatusUnchanged && !s.all {
+		return nil
+	}
+
+	return s.next.Write(r)
+}
+
+func (s *diffSink) Close() error {
+	return s.next.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// quietContext returns a context whose logger only writes warnings and errors to stderr, so
+// that the output of a command is not drowned in logs
+func quietContext(ctx context.Context) context.Context {
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})
+	return logging.NewContextWithLogger(ctx, slog.New(handler))
+}
@@ -0,0 +1,9 @@
+package main
+
+// The integrations register themselves with the integration registry when their
+// packages are imported. A new source is made available to the service by adding
+// its package to this list.
+import (
+	_ "github.com/diwise/integration-cip-sdl/internal/pkg/application/citywork"
+	_ "github.com/diwise/integration-cip-sdl/internal/pkg/application/facilities"
+)
@@ -1,24 +1,37 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
-	"strings"
+	"syscall"
 	"time"
 
+	// embed the time zone database so that cron schedules can be evaluated in the
+	// time zone given by TZ, even if the container image lacks zoneinfo files
+	_ "time/tzdata"
+
 	"github.com/diwise/context-broker/pkg/ngsild/client"
-	"github.com/diwise/integration-cip-sdl/internal/pkg/application/citywork"
-	"github.com/diwise/integration-cip-sdl/internal/pkg/application/facilities"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/batch"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/broker"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/circuitbreaker"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/config"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/dryrun"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/lifecycle"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/ratelimit"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/state"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/presentation/api"
 	"github.com/diwise/service-chassis/pkg/infrastructure/buildinfo"
-	"github.com/diwise/service-chassis/pkg/infrastructure/env"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
-	"github.com/go-chi/chi"
-	"github.com/rs/cors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 const serviceName string = "integration-cip-sdl"
@@ -29,114 +42,349 @@ func main() {
 	ctx, _, cleanup := o11y.Init(context.Background(), serviceName, serviceVersion, "json")
 	defer cleanup()
 
-	contextBrokerURL := env.GetVariableOrDie(ctx, "CONTEXT_BROKER_URL", "Context Broker URL")
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	ctxBroker := client.NewContextBrokerClient(contextBrokerURL, client.Debug("true"))
+	if len(os.Args) > 1 {
+		code := runCommand(ctx, os.Args[1], os.Args[2:])
+		stop()
+		cleanup()
+		os.Exit(code)
+	}
 
-	if featureIsEnabled(ctx, "facilities") {
-		facilitiesURL := env.GetVariableOrDie(ctx, "FACILITIES_URL", "Facilities URL")
-		facilitiesApiKey := env.GetVariableOrDie(ctx, "FACILITIES_API_KEY", "Facilities Api Key")
-		timeInterval := env.GetVariableOrDefault(ctx, "FACILITIES_POLLING_INTERVAL", "58")
+	serve(ctx)
+}
 
-		parsedTime, err := strconv.ParseInt(timeInterval, 0, 64)
-		if err != nil {
-			fatal(ctx, "FACILITIES_POLLING_INTERVAL must be set to a valid integer", err)
+// serve polls the named integrations, or all enabled integrations if no names are given,
+// until the context is cancelled
+func serve(ctx context.Context, names ...string) {
+	svc, err := setup(ctx, names...)
+	if err != nil {
+		fatal(ctx, "invalid configuration", err)
+	}
+
+	defer svc.close()
+
+	svc.manager.Start(ctx)
+
+	port := cmp.Or(svc.cfg.Get("SERVICE_PORT"), "8080")
+	adminAPIKey := svc.cfg.Get("ADMIN_API_KEY")
+
+	setupRouterAndWaitForConnections(ctx, port, api.New(ctx, svc.manager, adminAPIKey), svc.manager)
+}
+
+// service is a validated configuration of the service whose manager is ready to be started
+type service struct {
+	cfg     *config.Config
+	manager *integrations.Manager
+	close   func()
+}
+
+// setup reads and validates the configuration of the service and of the named integrations.
+// All problems with the configuration are reported together, even if the context broker could
+// not be set up.
+func setup(ctx context.Context, names ...string) (*service, error) {
+	cfg, configErr := loadConfig()
+	breaker, breakerErr := setupCircuitBreaker(cfg)
+	tenants, closeBroker, brokerErr := setupContextBroker(ctx, cfg, breaker)
+	store, storeErr := setupStateStore(ctx, cfg)
+	configured, integrationsErr := createIntegrations(ctx, cfg, tenants, names...)
+
+	if err := errors.Join(configErr, breakerErr, brokerErr, storeErr, integrationsErr); err != nil {
+		closeBroker()
+		return nil, err
+	}
+
+	manager := integrations.NewManager(
+		integrations.WithStateStore(store),
+		integrations.WithCircuitBreaker(breaker),
+		integrations.WithOutbox(integrations.NewOutbox(nil, store, integrations.WithTenants(tenants))),
+	)
+	for _, c := range configured {
+		manager.Add(c.integration, c.settings)
+	}
+
+	return &service{cfg: cfg, manager: manager, close: closeBroker}, nil
+}
+
+// loadConfig reads the configuration file given by CONFIG_FILE, if any. Settings in the
+// environment take precedence over the file.
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	errs := []error{err}
+
+	for _, name := range cfg.Integrations() {
+		if !slices.Contains(integrations.Names(), name) {
+			errs = append(errs, fmt.Errorf("the config file contains settings for an unknown integration %s", name))
 		}
+	}
+
+	return cfg, errors.Join(errs...)
+}
+
+// setupContextBroker returns the tenants of the context broker, whose clients are dry run clients
+// that record all writes to DRY_RUN_OUTPUT if it is set. A dry run compares its writes with the
+// broker if CONTEXT_BROKER_URL is set. Requests to the broker are authenticated as configured by
+// BROKER_AUTH and limited to BROKER_RATE_LIMIT per second, and entities are written in batches of
+// BROKER_BATCH_SIZE if it is set. All writes to the broker go through the circuit breaker.
+func setupContextBroker(ctx context.Context, cfg integrations.Config, breaker *circuitbreaker.Breaker) (*integrations.Tenants, func(), error) {
+	contextBrokerURL := cfg.Get("CONTEXT_BROKER_URL")
+	dryRunOutput := cfg.Get("DRY_RUN_OUTPUT")
 
-		go SetupAndRunFacilities(ctx, facilitiesURL, facilitiesApiKey, int(parsedTime), ctxBroker)
+	limiter, err := setupRateLimiter(cfg)
+	if err != nil {
+		return nil, func() {}, err
 	}
 
-	if featureIsEnabled(ctx, "citywork") {
-		sundsvallvaxerURL := env.GetVariableOrDie(ctx, "SDL_KARTA_URL", "Sundsvall växer URL")
-		timeInterval := env.GetVariableOrDefault(ctx, "CITYWORK_POLLING_INTERVAL", "59")
+	clients, err := brokerClients(cfg, limiter)
+	if err != nil {
+		return nil, func() {}, err
+	}
 
-		parsedTime, err := strconv.ParseInt(timeInterval, 0, 64)
-		if err != nil {
-			fatal(ctx, "CITYWORK_POLLING_INTERVAL must be set to valid integer", err)
+	if dryRunOutput == "" {
+		if contextBrokerURL == "" {
+			return nil, func() {}, errors.New("please set CONTEXT_BROKER_URL to the url of the context broker")
 		}
 
-		cw := SetupCityWorkService(ctx, sundsvallvaxerURL, int(parsedTime), ctxBroker)
-		go cw.Start(ctx)
+		batchSize, err := strconv.Atoi(cmp.Or(cfg.Get("BROKER_BATCH_SIZE"), "0"))
+		if err != nil || batchSize < 0 {
+			return nil, func() {}, errors.New("BROKER_BATCH_SIZE must be set to the number of entities per batch, or 0 to write entities one at a time")
+		}
+
+		tenants := integrations.NewTenants(cfg, func(tenant string) client.ContextBrokerClient {
+			broker := circuitbreaker.NewClient(clients.create(tenant), breaker)
+
+			if batchSize > 0 {
+				broker = batch.NewClient(broker, contextBrokerURL,
+					batch.Size(batchSize),
+					batch.Tenant(tenant),
+					batch.Transport(clients.roundTripper()),
+					batch.WaitFor(limiter.Wait),
+					batch.Guard(breaker.Do),
+				)
+			}
+
+			return broker
+		})
+
+		return tenants, func() {}, nil
 	}
 
-	port := env.GetVariableOrDefault(ctx, "SERVICE_PORT", "8080")
+	sink, err := dryrun.NewSink(dryRunOutput)
+	if err != nil {
+		return nil, func() {}, err
+	}
+
+	closeSink := func() { sink.Close() }
+
+	logging.GetFromContext(ctx).Warn("dry run enabled, nothing will be written to the context broker", "output", dryRunOutput)
+
+	tenants := integrations.NewTenants(cfg, func(tenant string) client.ContextBrokerClient {
+		if contextBrokerURL == "" {
+			return dryrun.New(sink)
+		}
+		return dryrun.New(sink, dryrun.CompareWith(clients.create(tenant)))
+	})
 
-	setupRouterAndWaitForConnections(ctx, port)
+	return tenants, closeSink, nil
 }
 
-// featureIsEnabled checks wether a given feature is enabled by exanding the feature name into <uppercase>_ENABLED
-// and checking if the corresponding environment variable is set to true.
-//
-//	Ex: citywork -> CITYWORK_ENABLED
-func featureIsEnabled(ctx context.Context, feature string) bool {
-	featureKey := fmt.Sprintf("%s_ENABLED", strings.ToUpper(feature))
-	isEnabled := os.Getenv(featureKey) == "true"
+// brokerClientFactory creates rate limited clients for the tenants of the context broker
+type brokerClientFactory struct {
+	url       string
+	debug     bool
+	limiter   *ratelimit.Limiter
+	transport http.RoundTripper
+}
 
-	logger := logging.GetFromContext(ctx)
-	logger.Info("checking if feature is enabled", "feature", feature, "enabled", isEnabled)
+// brokerClients returns a factory of clients for the context broker at CONTEXT_BROKER_URL. The
+// clients authenticate as configured by BROKER_AUTH, and log failed requests if BROKER_DEBUG
+// is true.
+func brokerClients(cfg integrations.Config, limiter *ratelimit.Limiter) (*brokerClientFactory, error) {
+	errs := []error{}
+
+	debug := false
+	if value := cfg.Get("BROKER_DEBUG"); value != "" {
+		var err error
+		if debug, err = strconv.ParseBool(value); err != nil {
+			errs = append(errs, errors.New("BROKER_DEBUG must be set to true or false"))
+		}
+	}
 
-	return isEnabled
+	credentials, err := integrations.CredentialsFromConfig("broker", cfg, integrations.AuthNone)
+	errs = append(errs, err)
+
+	return &brokerClientFactory{
+		url:       cfg.Get("CONTEXT_BROKER_URL"),
+		debug:     debug,
+		limiter:   limiter,
+		transport: credentials.Transport(),
+	}, errors.Join(errs...)
 }
 
-func SetupCityWorkService(ctx context.Context, cityWorkURL string, timeInterval int, ctxBroker client.ContextBrokerClient) citywork.CityWorkSvc {
-	c := citywork.NewSdlClient(ctx, cityWorkURL)
+// create returns a client for a tenant whose requests wait for the limiter
+func (f *brokerClientFactory) create(tenant string) client.ContextBrokerClient {
+	return ratelimit.NewClient(broker.NewClient(f.url,
+		broker.Tenant(tenant),
+		broker.Debug(f.debug),
+		broker.Transport(f.roundTripper()),
+	), f.limiter)
+}
 
-	return citywork.NewCityWorkService(ctx, c, timeInterval, ctxBroker)
+// roundTripper returns the transport of every request to the context broker. It authenticates
+// the requests, and lets the limiter see the Retry-After header of 429 and 503 responses.
+func (f *brokerClientFactory) roundTripper() http.RoundTripper {
+	return f.limiter.Transport(otelhttp.NewTransport(f.transport))
 }
 
-func setupRouterAndWaitForConnections(ctx context.Context, port string) {
-	r := chi.NewRouter()
-	r.Use(cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowCredentials: true,
-		Debug:            false,
-	}).Handler)
+// setupRateLimiter returns a limiter that is shared by all requests to the context broker. The
+// number of concurrent requests per entity type, BROKER_WORKERS, is validated here as well since
+// it is read by every integration.
+func setupRateLimiter(cfg integrations.Config) (*ratelimit.Limiter, error) {
+	errs := []error{}
 
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
+	rate := defaultBrokerRateLimit
+	if value := cfg.Get("BROKER_RATE_LIMIT"); value != "" {
+		var err error
+		rate, err = strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 {
+			errs = append(errs, errors.New("BROKER_RATE_LIMIT must be set to a number of requests per second, or 0 for no limit"))
+		}
+	}
 
-	err := http.ListenAndServe(":"+port, r)
-	if err != nil {
-		fatal(ctx, "failed to start router", err)
+	workers, err := integrations.BrokerWorkers(cfg)
+	errs = append(errs, err)
+
+	return ratelimit.NewLimiter(rate, workers), errors.Join(errs...)
+}
+
+// setupCircuitBreaker returns a breaker that opens after BROKER_BREAKER_THRESHOLD consecutive
+// failed requests to the context broker, and lets a trial request through after
+// BROKER_BREAKER_COOLDOWN. A threshold of 0 disables the breaker.
+func setupCircuitBreaker(cfg integrations.Config) (*circuitbreaker.Breaker, error) {
+	errs := []error{}
+
+	threshold, err := strconv.Atoi(cmp.Or(cfg.Get("BROKER_BREAKER_THRESHOLD"), strconv.Itoa(defaultBreakerThreshold)))
+	if err != nil || threshold < 0 {
+		errs = append(errs, errors.New("BROKER_BREAKER_THRESHOLD must be set to a number of consecutive failures, or 0 to disable the circuit breaker"))
+	}
+
+	cooldown, err := time.ParseDuration(cmp.Or(cfg.Get("BROKER_BREAKER_COOLDOWN"), defaultBreakerCooldown.String()))
+	if err != nil || cooldown <= 0 {
+		errs = append(errs, errors.New("BROKER_BREAKER_COOLDOWN must be set to a positive duration, such as 30s or 5m"))
 	}
+
+	return circuitbreaker.New(threshold, cooldown), errors.Join(errs...)
 }
 
-func SetupAndRunFacilities(ctx context.Context, url, apiKey string, timeInterval int, ctxBroker client.ContextBrokerClient) facilities.Client {
+const (
+	defaultBreakerThreshold int           = 5
+	defaultBreakerCooldown  time.Duration = time.Minute
+)
+
+// defaultBrokerRateLimit is the number of requests per second that are sent to the context broker
+// unless BROKER_RATE_LIMIT says otherwise
+const defaultBrokerRateLimit float64 = 10
+
+// setupStateStore returns a store that keeps the state of the integrations in STATE_DIR, or
+// in memory if it is not set. A dry run never persists its state, since nothing it records
+// has actually been written to the context broker.
+func setupStateStore(ctx context.Context, cfg integrations.Config) (state.Store, error) {
+	stateDir := cfg.Get("STATE_DIR")
+
+	if stateDir == "" || cfg.Get("DRY_RUN_OUTPUT") != "" {
+		logging.GetFromContext(ctx).Warn("integration state will not survive a restart, set STATE_DIR to persist it")
+		return state.NewMemoryStore(), nil
+	}
+
+	return state.NewFileStore(stateDir)
+}
 
-	fc := facilities.NewClient(ctx, apiKey, url)
-	storage := facilities.NewStorage(ctx)
+type configuredIntegration struct {
+	integration integrations.Integration
+	settings    integrations.Settings
+}
 
+// createIntegrations creates and validates the named integrations, or every enabled integration
+// if no names are given. Naming an integration explicitly overrides its enabled setting. The
+// tenants may be nil if the context broker could not be set up, in which case the integrations
+// are only validated.
+func createIntegrations(ctx context.Context, cfg integrations.Config, tenants *integrations.Tenants, names ...string) ([]configuredIntegration, error) {
 	logger := logging.GetFromContext(ctx)
 
-	for {
-		features, err := fc.Get(ctx)
-		sleepDuration := time.Duration(timeInterval) * time.Minute
+	explicit := len(names) > 0
+	if !explicit {
+		names = integrations.Names()
+	}
+
+	configured := []configuredIntegration{}
+	errs := []error{}
 
+	for _, name := range names {
+		i, settings, err := integrations.New(ctx, name, cfg, tenants.For(name))
 		if err != nil {
-			const retryInterval int = 2
-			logger.Error("failed to retrieve facilities information", slog.Int("retry_in", retryInterval), "err", err.Error())
-			sleepDuration = time.Duration(retryInterval) * time.Minute
-		} else {
-			err = storage.StoreTrailsFromSource(ctx, ctxBroker, url, *features)
-			if err != nil {
-				logger.Error("failed to store exercise trails information", "err", err.Error())
-			}
-			err = storage.StoreBeachesFromSource(ctx, ctxBroker, url, *features)
-			if err != nil {
-				logger.Error("failed to store beaches information", "err", err.Error())
-			}
-			err = storage.StoreSportsFieldsFromSource(ctx, ctxBroker, url, *features)
-			if err != nil {
-				logger.Error("failed to store sports fields information", "err", err.Error())
-			}
-			err = storage.StoreSportsVenuesFromSource(ctx, ctxBroker, url, *features)
-			if err != nil {
-				logger.Error("failed to store sports venues information", "err", err.Error())
+			errs = append(errs, err)
+			continue
+		}
+
+		if !explicit {
+			logger.Info("checking if integration is enabled", "integration", name, "enabled", settings.Enabled)
+
+			if !settings.Enabled {
+				continue
 			}
 		}
 
-		time.Sleep(sleepDuration)
+		if err = i.Validate(ctx); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		configured = append(configured, configuredIntegration{integration: i, settings: settings})
+	}
+
+	return configured, errors.Join(errs...)
+}
+
+// setupRouterAndWaitForConnections serves the router until the context is cancelled, and then
+// gives the server and any running integrations a bounded period of time to wind down.
+func setupRouterAndWaitForConnections(ctx context.Context, port string, handler http.Handler, manager *integrations.Manager) {
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: handler,
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal(ctx, "failed to start router", err)
+		}
+	}()
+
+	<-ctx.Done()
+
+	logger := logging.GetFromContext(ctx)
+	logger.Info("shutting down", "drain_period", lifecycle.DefaultDrainPeriod.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lifecycle.DefaultDrainPeriod)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Error("failed to shut down http server gracefully", "err", err.Error())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		manager.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logger.Info("all integrations stopped")
+	case <-shutdownCtx.Done():
+		logger.Warn("integrations did not stop within the drain period")
 	}
 }
 
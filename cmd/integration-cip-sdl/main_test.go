@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatAMissingBrokerURLIsReportedAlongWithTheIntegrationErrors(t *testing.T) {
+	is := is.New(t)
+
+	for _, key := range []string{"CONFIG_FILE", "CONTEXT_BROKER_URL", "DRY_RUN_OUTPUT", "STATE_DIR", "CITYWORK_URL", "SDL_KARTA_URL"} {
+		t.Setenv(key, "")
+	}
+
+	svc, err := setup(context.Background(), "citywork")
+
+	is.True(svc == nil)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "CONTEXT_BROKER_URL"))
+	is.True(strings.Contains(err.Error(), "CITYWORK_URL"))
+}
+
+func TestThatADiffDoesNotWaitForDeletionsToBeConfirmed(t *testing.T) {
+	is := is.New(t)
+
+	cfg := diffConfig{mapConfig{"FACILITIES_DELETE_CONFIRMATIONS": "3", "FACILITIES_MAX_FEATURE_DROP": "10"}}
+
+	is.Equal(cfg.Get("FACILITIES_DELETE_CONFIRMATIONS"), "1")
+	is.Equal(cfg.Get("CITYWORK_DELETE_CONFIRMATIONS"), "1")
+	is.Equal(cfg.Get("FACILITIES_MAX_FEATURE_DROP"), "10")
+}
+
+type mapConfig map[string]string
+
+func (m mapConfig) Get(key string) string { return m[key] }
@@ -2,119 +2,291 @@ package citywork
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/diwise/context-broker/pkg/datamodels/fiware"
 	"github.com/diwise/context-broker/pkg/ngsild/client"
-	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
 	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
 	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/schedule"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 )
 
-type CityWorkSvc interface {
-	Start(ctx context.Context) error
+const IntegrationName string = "citywork"
+
+// EntityTypeCityWork is the name under which the outcome of city work runs is reported
+const EntityTypeCityWork string = "cityworks"
+
+func init() {
+	integrations.Register(IntegrationName, NewIntegration, integrations.Settings{
+		Schedule:      schedule.Every(59 * time.Minute),
+		RetryInterval: 2 * time.Minute,
+		MaxSyncAge:    3 * time.Hour,
+	})
+}
+
+func NewIntegration(ctx context.Context, cfg integrations.Config, brokers integrations.Brokers) integrations.Integration {
+	// SDL_KARTA_URL is still accepted for existing deployments
+	sundsvallvaxerURL := cfg.Get("CITYWORK_URL")
+	if sundsvallvaxerURL == "" {
+		sundsvallvaxerURL = cfg.Get("SDL_KARTA_URL")
+	}
+
+	credentials, credentialsErr := integrations.CredentialsFromConfig(IntegrationName, cfg, integrations.AuthNone)
+	retryPolicy, retryErr := integrations.FetchRetryPolicy(IntegrationName, cfg)
+	limits, limitsErr := integrations.FetchLimitsFromConfig(IntegrationName, cfg)
+	safeguard, safeguardErr := integrations.SafeguardFromConfig(IntegrationName, cfg)
+
+	endOfLife, endOfLifeErr := parseEndOfLifePolicy(cfg.Get("CITYWORK_END_OF_LIFE"))
+	if endOfLifeErr != nil {
+		endOfLifeErr = fmt.Errorf("CITYWORK_END_OF_LIFE: %w", endOfLifeErr)
+	}
+
+	sdl := NewSdlClient(ctx, sundsvallvaxerURL, WithCredentials(credentials), WithRetryPolicy(retryPolicy), WithFetchLimits(limits))
+
+	cw := newCityWorkService(ctx, sdl, brokers(EntityTypeCityWork))
+	cw.sundsvallvaxerURL = sundsvallvaxerURL
+	cw.endOfLife = endOfLife
+	cw.safeguard = safeguard
+	cw.configErr = errors.Join(credentialsErr, retryErr, limitsErr, safeguardErr, endOfLifeErr)
+	// an invalid value is reported when the service starts
+	cw.workers, _ = integrations.BrokerWorkers(cfg)
+
+	return cw
 }
 
-func NewCityWorkService(ctx context.Context, s SdlClient, timeInterval int, c client.ContextBrokerClient) CityWorkSvc {
+func NewCityWorkService(ctx context.Context, s SdlClient, c client.ContextBrokerClient) integrations.Integration {
+	return newCityWorkService(ctx, s, c)
+}
+
+func newCityWorkService(_ context.Context, s SdlClient, c client.ContextBrokerClient) *cwimpl {
 	return &cwimpl{
 		sdlClient:     s,
-		timeInterval:  timeInterval,
 		contextbroker: c,
+		tracker:       integrations.NewTracker(IntegrationName),
+		previous:      map[string]string{},
+		workers:       integrations.DefaultWorkers,
+		endOfLife:     EndOfLifeDelete,
+		now:           time.Now,
 	}
 }
 
 type cwimpl struct {
-	sdlClient     SdlClient
-	timeInterval  int
-	contextbroker client.ContextBrokerClient
+	sundsvallvaxerURL string
+	sdlClient         SdlClient
+	contextbroker     client.ContextBrokerClient
+	tracker           *integrations.Tracker
+	workers           int
+	endOfLife         EndOfLifePolicy
+	safeguard         *integrations.Safeguard
+	configErr         error
+	now               func() time.Time
+
+	// previous holds the content hash of every city work that has been written to
+	// the context broker, keyed by the id of the feature
+	previous map[string]string
+	mu       sync.Mutex
 }
 
-var previous map[string]string = make(map[string]string)
+type cityWorkState struct {
+	Previous map[string]string `json:"previous"`
+	// Safeguard holds the recent feature counts and the deletions that are not yet confirmed
+	Safeguard *integrations.Safeguard `json:"safeguard,omitempty"`
+}
 
-func (cw *cwimpl) Start(ctx context.Context) error {
-	for {
-		err := cw.getAndPublishCityWork(ctx)
-		sleepDuration := time.Duration(cw.timeInterval) * time.Minute
+func (cw *cwimpl) MarshalState() ([]byte, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
 
-		if err != nil {
-			const retryIntervalMinutes int = 2
-			log := logging.GetFromContext(ctx)
-			log.Error("failed to get city work", slog.Int("retry", retryIntervalMinutes*60), "err", err.Error())
-			sleepDuration = time.Duration(retryIntervalMinutes) * time.Minute
-		}
+	return json.Marshal(cityWorkState{Previous: cw.previous, Safeguard: cw.safeguard})
+}
 
-		time.Sleep(sleepDuration)
+func (cw *cwimpl) UnmarshalState(data []byte) error {
+	// a saved safeguard is restored into the configured one, and dropped if there is none
+	st := cityWorkState{Safeguard: cw.safeguard}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
 	}
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if st.Previous != nil {
+		cw.previous = st.Previous
+	}
+
+	return nil
 }
 
+func (cw *cwimpl) Name() string {
+	return IntegrationName
+}
+
+func (cw *cwimpl) Validate(ctx context.Context) error {
+	errs := []error{cw.configErr}
+
+	if cw.sundsvallvaxerURL == "" {
+		errs = append(errs, errors.New("please set CITYWORK_URL or SDL_KARTA_URL to a valid Sundsvall växer URL"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (cw *cwimpl) Run(ctx context.Context, _ ...string) (err error) {
+	cw.tracker.Attempt()
+	defer func() { cw.tracker.Done(err) }()
+
+	return cw.getAndPublishCityWork(ctx)
+}
+
+func (cw *cwimpl) Status() integrations.Status {
+	status := cw.tracker.Status()
+
+	if cw.safeguard != nil {
+		safeguard := cw.safeguard.Status()
+		status.Safeguard = &safeguard
+	}
+
+	return status
+}
+
+// getAndPublishCityWork writes each city work to the broker as soon as it has been received.
+// Deletions of city works that have disappeared wait until the whole feed has been received.
 func (cw *cwimpl) getAndPublishCityWork(ctx context.Context) error {
-	response, err := cw.sdlClient.Get(ctx)
 	logger := logging.GetFromContext(ctx)
-	if err != nil {
-		logger.Error("failed to get city work", "err", err.Error())
-		return fmt.Errorf("failed to get city work")
+
+	var run *integrations.EntityRun
+	var w *integrations.Writer
+
+	begin := func() {
+		run = cw.tracker.BeginEntityType(EntityTypeCityWork)
+		w = integrations.NewWriter(ctx, cw.contextbroker, run, cw.workers)
 	}
 
-	headers := map[string][]string{"Content-Type": {"application/ld+json"}}
+	now := cw.now()
+	inFeed := map[string]bool{}
+	received := 0
 
-	for _, f := range response.Features {
-		featureID := f.ID()
-		if _, exists := previous[featureID]; exists {
-			continue
+	err := cw.sdlClient.Get(ctx, func(f sdlFeature) error {
+		if w == nil {
+			begin()
 		}
 
-		entityID := fiware.CityWorkIDPrefix + f.ID()
+		if err := w.Err(); err != nil {
+			return err
+		}
 
-		attributes := toCityWorkModel(f)
+		received++
+		inFeed[f.ID()] = true
+		cw.publish(ctx, w, run, f, now)
 
-		fragment, _ := entities.NewFragment(attributes...)
+		return nil
+	})
+	if err != nil {
+		logger.Error("failed to get city work", "err", err.Error())
+		err = fmt.Errorf("failed to get city work")
 
-		_, err = cw.contextbroker.MergeEntity(ctx, entityID, fragment, headers)
-		if err != nil {
-			if !errors.Is(err, ngsierrors.ErrNotFound) {
-				logger.Error("failed to merge entity", "entityID", entityID, "err", err.Error())
-				logger.Info("waiting for context broker to recover...")
-				time.Sleep(10 * time.Second)
-				continue
-			}
-			entity, err := entities.New(entityID, fiware.CityWorkTypeName, attributes...)
-			if err != nil {
-				logger.Error("entities.New failed", "entityID", entityID, "err", err.Error())
-				continue
+		// the city works that were received before the failure are still written
+		if w != nil {
+			if writeErr := w.Wait(); writeErr != nil {
+				err = writeErr
 			}
+			run.Done(err)
+		}
 
-			_, err = cw.contextbroker.CreateEntity(ctx, entity, headers)
-			if err != nil {
-				logger.Error("failed to post city work to context broker", "entityID", entityID, "err", err.Error())
-				continue
-			}
+		return err
+	}
+
+	if w == nil {
+		begin()
+	}
+
+	cw.safeguard.Begin(ctx, received, EntityTypeCityWork)
+	cw.endDisappeared(ctx, w, run, inFeed, now)
+
+	err = w.Wait()
+	run.Done(err)
+
+	return err
+}
+
+// publish writes a city work that has changed since it was last written, or ends it if it has
+// ended according to the end of life policy
+func (cw *cwimpl) publish(ctx context.Context, w *integrations.Writer, run *integrations.EntityRun, f sdlFeature, now time.Time) {
+	run.Processed()
+
+	featureID := f.ID()
+	entityID := fiware.CityWorkIDPrefix + featureID
+
+	cw.mu.Lock()
+	written, exists := cw.previous[featureID]
+	cw.mu.Unlock()
+
+	status := ""
+	if cw.endOfLife == EndOfLifeStatus {
+		status = StatusOngoing
+	}
+
+	if cw.endOfLife != EndOfLifeIgnore && hasEnded(f, now) {
+		// a city work that ended before it was ever written is not created
+		if !exists {
+			run.Skipped()
+			return
 		}
 
-		previous[featureID] = featureID
+		if cw.endOfLife == EndOfLifeDelete {
+			logging.GetFromContext(ctx).Info("deleting city work that has ended", "entityID", entityID)
+			w.Delete(entityID, cw.forget(featureID))
+			return
+		}
+
+		status = StatusEnded
 	}
 
-	return nil
+	hash := contentHash(f, status)
+	if exists && written == hash {
+		run.Skipped()
+		return
+	}
+
+	w.Upsert(entityID, fiware.CityWorkTypeName, toCityWorkModel(f, status, now), cw.remember(featureID, hash))
 }
 
-func toCityWorkModel(sf sdlFeature) []entities.EntityDecoratorFunc {
+// contentHash returns a hash of the feature as it was received from the source, and of the
+// status that it is written with, if any
+func contentHash(f sdlFeature, status string) string {
+	b, _ := json.Marshal(f)
+	sum := sha256.Sum256(append(b, status...))
+	return hex.EncodeToString(sum[:])
+}
+
+// toCityWorkModel returns the attributes of a city work. Since the entity is merged whenever
+// the city work changes, the time of the write is given as dateModified.
+func toCityWorkModel(sf sdlFeature, status string, now time.Time) []entities.EntityDecoratorFunc {
 	long, lat, _ := sf.Geometry.AsPoint()
 
 	startDate := strings.ReplaceAll(sf.Properties.Start, "Z", "") + "T00:00:00Z"
-	endDate := strings.ReplaceAll(sf.Properties.End, "Z", "") + "T23:59:59Z"
 
 	attributes := append(
-		make([]entities.EntityDecoratorFunc, 0, 5),
+		make([]entities.EntityDecoratorFunc, 0, 6),
 		decorators.Location(lat, long),
 		decorators.Description(sf.Properties.Description),
 		decorators.DateTime("startDate", startDate),
-		decorators.DateTime("endDate", endDate),
-		decorators.DateTime("dateCreated", time.Now().UTC().Format(time.RFC3339)),
+		decorators.DateTime("endDate", endDate(sf)),
+		decorators.DateTime("dateModified", now.UTC().Format(time.RFC3339)),
 	)
 
+	if status != "" {
+		attributes = append(attributes, decorators.Status(status))
+	}
+
 	return attributes
 }
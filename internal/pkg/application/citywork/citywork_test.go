@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/diwise/context-broker/pkg/ngsild"
 	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
@@ -24,6 +26,116 @@ func TestThatMergeIsAttemptedBeforeCreate(t *testing.T) {
 	is.Equal(len(ctxBroker.CreateEntityCalls()), 2)
 }
 
+func TestThatPublishedCityWorkIsRememberedAcrossRestarts(t *testing.T) {
+	is, before, ctxBroker := testSetup(t, http.StatusOK, complex)
+	ctxBroker.CreateEntityFunc = func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+		return ngsild.NewCreateEntityResult(""), nil
+	}
+
+	is.NoErr(before.getAndPublishCityWork(context.Background()))
+	is.Equal(len(ctxBroker.CreateEntityCalls()), 2)
+
+	data, err := before.MarshalState()
+	is.NoErr(err)
+
+	_, after, ctxBroker := testSetup(t, http.StatusOK, complex)
+	is.NoErr(after.UnmarshalState(data))
+
+	is.NoErr(after.getAndPublishCityWork(context.Background()))
+	is.Equal(len(ctxBroker.MergeEntityCalls()), 0)
+	is.Equal(len(ctxBroker.CreateEntityCalls()), 0)
+}
+
+func TestThatChangedCityWorkIsUpdated(t *testing.T) {
+	is, before, _ := testSetup(t, http.StatusOK, complex)
+	before.contextbroker = acceptingBroker()
+
+	is.NoErr(before.getAndPublishCityWork(context.Background()))
+
+	data, err := before.MarshalState()
+	is.NoErr(err)
+
+	_, after, _ := testSetup(t, http.StatusOK, strings.Replace(complex, "2021-12-30Z", "2022-03-31Z", 1))
+	ctxBroker := acceptingBroker()
+	after.contextbroker = ctxBroker
+	is.NoErr(after.UnmarshalState(data))
+
+	is.NoErr(after.getAndPublishCityWork(context.Background()))
+	is.Equal(len(ctxBroker.MergeEntityCalls()), 1)
+	is.Equal(ctxBroker.MergeEntityCalls()[0].EntityID, "urn:ngsi-ld:CityWork:5")
+}
+
+func TestThatEndedCityWorkIsDeleted(t *testing.T) {
+	is, cw, _ := testSetup(t, http.StatusOK, complex)
+	ctxBroker := acceptingBroker()
+	cw.contextbroker = ctxBroker
+	cw.previous = map[string]string{"5": "an older version", "471": "an older version"}
+	cw.now = func() time.Time { return time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC) }
+
+	is.NoErr(cw.getAndPublishCityWork(context.Background()))
+
+	is.Equal(len(ctxBroker.DeleteEntityCalls()), 1)
+	is.Equal(ctxBroker.DeleteEntityCalls()[0].EntityID, "urn:ngsi-ld:CityWork:5")
+	is.Equal(len(ctxBroker.MergeEntityCalls()), 1)
+
+	_, remembered := cw.previous["5"]
+	is.True(!remembered)
+}
+
+func TestThatDisappearedCityWorkIsMarkedAsRemoved(t *testing.T) {
+	is, cw, _ := testSetup(t, http.StatusOK, complex)
+	ctxBroker := acceptingBroker()
+	cw.contextbroker = ctxBroker
+	cw.endOfLife = EndOfLifeStatus
+	cw.previous = map[string]string{"1024": "a city work that is no longer in the feed"}
+
+	is.NoErr(cw.getAndPublishCityWork(context.Background()))
+
+	var removed map[string]any
+	for _, call := range ctxBroker.MergeEntityCalls() {
+		body, _ := call.Fragment.MarshalJSON()
+		attributes := map[string]any{}
+		json.Unmarshal(body, &attributes)
+
+		if call.EntityID == "urn:ngsi-ld:CityWork:1024" {
+			removed = attributes
+		} else {
+			is.Equal(attributes["status"].(map[string]any)["value"], StatusOngoing)
+		}
+	}
+
+	is.Equal(removed["status"].(map[string]any)["value"], StatusRemoved)
+	is.Equal(cw.previous["1024"], removedHash)
+	is.Equal(len(ctxBroker.CreateEntityCalls()), 0)
+
+	// a city work that has been marked as removed is not marked again
+	is.NoErr(cw.getAndPublishCityWork(context.Background()))
+	is.Equal(len(ctxBroker.MergeEntityCalls()), 3)
+}
+
+func TestThatTheEndOfLifePolicyIsValidated(t *testing.T) {
+	is := is.New(t)
+
+	policy, err := parseEndOfLifePolicy("")
+	is.NoErr(err)
+	is.Equal(policy, EndOfLifeDelete)
+
+	_, err = parseEndOfLifePolicy("archive")
+	is.True(err != nil)
+}
+
+// acceptingBroker accepts every merge and delete
+func acceptingBroker() *test.ContextBrokerClientMock {
+	return &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return &ngsild.MergeEntityResult{}, nil
+		},
+		DeleteEntityFunc: func(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
+			return ngsild.NewDeleteEntityResult(), nil
+		},
+	}
+}
+
 func TestSimpleModelCanBeCreated(t *testing.T) {
 	is, _, _ := testSetup(t, 0, "")
 	m, err := toModel([]byte(simple))
@@ -89,8 +201,10 @@ func testSetup(t *testing.T, statusCode int, body string) (*is.I, *cwimpl, *test
 		},
 	}
 
-	cw := NewCityWorkService(context.Background(), &sdlc, 1, ctxBroker)
+	cw := NewCityWorkService(context.Background(), &sdlc, ctxBroker)
 	impl := cw.(*cwimpl)
+	// before any of the city works in the test data have ended
+	impl.now = func() time.Time { return time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC) }
 
 	return is, impl, ctxBroker
 }
@@ -254,3 +368,43 @@ const complex string = `
 	]
 }
 `
+
+func TestThatCityWorksAreWrittenWhileTheFeedIsReceived(t *testing.T) {
+	is := is.New(t)
+
+	m, err := toModel([]byte(complex))
+	is.NoErr(err)
+	first, _ := json.Marshal(m.Features[0])
+	second, _ := json.Marshal(m.Features[1])
+
+	merged := make(chan string, len(m.Features))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"type":"FeatureCollection","features":[`))
+		w.Write(first)
+		w.(http.Flusher).Flush()
+
+		// the rest of the feed is only sent once the first city work has been written
+		select {
+		case <-merged:
+		case <-time.After(5 * time.Second):
+			return
+		}
+
+		w.Write([]byte(","))
+		w.Write(second)
+		w.Write([]byte("]}"))
+	}))
+	defer server.Close()
+
+	ctxBroker := acceptingBroker()
+	ctxBroker.MergeEntityFunc = func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+		merged <- entityID
+		return &ngsild.MergeEntityResult{}, nil
+	}
+
+	cw := NewCityWorkService(context.Background(), &sdlClient{sundsvallvaxerURL: server.URL}, ctxBroker).(*cwimpl)
+	cw.now = func() time.Time { return time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC) }
+
+	is.NoErr(cw.getAndPublishCityWork(context.Background()))
+	is.Equal(len(ctxBroker.MergeEntityCalls()), 2)
+}
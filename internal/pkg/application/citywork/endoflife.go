@@ -0,0 +1,122 @@
+package citywork
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/diwise/context-broker/pkg/datamodels/fiware"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// EndOfLifePolicy decides what happens to city works that have ended, or that have
+// disappeared from the feed
+type EndOfLifePolicy string
+
+const (
+	// EndOfLifeIgnore leaves city works in the context broker after they have ended
+	EndOfLifeIgnore EndOfLifePolicy = "ignore"
+	// EndOfLifeStatus marks city works with the status ended or removed
+	EndOfLifeStatus EndOfLifePolicy = "status"
+	// EndOfLifeDelete deletes city works from the context broker
+	EndOfLifeDelete EndOfLifePolicy = "delete"
+)
+
+// The status of a city work when it is written with EndOfLifeStatus
+const (
+	StatusOngoing string = "ongoing"
+	StatusEnded   string = "ended"
+	StatusRemoved string = "removed"
+)
+
+// removedHash replaces the content hash of a city work that has been marked as removed
+const removedHash string = StatusRemoved
+
+func parseEndOfLifePolicy(value string) (EndOfLifePolicy, error) {
+	switch policy := EndOfLifePolicy(value); policy {
+	case EndOfLifeIgnore, EndOfLifeStatus, EndOfLifeDelete:
+		return policy, nil
+	case "":
+		return EndOfLifeDelete, nil
+	}
+
+	return EndOfLifeDelete, fmt.Errorf("unknown policy %q, expected one of %s, %s or %s", value, EndOfLifeIgnore, EndOfLifeStatus, EndOfLifeDelete)
+}
+
+// hasEnded tells if the city work ended before now. A city work without a valid end date
+// has not ended.
+func hasEnded(sf sdlFeature, now time.Time) bool {
+	end, err := time.Parse(time.RFC3339, endDate(sf))
+	return err == nil && end.Before(now)
+}
+
+func endDate(sf sdlFeature) string {
+	return strings.ReplaceAll(sf.Properties.End, "Z", "") + "T23:59:59Z"
+}
+
+// endDisappeared deletes, or marks as removed, the city works that have been written to the
+// context broker but are no longer in the feed. Like any deletion, this has to be confirmed
+// by the safeguard, so that a truncated feed does not end every city work at once.
+func (cw *cwimpl) endDisappeared(ctx context.Context, w *integrations.Writer, run *integrations.EntityRun, inFeed map[string]bool, now time.Time) {
+	if cw.endOfLife == EndOfLifeIgnore {
+		return
+	}
+
+	logger := logging.GetFromContext(ctx)
+
+	cw.mu.Lock()
+	disappeared := slices.Sorted(maps.Keys(cw.previous))
+	hashes := maps.Clone(cw.previous)
+	cw.mu.Unlock()
+
+	for _, featureID := range disappeared {
+		if inFeed[featureID] || hashes[featureID] == removedHash {
+			continue
+		}
+
+		entityID := fiware.CityWorkIDPrefix + featureID
+		run.Orphaned(entityID)
+
+		if !cw.safeguard.ConfirmDelete(EntityTypeCityWork, entityID) {
+			logger.Warn("holding back the end of a city work until it has been confirmed", "entityID", entityID)
+			run.Held()
+			continue
+		}
+
+		if cw.endOfLife == EndOfLifeDelete {
+			logger.Info("deleting city work that has disappeared from the feed", "entityID", entityID)
+			w.Delete(entityID, cw.forget(featureID))
+			continue
+		}
+
+		logger.Info("marking city work that has disappeared from the feed as removed", "entityID", entityID)
+		w.Merge(entityID, []entities.EntityDecoratorFunc{
+			decorators.Status(StatusRemoved),
+			decorators.DateTime("dateModified", now.UTC().Format(time.RFC3339)),
+		}, cw.remember(featureID, removedHash))
+	}
+}
+
+// remember returns a callback that records the content hash of a city work once it has been written
+func (cw *cwimpl) remember(featureID, hash string) func() {
+	return func() {
+		cw.mu.Lock()
+		defer cw.mu.Unlock()
+		cw.previous[featureID] = hash
+	}
+}
+
+// forget returns a callback that forgets a city work once it has been deleted
+func (cw *cwimpl) forget(featureID string) func() {
+	return func() {
+		cw.mu.Lock()
+		defer cw.mu.Unlock()
+		delete(cw.previous, featureID)
+	}
+}
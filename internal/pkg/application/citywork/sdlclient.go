@@ -2,12 +2,16 @@ package citywork
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/auth"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/geojson"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/retry"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
@@ -18,24 +22,56 @@ import (
 var sdltracer = otel.Tracer("sdl-cityworks-client")
 
 type SdlClient interface {
-	Get(cxt context.Context) (*sdlResponse, error)
+	// Get hands the city works to feature one at a time, as soon as they have been decoded
+	Get(cxt context.Context, feature func(sdlFeature) error) error
 }
 
 type sdlClient struct {
 	sundsvallvaxerURL string
 	httpClient        http.Client
+	retryPolicy       retry.Policy
+	limits            integrations.FetchLimits
 }
 
-func NewSdlClient(ctx context.Context, sundsvallvaxerURL string) SdlClient {
-	return &sdlClient{
+// WithCredentials decides how requests to Sundsvall växer are authenticated
+func WithCredentials(credentials auth.Credentials) func(*sdlClient) {
+	return func(c *sdlClient) {
+		c.httpClient.Transport = otelhttp.NewTransport(credentials.Transport())
+	}
+}
+
+// WithRetryPolicy controls how failed requests to Sundsvall växer are retried
+func WithRetryPolicy(policy retry.Policy) func(*sdlClient) {
+	return func(c *sdlClient) {
+		c.retryPolicy = policy
+	}
+}
+
+// WithFetchLimits bounds the time and memory that a single request to Sundsvall växer may use
+func WithFetchLimits(limits integrations.FetchLimits) func(*sdlClient) {
+	return func(c *sdlClient) {
+		c.limits = limits
+	}
+}
+
+func NewSdlClient(ctx context.Context, sundsvallvaxerURL string, options ...func(*sdlClient)) SdlClient {
+	c := &sdlClient{
 		sundsvallvaxerURL: sundsvallvaxerURL,
 		httpClient: http.Client{
 			Transport: otelhttp.NewTransport(http.DefaultTransport),
 		},
+		retryPolicy: retry.DefaultPolicy,
+		limits:      integrations.DefaultFetchLimits,
 	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
 }
 
-func (c *sdlClient) Get(ctx context.Context) (*sdlResponse, error) {
+func (c *sdlClient) Get(ctx context.Context, feature func(sdlFeature) error) error {
 	var err error
 	ctx, span := sdltracer.Start(ctx, "get-sdl-cityworks-info")
 	defer func() {
@@ -47,6 +83,53 @@ func (c *sdlClient) Get(ctx context.Context) (*sdlResponse, error) {
 
 	log := logging.GetFromContext(ctx)
 
+	var m *sdlResponse
+	handed := 0
+
+	err = retry.Do(ctx, c.retryPolicy, func() error {
+		m, err = c.fetch(ctx, func(f sdlFeature) error {
+			handed++
+			return feature(f)
+		})
+		if err != nil && handed > 0 {
+			// a retry would hand the same city works over again
+			return &integrations.InterruptedError{Err: err, Handed: handed}
+		}
+		return err
+	})
+	if err != nil {
+		var tooLarge *integrations.BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			log.Error("the response from Sundsvall växer is too large, check CITYWORK_MAX_BODY_SIZE", "err", err.Error())
+		} else if retry.IsPermanent(err) {
+			log.Error("Sundsvall växer rejected the request, check CITYWORK_URL", "err", err.Error())
+		}
+		return err
+	}
+
+	log.Debug("received response", "features", handed)
+
+	if len(m.Error) > 0 {
+		err = fmt.Errorf("endpoint returned 200 OK with err body: (%s)", m.Error)
+		return err
+	}
+
+	return nil
+}
+
+// fetch makes a single attempt at retrieving the city works, and hands each of them to
+// feature while the response is decoded. The other members of the response are returned.
+func (c *sdlClient) fetch(ctx context.Context, feature func(sdlFeature) error) (m *sdlResponse, err error) {
+	var statusCode int
+	defer func(started time.Time) {
+		integrations.ObserveFetch(IntegrationName, started, statusCode, err)
+	}(time.Now())
+
+	log := logging.GetFromContext(ctx)
+
+	ctx, cancel := c.limits.WithTimeout(ctx)
+	defer cancel()
+
 	apiReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.sundsvallvaxerURL, nil)
 	if err != nil {
 		return nil, err
@@ -60,33 +143,21 @@ func (c *sdlClient) Get(ctx context.Context) (*sdlResponse, error) {
 
 	defer apiResponse.Body.Close()
 
+	statusCode = apiResponse.StatusCode
+
 	if apiResponse.StatusCode != http.StatusOK {
 		log.Error("unexpected response code when retrieving traffic information", slog.Int("expected", http.StatusOK), slog.Int("received", apiResponse.StatusCode))
-		return nil, fmt.Errorf("expected status code %d, but got %d", http.StatusOK, apiResponse.StatusCode)
+		err = retry.NewStatusError(apiResponse)
+		return nil, err
 	}
 
-	body, err := io.ReadAll(apiResponse.Body)
+	m = &sdlResponse{}
+	err = geojson.Decode(c.limits.Body(apiResponse), m, feature)
 	if err != nil {
 		log.Error("failed to read response body", "err", err.Error())
+		err = fmt.Errorf("failed to decode model: %w", err)
 		return nil, err
 	}
 
-	strBody := string(body)
-	if len(strBody) > 100 {
-		strBody = strBody[:100]
-	}
-
-	log.Debug("received response", "body", strBody)
-
-	var m sdlResponse
-	err = json.Unmarshal(body, &m)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal model")
-	}
-
-	if len(m.Error) > 0 {
-		return nil, fmt.Errorf("endpoint returned 200 OK with err body: (%s)", m.Error)
-	}
-
-	return &m, err
+	return m, nil
 }
@@ -18,7 +18,7 @@ var _ SdlClient = &SdlClientMock{}
 //
 //		// make and configure a mocked SdlClient
 //		mockedSdlClient := &SdlClientMock{
-//			GetFunc: func(cxt context.Context) (*sdlResponse, error) {
+//			GetFunc: func(cxt context.Context, feature func(sdlFeature) error) error {
 //				panic("mock out the Get method")
 //			},
 //		}
@@ -29,7 +29,7 @@ var _ SdlClient = &SdlClientMock{}
 //	}
 type SdlClientMock struct {
 	// GetFunc mocks the Get method.
-	GetFunc func(cxt context.Context) (*sdlResponse, error)
+	GetFunc func(cxt context.Context, feature func(sdlFeature) error) error
 
 	// calls tracks calls to the methods.
 	calls struct {
@@ -37,25 +37,29 @@ type SdlClientMock struct {
 		Get []struct {
 			// Cxt is the cxt argument value.
 			Cxt context.Context
+			// Feature is the feature argument value.
+			Feature func(sdlFeature) error
 		}
 	}
 	lockGet sync.RWMutex
 }
 
 // Get calls GetFunc.
-func (mock *SdlClientMock) Get(cxt context.Context) (*sdlResponse, error) {
+func (mock *SdlClientMock) Get(cxt context.Context, feature func(sdlFeature) error) error {
 	if mock.GetFunc == nil {
 		panic("SdlClientMock.GetFunc: method is nil but SdlClient.Get was just called")
 	}
 	callInfo := struct {
-		Cxt context.Context
+		Cxt     context.Context
+		Feature func(sdlFeature) error
 	}{
-		Cxt: cxt,
+		Cxt:     cxt,
+		Feature: feature,
 	}
 	mock.lockGet.Lock()
 	mock.calls.Get = append(mock.calls.Get, callInfo)
 	mock.lockGet.Unlock()
-	return mock.GetFunc(cxt)
+	return mock.GetFunc(cxt, feature)
 }
 
 // GetCalls gets all the calls that were made to Get.
@@ -63,10 +67,12 @@ func (mock *SdlClientMock) Get(cxt context.Context) (*sdlResponse, error) {
 //
 //	len(mockedSdlClient.GetCalls())
 func (mock *SdlClientMock) GetCalls() []struct {
-	Cxt context.Context
+	Cxt     context.Context
+	Feature func(sdlFeature) error
 } {
 	var calls []struct {
-		Cxt context.Context
+		Cxt     context.Context
+		Feature func(sdlFeature) error
 	}
 	mock.lockGet.RLock()
 	calls = mock.calls.Get
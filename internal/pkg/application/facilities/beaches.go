@@ -3,81 +3,90 @@ package facilities
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"strconv"
 	"time"
 
 	"github.com/diwise/context-broker/pkg/datamodels/fiware"
 	"github.com/diwise/context-broker/pkg/ngsild/client"
-	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
 	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
 	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
 	"github.com/diwise/context-broker/pkg/ngsild/types/properties"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 )
 
-func (s *storageImpl) StoreBeachesFromSource(ctx context.Context, ctxBrokerClient client.ContextBrokerClient, sourceURL string, featureCollection domain.FeatureCollection) error {
-	headers := map[string][]string{"Content-Type": {"application/ld+json"}}
+func isBeach(t string) bool {
+	return t == "Strandbad"
+}
+
+func (s *storageImpl) StoreBeachesFromSource(ctx context.Context, ctxBrokerClient client.ContextBrokerClient, sourceURL string, features *Feed) error {
+	defer features.stop()
 
 	logger := logging.GetFromContext(ctx)
+	run := s.tracker.BeginEntityType(TypeBeaches)
+	inFeed := map[int64]bool{}
+	deletions := []deletion{}
+
+	index, err := prefetch(ctx, ctxBrokerClient, fiware.BeachTypeName, fiware.BeachIDPrefix)
+	if err != nil {
+		run.Done(err)
+		return err
+	}
+
+	w := integrations.NewWriter(ctx, ctxBrokerClient, run, s.workers, integrations.WithIndex(index))
+
+	for feature := range features.All() {
+		if w.Err() != nil {
+			break
+		}
+
+		if isBeach(feature.Properties.Type) {
+			run.Processed()
+			inFeed[feature.ID] = true
 
-	for _, feature := range featureCollection.Features {
-		if feature.Properties.Type == "Strandbad" {
 			beach, err := parseBeach(ctx, feature)
 			if err != nil {
 				logger.Error("failed to parse beach", slog.Int64("featureID", feature.ID), "err", err.Error())
+				run.Rejected(err)
 				continue
 			}
 
+			if ref, ok := s.seeAlsoRefs[feature.ID]; ok {
+				ref.applyTo(beach)
+			}
+
 			entityID := fiware.BeachIDPrefix + beach.ID
 
 			if okToDel, alreadyDeleted := s.shouldBeDeleted(ctx, feature); okToDel {
-				if !alreadyDeleted {
-					_, err := ctxBrokerClient.DeleteEntity(ctx, entityID)
-					if err != nil {
-						logger.Info("could not delete entity", "entityID", entityID, "err", err.Error())
-					}
+				if alreadyDeleted {
+					run.Skipped()
+				} else {
+					deletions = append(deletions, deletion{featureID: feature.ID, entityID: entityID})
 				}
 				continue
 			}
 
 			attributes := convertDomainBeachToFiwareBeach(*beach)
 
-			fragment, _ := entities.NewFragment(attributes...)
-
-			_, err = ctxBrokerClient.MergeEntity(ctx, entityID, fragment, headers)
-
-			// Throttle so we dont kill the broker
-			time.Sleep(100 * time.Millisecond)
-
-			if err != nil {
-				if !errors.Is(err, ngsierrors.ErrNotFound) {
-					logger.Error("failed to merge entity", "entityID", entityID, "err", err.Error())
-					logger.Info("waiting for context broker to recover...")
-					time.Sleep(10 * time.Second)
-					continue
-				}
-				entity, err := entities.New(entityID, fiware.BeachTypeName, attributes...)
-				if err != nil {
-					logger.Error("entities.New failed", "entityID", entityID, "err", err.Error())
-					continue
-				}
+			w.UpsertChanges(s.fingerprints, entityID, fiware.BeachTypeName, attributes)
+		}
 
-				res, err := ctxBrokerClient.CreateEntity(ctx, entity, headers)
-				if err != nil {
-					logger.Error("failed to post beach to context broker", "entityID", entityID, "err", err.Error())
-					continue
-				}
+	}
 
-				logger.Info("posted beach to context broker", "location", res.Location())
-			}
-		}
+	if err = s.deleteFeatures(ctx, w, run, features, deletions); err == nil {
+		s.reconcile(ctx, w, run, fiware.BeachIDPrefix, index, features, inFeed)
+	}
 
+	if writeErr := w.Wait(); writeErr != nil {
+		err = writeErr
 	}
+	run.Done(err)
 
-	return nil
+	return err
 }
 
 func parseBeach(ctx context.Context, feature domain.Feature) (*domain.Beach, error) {
@@ -129,16 +138,6 @@ func parseBeach(ctx context.Context, feature domain.Feature) (*domain.Beach, err
 		}
 	}
 
-	if ref, ok := seeAlsoRefs[feature.ID]; ok {
-		if len(ref.nuts) > 0 {
-			beach.NUTSCode = &ref.nuts
-		}
-
-		if len(ref.wikidata) > 0 {
-			beach.WikidataID = &ref.wikidata
-		}
-	}
-
 	return beach, nil
 }
 
@@ -148,6 +147,50 @@ type extraInfo struct {
 	sensorID string
 }
 
+func (ref extraInfo) applyTo(beach *domain.Beach) {
+	if len(ref.nuts) > 0 {
+		beach.NUTSCode = &ref.nuts
+	}
+
+	if len(ref.wikidata) > 0 {
+		beach.WikidataID = &ref.wikidata
+	}
+}
+
+// parseSeeAlsoRefs reads overrides of the built in references to other sources of
+// information about beaches. The overrides are given as a JSON object keyed by
+// feature id, e.g. {"283": {"nuts": "SE0712281000003473", "wikidata": "Q10671745"}},
+// and replace any built in references for the same beach.
+func parseSeeAlsoRefs(overrides string) (map[int64]extraInfo, error) {
+	refs := maps.Clone(seeAlsoRefs)
+
+	if overrides == "" {
+		return refs, nil
+	}
+
+	m := map[string]struct {
+		NUTS     string `json:"nuts"`
+		Wikidata string `json:"wikidata"`
+		SensorID string `json:"sensorID"`
+	}{}
+
+	err := json.Unmarshal([]byte(overrides), &m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid see also references: %w", err)
+	}
+
+	for key, ref := range m {
+		featureID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid see also references: %q is not a feature id", key)
+		}
+
+		refs[featureID] = extraInfo{nuts: ref.NUTS, wikidata: ref.Wikidata, sensorID: ref.SensorID}
+	}
+
+	return refs, nil
+}
+
 var seeAlsoRefs map[int64]extraInfo = map[int64]extraInfo{
 	// Slädaviken
 	283: {nuts: "SE0712281000003473", sensorID: "sk-elt-temp-21", wikidata: "Q10671745"},
@@ -210,7 +253,6 @@ var seeAlsoRefs map[int64]extraInfo = map[int64]extraInfo{
 
 func convertDomainBeachToFiwareBeach(b domain.Beach) []entities.EntityDecoratorFunc {
 	properties := []entities.EntityDecoratorFunc{
-		entities.DefaultContext(),
 		decorators.Description(b.Description),
 		decorators.LocationMP(b.Geometry.Lines),
 		decorators.DateTimeIfNotZero(properties.DateCreated, b.DateCreated),
@@ -7,8 +7,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/diwise/context-broker/pkg/datamodels/fiware"
 	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+	"github.com/matryer/is"
 )
 
 func TestBeachesDataLoad(t *testing.T) {
@@ -19,12 +22,30 @@ func TestBeachesDataLoad(t *testing.T) {
 	json.Unmarshal([]byte(response), &fc)
 
 	storage := NewStorage(ctx)
-	err := storage.StoreBeachesFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err := storage.StoreBeachesFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 
 	is.NoErr(err)
 	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 1)
 }
 
+func TestThatBeachesAreCountedByTheTracker(t *testing.T) {
+	is, ctxBrokerMock, server := testSetup(t, "", http.StatusOK, response)
+	ctx := context.Background()
+
+	fc := domain.FeatureCollection{}
+	json.Unmarshal([]byte(response), &fc)
+
+	tracker := integrations.NewTracker(IntegrationName)
+	storage := NewStorage(ctx, WithTracker(tracker))
+	err := storage.StoreBeachesFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
+	is.NoErr(err)
+
+	beaches := tracker.Status().EntityTypes[TypeBeaches]
+	is.Equal(beaches.Processed, 1)
+	is.Equal(beaches.Failed, 1) // the mocked CreateEntity always fails
+	is.True(!beaches.LastSuccess.IsZero())
+}
+
 func TestDeletedBeach(t *testing.T) {
 	is, ctxBrokerMock, server := testSetup(t, "", http.StatusOK, response)
 	ctx := context.Background()
@@ -33,17 +54,33 @@ func TestDeletedBeach(t *testing.T) {
 		return &ngsild.DeleteEntityResult{}, nil
 	}
 
-	client := NewClient(ctx, "apiKey", server.URL)
+	client := NewClient(ctx, server.URL)
 
-	featureCollection, err := client.Get(ctx)
+	featureCollection, err := download(ctx, client)
 	is.NoErr(err)
 
 	var aWeekAgo = time.Now().UTC().Add(-1 * 7 * 24 * time.Hour).Format("2006-01-02 15:04:05")
 	featureCollection.Features[0].Properties.Deleted = &aWeekAgo
+	withEntitiesInBroker(ctxBrokerMock, fiware.BeachTypeName, fiware.BeachIDPrefix, "1545")
 
 	storage := NewStorage(ctx)
-	err = storage.StoreBeachesFromSource(ctx, ctxBrokerMock, server.URL, *featureCollection)
+	err = storage.StoreBeachesFromSource(ctx, ctxBrokerMock, server.URL, feedOf(featureCollection))
 
 	is.NoErr(err)
 	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
 }
+
+func TestThatSeeAlsoRefsCanBeOverridden(t *testing.T) {
+	is := is.New(t)
+
+	refs, err := parseSeeAlsoRefs(`{"283": {"nuts": "SE0000000000000001"}, "9999": {"wikidata": "Q1"}}`)
+	is.NoErr(err)
+
+	is.Equal(refs[283], extraInfo{nuts: "SE0000000000000001"})
+	is.Equal(refs[9999], extraInfo{wikidata: "Q1"})
+	is.Equal(refs[284], seeAlsoRefs[284]) // built in references are kept
+	is.Equal(seeAlsoRefs[283].wikidata, "Q10671745")
+
+	_, err = parseSeeAlsoRefs(`{"Slädaviken": {"nuts": "SE0712281000003473"}}`)
+	is.True(err != nil)
+}
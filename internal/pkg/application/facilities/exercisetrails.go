@@ -3,7 +3,6 @@ package facilities
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log/slog"
 	"math"
@@ -13,12 +12,10 @@ import (
 
 	"github.com/diwise/context-broker/pkg/datamodels/diwise"
 	"github.com/diwise/context-broker/pkg/ngsild/client"
-	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
-	"github.com/diwise/context-broker/pkg/ngsild/geojson"
-	"github.com/diwise/context-broker/pkg/ngsild/types"
 	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
 	"github.com/diwise/context-broker/pkg/ngsild/types/properties"
 	"github.com/diwise/context-broker/pkg/ngsild/types/relationships"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 
@@ -35,83 +32,83 @@ const (
 	SkiTrack        string = "Skidspår"
 )
 
-func (s *storageImpl) StoreTrailsFromSource(ctx context.Context, ctxBrokerClient client.ContextBrokerClient, sourceURL string, featureCollection domain.FeatureCollection) error {
+func isExerciseTrail(theType string) bool {
+	type StringSet map[string]struct{}
+	_, theTypeIsInSet := StringSet{BikeTrail: {}, ExerciseTrail: {}, IceSkatingTrail: {}, SkiLift: {}, SkiSlope: {}, SkiTrack: {}}[theType]
+	return theTypeIsInSet
+}
+
+func (s *storageImpl) StoreTrailsFromSource(ctx context.Context, ctxBrokerClient client.ContextBrokerClient, sourceURL string, features *Feed) error {
+	defer features.stop()
 
 	logger := logging.GetFromContext(ctx)
+	run := s.tracker.BeginEntityType(TypeTrails)
+	inFeed := map[int64]bool{}
+	deletions := []deletion{}
+
+	index, err := prefetch(ctx, ctxBrokerClient, diwise.ExerciseTrailTypeName, diwise.ExerciseTrailIDPrefix)
+	if err != nil {
+		run.Done(err)
+		return err
+	}
+
+	w := integrations.NewWriter(ctx, ctxBrokerClient, run, s.workers, integrations.WithIndex(index))
 	logger.Info("creating or updating exercise trails in broker...")
 
-	headers := map[string][]string{"Content-Type": {"application/ld+json"}}
+	for feature := range features.All() {
+		if w.Err() != nil {
+			break
+		}
 
-	isSupportedType := func(theType string) bool {
-		type StringSet map[string]struct{}
-		_, theTypeIsInSet := StringSet{BikeTrail: {}, ExerciseTrail: {}, IceSkatingTrail: {}, SkiLift: {}, SkiSlope: {}, SkiTrack: {}}[theType]
-		return theTypeIsInSet
-	}
+		if isExerciseTrail(feature.Properties.Type) {
+			run.Processed()
+			inFeed[feature.ID] = true
 
-	for _, feature := range featureCollection.Features {
-		if isSupportedType(feature.Properties.Type) {
 			exerciseTrail, err := parseExerciseTrail(ctx, feature)
 			if err != nil {
 				logger.Error("failed to parse exercise trail", slog.Int64("featureID", feature.ID), "err", err.Error())
+				run.Rejected(err)
 				continue
 			}
 
 			entityID := diwise.ExerciseTrailIDPrefix + exerciseTrail.ID
 
 			if okToDel, alreadyDeleted := s.shouldBeDeleted(ctx, feature); okToDel {
-				if !alreadyDeleted {
-					_, err := ctxBrokerClient.DeleteEntity(ctx, entityID)
-					if err != nil {
-						logger.Info("could not delete entity", "entityID", entityID, "err", err.Error())
-					}
+				if alreadyDeleted {
+					run.Skipped()
+				} else {
+					deletions = append(deletions, deletion{featureID: feature.ID, entityID: entityID})
 				}
 				continue
 			}
 
 			exerciseTrail.Source = fmt.Sprintf("%s/get/%d", sourceURL, feature.ID)
 
-			entity, err := ctxBrokerClient.RetrieveEntity(ctx, entityID, headers)
-			if err != nil {
-				entity = nil
+			// the broker tells which attributes were written before there were any fingerprints
+			if existing, ok := index.Get(entityID); ok {
+				s.fingerprints.Seed(entityID, existing)
 			}
 
-			attributes := convertDBTrailToFiwareExerciseTrail(*exerciseTrail, entity)
-
-			fragment, _ := entities.NewFragment(attributes...)
-
-			_, err = ctxBrokerClient.MergeEntity(ctx, entityID, fragment, headers)
-
-			// Throttle so we dont kill the broker
-			time.Sleep(100 * time.Millisecond)
+			attributes := convertDBTrailToFiwareExerciseTrail(*exerciseTrail, func(attributeName string) bool {
+				return s.fingerprints.Has(entityID, attributeName)
+			})
 
-			if err != nil {
-				if !errors.Is(err, ngsierrors.ErrNotFound) {
-					logger.Error("failed to merge entity", "entityID", entityID, "err", err.Error())
-					logger.Info("waiting for context broker to recover...")
-					time.Sleep(10 * time.Second)
-					continue
-				}
-				entity, err := entities.New(entityID, diwise.ExerciseTrailTypeName, attributes...)
-				if err != nil {
-					logger.Error("entities.New failed", "entityID", entityID, "err", err.Error())
-					continue
-				}
-
-				deadline, cancelDeadline := context.WithDeadline(ctx, time.Now().Add(10*time.Second))
-				_, err = ctxBrokerClient.CreateEntity(deadline, entity, headers)
-				cancelDeadline()
-
-				if err != nil {
-					logger.Error("failed to post exercise trail to context broker", "entityID", entityID, "err", err.Error())
-					continue
-				}
-			}
+			w.UpsertChanges(s.fingerprints, entityID, diwise.ExerciseTrailTypeName, attributes)
 		}
 	}
 
 	logger.Info("done processing exercise trails")
 
-	return nil
+	if err = s.deleteFeatures(ctx, w, run, features, deletions); err == nil {
+		s.reconcile(ctx, w, run, diwise.ExerciseTrailIDPrefix, index, features, inFeed)
+	}
+
+	if writeErr := w.Wait(); writeErr != nil {
+		err = writeErr
+	}
+	run.Done(err)
+
+	return err
 }
 
 func parseExerciseTrail(ctx context.Context, feature domain.Feature) (*domain.ExerciseTrail, error) {
@@ -284,49 +281,15 @@ func propertyValueMatches(field domain.FeaturePropField, expectation string) boo
 	return value == expectation || value == ("\""+expectation+"\"")
 }
 
-func entityProperties(e types.Entity) map[string]any {
-	entiyMap := map[string]any{}
-	if e != nil {
-		e.ForEachAttribute(func(attributeType, attributeName string, contents any) {
-			if attributeType == "Property" {
-				switch v := contents.(type) {
-				case *properties.DateTimeProperty:
-					entiyMap[attributeName] = v.Val.Value
-				case *properties.NumberProperty:
-					entiyMap[attributeName] = v.Val
-				case *properties.TextProperty:
-					entiyMap[attributeName] = v.Val
-				case *properties.TextListProperty:
-					entiyMap[attributeName] = v.Val
-				default:
-					// Handle other types if needed
-				}
-			}
-
-			if attributeType == "GeoProperty" {
-				switch v := contents.(type) {
-				case *geojson.GeoJSONProperty:
-					if lineString, ok := v.Val.(*geojson.GeoJSONPropertyLineString); ok {
-						entiyMap[attributeName] = lineString.Coordinates
-					}
-				default:
-					// Handle other types if needed
-				}
-			}
-		})
-	}
-	return entiyMap
-}
-
-func convertDBTrailToFiwareExerciseTrail(trail domain.ExerciseTrail, e types.Entity) []entities.EntityDecoratorFunc {
+// convertDBTrailToFiwareExerciseTrail returns the attributes of a trail. A text that is empty is
+// left out, unless written reports that the entity already has it, in which case it is cleared.
+func convertDBTrailToFiwareExerciseTrail(trail domain.ExerciseTrail, written func(attributeName string) bool) []entities.EntityDecoratorFunc {
 
 	boolMap := map[bool]string{
 		true:  "yes",
 		false: "no",
 	}
 
-	m := entityProperties(e)
-
 	attributes := append(
 		make([]entities.EntityDecoratorFunc, 0, 21),
 
@@ -336,125 +299,39 @@ func convertDBTrailToFiwareExerciseTrail(trail domain.ExerciseTrail, e types.Ent
 		Text("paymentRequired", boolMap[trail.PaymentRequired]),
 	)
 
-	shouldAppendStr := func(key string, value string) bool {
-		if v, ok := m[key]; ok { // existing value?
-			if str, ok := v.(string); ok && str == value { // same value?
-				return false
-			}
-		} else {
-			if value == "" { // new value but empty string
-				return false
-			}
-		}
-
-		return true //append it
-	}
-
-	shouldAppendNilStr := func(key string, value *string) bool {
-		if v, ok := m[key]; ok { // existing value?
-			if str, ok := v.(string); ok {
-				if value == nil && str == "" { // new value is nil and existing value is empty string, treat as same, don't append
-					return false
-				}
-				if value != nil && str == *value { // new value is not nil but existing value is same, don't append
-					return false
-				}
-			}
-		}
-		return true
-	}
-
-	approximatelyEqual := func(a, b, epsilon float64) bool {
-		v := math.Abs(a - b)
-		return v <= epsilon
-	}
-
-	shouldAppendNumber := func(key string, value float64) bool {
-		if v, ok := m[key]; ok { // existing value?
-			if num, ok := v.(float64); ok && approximatelyEqual(num, value, 1e-9) { // same value?
-				return false
-			}
-		}
-		return true
-	}
-
-	shouldAppendList := func(key string, value []string) bool {
-		if v, ok := m[key]; ok {
-			if list, ok := v.([]string); ok {
-				if len(list) == len(value) {
-					for i, v := range list {
-						if v != value[i] {
-							return true
-						}
-					}
-					return false
-				}
-			}
-		}
-		return true
-	}
-
-	shouldAppendLocation := func(key string, value [][]float64) bool {
-		if v, ok := m[key]; ok {
-			if geo, ok := v.([][]float64); ok {
-				if len(geo) == len(value) {
-					for i, v := range geo {
-						if len(v) != len(value[i]) {
-							return true
-						}
-
-						a := value[i][0]
-						b := value[i][1]
-
-						if !approximatelyEqual(v[0], a, 0.00001) || !approximatelyEqual(v[1], b, 0.00001) {
-							return true
-						}
-					}
-					return false
-				}
-			}
-		}
-
-		return true
-	}
-
-	if len(trail.Geometry.Lines) > 0 && shouldAppendLocation("location", trail.Geometry.Lines) {
+	if len(trail.Geometry.Lines) > 0 {
 		attributes = append(attributes, LocationLS(trail.Geometry.Lines))
 	}
 
-	if shouldAppendStr("name", trail.Name) {
+	if trail.Name != "" || written("name") {
 		attributes = append(attributes, Name(trail.Name))
 	}
 
-	if shouldAppendStr("description", trail.Description) {
+	if trail.Description != "" || written("description") {
 		attributes = append(attributes, Description(trail.Description))
 	}
 
-	if shouldAppendStr("areaServed", trail.AreaServed) {
+	if trail.AreaServed != "" || written("areaServed") {
 		attributes = append(attributes, Text("areaServed", trail.AreaServed))
 	}
 
-	if shouldAppendStr("source", trail.Source) {
+	if trail.Source != "" || written("source") {
 		attributes = append(attributes, Source(trail.Source))
 	}
 
-	if shouldAppendStr("status", trail.Status) {
+	if trail.Status != "" || written("status") {
 		attributes = append(attributes, Status(trail.Status))
 	}
 
-	if shouldAppendStr("publicAccess", trail.PublicAccess) {
+	if trail.PublicAccess != "" || written("publicAccess") {
 		attributes = append(attributes, Text("publicAccess", trail.PublicAccess))
 	}
 
-	if shouldAppendNilStr("annotations", trail.Annotations) {
-		annotations := ""
-
-		if trail.Annotations != nil {
-			annotations = *trail.Annotations
-		}
-
-		attributes = append(attributes, Text("annotations", annotations))
+	annotations := ""
+	if trail.Annotations != nil {
+		annotations = *trail.Annotations
 	}
+	attributes = append(attributes, Text("annotations", annotations))
 
 	if trail.ManagedBy != "" {
 		attributes = append(attributes, entities.R("managedBy", relationships.NewSingleObjectRelationship(trail.ManagedBy)))
@@ -464,28 +341,28 @@ func convertDBTrailToFiwareExerciseTrail(trail domain.ExerciseTrail, e types.Ent
 		attributes = append(attributes, entities.R("owner", relationships.NewSingleObjectRelationship(trail.Owner)))
 	}
 
-	if trail.Length > 0.1 && shouldAppendNumber("length", trail.Length) {
+	if trail.Length > 0.1 {
 		attributes = append(attributes, Number("length", trail.Length))
 	}
 
-	if trail.Width > 0.1 && shouldAppendNumber("width", math.Round(trail.Width*10)/10) {
+	if trail.Width > 0.1 {
 		attributes = append(attributes, Number("width", math.Round(trail.Width*10)/10, properties.UnitCode("CMT")))
 	}
 
-	if trail.Difficulty >= 0 && shouldAppendNumber("difficulty", math.Round(trail.Difficulty*100)/100) {
+	if trail.Difficulty >= 0 {
 		// Add difficulty rounded to one decimal
 		attributes = append(attributes, Number("difficulty", math.Round(trail.Difficulty*100)/100))
 	}
 
-	if trail.ElevationGain > 0.1 && shouldAppendNumber("elevationGain", math.Round(trail.ElevationGain*10)/10) {
+	if trail.ElevationGain > 0.1 {
 		attributes = append(attributes, Number("elevationGain", math.Round(trail.ElevationGain*10)/10, properties.UnitCode("MTR")))
 	}
 
-	if len(trail.Category) > 0 && shouldAppendList("category", trail.Category) {
+	if len(trail.Category) > 0 {
 		attributes = append(attributes, TextList("category", trail.Category))
 	}
 
-	if len(trail.SeeAlso) > 0 && shouldAppendList("seeAlso", trail.SeeAlso) {
+	if len(trail.SeeAlso) > 0 {
 		attributes = append(attributes, TextList("seeAlso", trail.SeeAlso))
 	}
 
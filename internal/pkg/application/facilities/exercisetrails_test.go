@@ -8,15 +8,20 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/diwise/context-broker/pkg/datamodels/diwise"
 	"github.com/diwise/context-broker/pkg/ngsild"
 	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
 	"github.com/diwise/context-broker/pkg/ngsild/types"
 	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
 	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
 	"github.com/matryer/is"
 )
@@ -37,7 +42,7 @@ func TestTrailDataLoad(t *testing.T) {
 	json.Unmarshal([]byte(response), &fc)
 
 	storage := NewStorage(ctx)
-	err := storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err := storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 
 	is.NoErr(err)
 	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 2)
@@ -51,21 +56,131 @@ func TestFacilitiesLoad(t *testing.T) {
 		return &ngsild.CreateEntityResult{}, nil
 	}
 
-	ctxBrokerMock.RetrieveEntityFunc = func(ctx context.Context, entityID string, headers map[string][]string) (types.Entity, error) {
-		e, err := entities.NewFromJSON([]byte(ngsi_703))
-		return e, err
-	}
-
 	fc := domain.FeatureCollection{}
 	json.Unmarshal([]byte(facilities_703), &fc)
 
 	storage := NewStorage(ctx)
-	err := storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err := storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 
 	is.NoErr(err)
 	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 1)
 }
 
+func TestThatUnchangedTrailsAreNotWrittenAgain(t *testing.T) {
+	is, ctxBrokerMock, server := testSetup(t, "", http.StatusOK, response)
+	ctx := context.Background()
+
+	ctxBrokerMock.CreateEntityFunc = func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+		return &ngsild.CreateEntityResult{}, nil
+	}
+
+	fc := features(t)
+
+	tracker := integrations.NewTracker(IntegrationName)
+	storage := NewStorage(ctx, WithTracker(tracker))
+	is.NoErr(storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc)))
+	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 2)
+	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 0) // the trails were not in the broker
+
+	created := []string{}
+	for _, call := range ctxBrokerMock.CreateEntityCalls() {
+		created = append(created, strings.TrimPrefix(call.Entity.ID(), diwise.ExerciseTrailIDPrefix+domain.SundsvallAnlaggningPrefix))
+	}
+	withEntitiesInBroker(ctxBrokerMock, diwise.ExerciseTrailTypeName, diwise.ExerciseTrailIDPrefix, created...)
+
+	ctxBrokerMock.MergeEntityFunc = func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+		return &ngsild.MergeEntityResult{}, nil
+	}
+
+	is.NoErr(storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc)))
+	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 0)
+	is.Equal(tracker.Status().EntityTypes[TypeTrails].Skipped, 2)
+
+	// a change to one attribute of one trail is merged on its own
+	fc.Features[1].Properties.Name = "Nytt namn"
+	is.NoErr(storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc)))
+	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 1)
+
+	fragment, _ := json.Marshal(ctxBrokerMock.MergeEntityCalls()[0].Fragment)
+	is.Equal(string(fragment), `{"@context":["https://raw.githubusercontent.com/diwise/context-broker/main/assets/jsonldcontexts/default-context.jsonld"],"name":{"type":"Property","value":"Nytt namn"}}`)
+}
+
+func TestThatAClearedTrailAttributeIsClearedInTheBroker(t *testing.T) {
+	is, ctxBrokerMock, server := testSetup(t, "", http.StatusOK, response)
+	ctx := context.Background()
+
+	ctxBrokerMock.CreateEntityFunc = func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+		return &ngsild.CreateEntityResult{}, nil
+	}
+
+	fc := features(t)
+
+	storage := NewStorage(ctx)
+	is.NoErr(storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc)))
+
+	created := []string{}
+	for _, call := range ctxBrokerMock.CreateEntityCalls() {
+		created = append(created, strings.TrimPrefix(call.Entity.ID(), diwise.ExerciseTrailIDPrefix+domain.SundsvallAnlaggningPrefix))
+	}
+	withEntitiesInBroker(ctxBrokerMock, diwise.ExerciseTrailTypeName, diwise.ExerciseTrailIDPrefix, created...)
+
+	ctxBrokerMock.MergeEntityFunc = func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+		return &ngsild.MergeEntityResult{}, nil
+	}
+
+	// the name is no longer set in the source, and must not be left behind in the broker
+	fc.Features[1].Properties.Name = ""
+	is.NoErr(storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc)))
+	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 1)
+
+	fragment, _ := json.Marshal(ctxBrokerMock.MergeEntityCalls()[0].Fragment)
+	is.Equal(string(fragment), `{"@context":["https://raw.githubusercontent.com/diwise/context-broker/main/assets/jsonldcontexts/default-context.jsonld"],"name":{"type":"Property","value":""}}`)
+
+	// once cleared, the name is not written again
+	is.NoErr(storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc)))
+	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 1)
+}
+
+func TestThatATextClearedBeforeAnyFingerprintsIsClearedInTheBroker(t *testing.T) {
+	is, ctxBrokerMock, server := testSetup(t, "", http.StatusOK, response)
+	ctx := context.Background()
+
+	trailID := diwise.ExerciseTrailIDPrefix + domain.SundsvallAnlaggningPrefix + "703"
+	ctxBrokerMock.QueryEntitiesFunc = func(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+		result := ngsild.NewQueryEntitiesResult()
+		go func() {
+			e, _ := entities.New(trailID, diwise.ExerciseTrailTypeName, decorators.Description("En beskrivning"))
+			result.Found <- e
+			result.Found <- nil
+		}()
+		return result, nil
+	}
+	ctxBrokerMock.CreateEntityFunc = func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+		return &ngsild.CreateEntityResult{}, nil
+	}
+	ctxBrokerMock.MergeEntityFunc = func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+		return &ngsild.MergeEntityResult{}, nil
+	}
+
+	// the description has been removed from the source since the trail was written
+	fc := features(t)
+	fields := []map[string]any{}
+	is.NoErr(json.Unmarshal(fc.Features[1].Properties.Fields, &fields))
+	fields = slices.DeleteFunc(fields, func(field map[string]any) bool { return field["id"] == float64(110) })
+	fc.Features[1].Properties.Fields, _ = json.Marshal(fields)
+
+	// a new storage has no fingerprints of what it has written before
+	storage := NewStorage(ctx)
+	is.NoErr(storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc)))
+	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 1)
+	is.Equal(ctxBrokerMock.MergeEntityCalls()[0].EntityID, trailID)
+
+	fragment, _ := json.Marshal(ctxBrokerMock.MergeEntityCalls()[0].Fragment)
+	attributes := map[string]json.RawMessage{}
+	is.NoErr(json.Unmarshal(fragment, &attributes))
+	is.Equal(string(attributes["description"]), `{"type":"Property","value":""}`)
+}
+
 func TestExerciseTrail(t *testing.T) {
 	is, ctxBrokerMock, server := testSetup(t, "", http.StatusOK, response)
 	ctx := context.Background()
@@ -74,17 +189,17 @@ func TestExerciseTrail(t *testing.T) {
 		return &ngsild.CreateEntityResult{}, nil
 	}
 
-	client := NewClient(ctx, "apiKey", server.URL)
+	client := NewClient(ctx, server.URL)
 
-	featureCollection, err := client.Get(ctx)
+	featureCollection, err := download(ctx, client)
 	is.NoErr(err)
 
 	storage := NewStorage(ctx)
-	err = storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, *featureCollection)
+	err = storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(featureCollection))
 	is.NoErr(err)
 
 	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 2)
-	e := ctxBrokerMock.CreateEntityCalls()[0].Entity
+	e := createdEntity(ctxBrokerMock, diwise.ExerciseTrailIDPrefix+domain.SundsvallAnlaggningPrefix+"703")
 	entityJSON, _ := json.Marshal(e)
 
 	const difficulty string = `"difficulty":{"type":"Property","value":0.5}`
@@ -104,17 +219,17 @@ func TestExerciseTrailContainsManagedByAndOwnerProperties(t *testing.T) {
 		return &ngsild.CreateEntityResult{}, nil
 	}
 
-	client := NewClient(ctx, "apiKey", server.URL)
+	client := NewClient(ctx, server.URL)
 
-	featureCollection, err := client.Get(ctx)
+	featureCollection, err := download(ctx, client)
 	is.NoErr(err)
 
 	storage := NewStorage(ctx)
-	err = storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, *featureCollection)
+	err = storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(featureCollection))
 	is.NoErr(err)
 
 	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 2)
-	e := ctxBrokerMock.CreateEntityCalls()[0].Entity
+	e := createdEntity(ctxBrokerMock, diwise.ExerciseTrailIDPrefix+domain.SundsvallAnlaggningPrefix+"703")
 	entityJSON, _ := json.Marshal(e)
 
 	const manager string = `"managedBy":{"type":"Relationship","object":"urn:ngsi-ld:Organisation:se:sundsvall:facilities:org:88"}`
@@ -131,21 +246,33 @@ func TestDeletedExerciseTrail(t *testing.T) {
 		return &ngsild.DeleteEntityResult{}, nil
 	}
 
-	client := NewClient(ctx, "apiKey", server.URL)
+	client := NewClient(ctx, server.URL)
 
-	featureCollection, err := client.Get(ctx)
+	featureCollection, err := download(ctx, client)
 	is.NoErr(err)
 
 	var aWeekAgo = time.Now().UTC().Add(-1 * 7 * 24 * time.Hour).Format("2006-01-02 15:04:05")
 	featureCollection.Features[1].Properties.Deleted = &aWeekAgo
+	withEntitiesInBroker(ctxBrokerMock, diwise.ExerciseTrailTypeName, diwise.ExerciseTrailIDPrefix, strconv.FormatInt(featureCollection.Features[1].ID, 10))
 
 	storage := NewStorage(ctx)
-	err = storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, *featureCollection)
+	err = storage.StoreTrailsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(featureCollection))
 
 	is.NoErr(err)
 	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
 }
 
+// createdEntity returns the entity with the given id among those that have been created,
+// since the entities of a run are created in no particular order
+func createdEntity(broker *test.ContextBrokerClientMock, entityID string) types.Entity {
+	for _, call := range broker.CreateEntityCalls() {
+		if call.Entity.ID() == entityID {
+			return call.Entity
+		}
+	}
+	return nil
+}
+
 func setupMockServiceThatReturns(is *is.I, expectedRequestBody string, responseCode int, body string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if expectedRequestBody != "" {
@@ -176,8 +303,10 @@ func testSetup(t *testing.T, requestBody string, responseCode int, responseBody
 		DeleteEntityFunc: func(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
 			return &ngsild.DeleteEntityResult{}, nil
 		},
-		RetrieveEntityFunc: func(ctx context.Context, entityID string, headers map[string][]string) (types.Entity, error) {
-			return nil, ngsierrors.ErrNotFound
+		QueryEntitiesFunc: func(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+			result := ngsild.NewQueryEntitiesResult()
+			go func() { result.Found <- nil }()
+			return result, nil
 		},
 	}
 
@@ -2649,1681 +2778,3 @@ const facilities_703 = `
         }
     ]
 }`
-
-const ngsi_703 = `
-{
-    "@context": [
-        "https://raw.githubusercontent.com/diwise/context-broker/main/assets/jsonldcontexts/default-context.jsonld"
-    ],
-    "areaServed": {
-        "type": "Property",
-        "value": "Motionsspår Södra spårområdet"
-    },
-    "category": {
-        "type": "Property",
-        "value": [
-            "floodlit",
-            "ski-classic",
-            "ski-skate"
-        ]
-    },
-    "dateCreated": {
-        "type": "Property",
-        "value": {
-            "@type": "DateTime",
-            "@value": "2019-04-05T12:39:34Z"
-        }
-    },
-    "dateLastPreparation": {
-        "type": "Property",
-        "value": {
-            "@type": "DateTime",
-            "@value": "2025-03-01T00:36:26Z"
-        }
-    },
-    "dateModified": {
-        "type": "Property",
-        "value": {
-            "@type": "DateTime",
-            "@value": "2025-01-14T12:46:58Z"
-        }
-    },
-    "description": {
-        "type": "Property",
-        "value": "Motionsspår med grusbeläggning. Bjuder på en lång och jobbig åkbacke, med skön lutning, samt vidunderlig utsikt över Sundsvalls hamn och stad."
-    },
-    "difficulty": {
-        "type": "Property",
-        "value": 0.5
-    },
-    "id": "urn:ngsi-ld:ExerciseTrail:se:sundsvall:facilities:703",
-    "length": {
-        "type": "Property",
-        "value": 4.7
-    },
-    "location": {
-        "type": "GeoProperty",
-        "value": {
-            "type": "LineString",
-            "coordinates": [
-                [
-                    17.308707,
-                    62.366359
-                ],
-                [
-                    17.308765,
-                    62.366428
-                ],
-                [
-                    17.308771,
-                    62.366531
-                ],
-                [
-                    17.308721,
-                    62.366609
-                ],
-                [
-                    17.308607,
-                    62.366663
-                ],
-                [
-                    17.308441,
-                    62.366694
-                ],
-                [
-                    17.308383,
-                    62.366694
-                ],
-                [
-                    17.306906,
-                    62.366586
-                ],
-                [
-                    17.306088,
-                    62.366397
-                ],
-                [
-                    17.305202,
-                    62.36618
-                ],
-                [
-                    17.305029,
-                    62.366122
-                ],
-                [
-                    17.305029,
-                    62.366122
-                ],
-                [
-                    17.304897,
-                    62.366023
-                ],
-                [
-                    17.304829,
-                    62.365974
-                ],
-                [
-                    17.304692,
-                    62.365921
-                ],
-                [
-                    17.304495,
-                    62.365868
-                ],
-                [
-                    17.304495,
-                    62.365868
-                ],
-                [
-                    17.304302,
-                    62.365838
-                ],
-                [
-                    17.30413,
-                    62.365807
-                ],
-                [
-                    17.304103,
-                    62.365803
-                ],
-                [
-                    17.303955,
-                    62.365777
-                ],
-                [
-                    17.303795,
-                    62.365758
-                ],
-                [
-                    17.303445,
-                    62.365722
-                ],
-                [
-                    17.303445,
-                    62.365722
-                ],
-                [
-                    17.303193,
-                    62.365681
-                ],
-                [
-                    17.303048,
-                    62.365625
-                ],
-                [
-                    17.302889,
-                    62.365539
-                ],
-                [
-                    17.302652,
-                    62.365347
-                ],
-                [
-                    17.302417,
-                    62.365164
-                ],
-                [
-                    17.302352,
-                    62.365114
-                ],
-                [
-                    17.302342,
-                    62.36508
-                ],
-                [
-                    17.302342,
-                    62.36508
-                ],
-                [
-                    17.302349,
-                    62.36497
-                ],
-                [
-                    17.302389,
-                    62.364906
-                ],
-                [
-                    17.302561,
-                    62.36475
-                ],
-                [
-                    17.302561,
-                    62.36475
-                ],
-                [
-                    17.302733,
-                    62.364548
-                ],
-                [
-                    17.302854,
-                    62.364399
-                ],
-                [
-                    17.302945,
-                    62.364329
-                ],
-                [
-                    17.303098,
-                    62.364281
-                ],
-                [
-                    17.303098,
-                    62.364281
-                ],
-                [
-                    17.303135,
-                    62.364279
-                ],
-                [
-                    17.303293,
-                    62.364284
-                ],
-                [
-                    17.303298,
-                    62.364287
-                ],
-                [
-                    17.303378,
-                    62.364292
-                ],
-                [
-                    17.303383,
-                    62.364293
-                ],
-                [
-                    17.303734,
-                    62.364326
-                ],
-                [
-                    17.304053,
-                    62.364341
-                ],
-                [
-                    17.30415,
-                    62.36434
-                ],
-                [
-                    17.30429,
-                    62.36434
-                ],
-                [
-                    17.30429,
-                    62.36434
-                ],
-                [
-                    17.304628,
-                    62.364275
-                ],
-                [
-                    17.304857,
-                    62.364188
-                ],
-                [
-                    17.305139,
-                    62.364017
-                ],
-                [
-                    17.305383,
-                    62.363909
-                ],
-                [
-                    17.305722,
-                    62.363846
-                ],
-                [
-                    17.306123,
-                    62.363809
-                ],
-                [
-                    17.306329,
-                    62.363781
-                ],
-                [
-                    17.306483,
-                    62.363745
-                ],
-                [
-                    17.306633,
-                    62.363702
-                ],
-                [
-                    17.306963,
-                    62.363674
-                ],
-                [
-                    17.307091,
-                    62.363669
-                ],
-                [
-                    17.307222,
-                    62.363672
-                ],
-                [
-                    17.307222,
-                    62.363672
-                ],
-                [
-                    17.307413,
-                    62.363704
-                ],
-                [
-                    17.307598,
-                    62.363756
-                ],
-                [
-                    17.307867,
-                    62.363875
-                ],
-                [
-                    17.308149,
-                    62.364069
-                ],
-                [
-                    17.308573,
-                    62.364225
-                ],
-                [
-                    17.309017,
-                    62.364389
-                ],
-                [
-                    17.309127,
-                    62.36444
-                ],
-                [
-                    17.309127,
-                    62.36444
-                ],
-                [
-                    17.309303,
-                    62.364476
-                ],
-                [
-                    17.309508,
-                    62.364515
-                ],
-                [
-                    17.309586,
-                    62.364525
-                ],
-                [
-                    17.309784,
-                    62.364521
-                ],
-                [
-                    17.309901,
-                    62.364512
-                ],
-                [
-                    17.310031,
-                    62.364491
-                ],
-                [
-                    17.310079,
-                    62.364484
-                ],
-                [
-                    17.310178,
-                    62.364425
-                ],
-                [
-                    17.310328,
-                    62.364368
-                ],
-                [
-                    17.310503,
-                    62.364334
-                ],
-                [
-                    17.3107,
-                    62.364347
-                ],
-                [
-                    17.310827,
-                    62.364369
-                ],
-                [
-                    17.310943,
-                    62.36442
-                ],
-                [
-                    17.311171,
-                    62.364536
-                ],
-                [
-                    17.311252,
-                    62.364583
-                ],
-                [
-                    17.311252,
-                    62.364583
-                ],
-                [
-                    17.311431,
-                    62.364661
-                ],
-                [
-                    17.311675,
-                    62.364754
-                ],
-                [
-                    17.311675,
-                    62.364754
-                ],
-                [
-                    17.312829,
-                    62.36449
-                ],
-                [
-                    17.313016,
-                    62.364463
-                ],
-                [
-                    17.313233,
-                    62.36444
-                ],
-                [
-                    17.313536,
-                    62.364373
-                ],
-                [
-                    17.313916,
-                    62.36426
-                ],
-                [
-                    17.313916,
-                    62.36426
-                ],
-                [
-                    17.313999,
-                    62.364251
-                ],
-                [
-                    17.314165,
-                    62.364256
-                ],
-                [
-                    17.31426,
-                    62.364276
-                ],
-                [
-                    17.314249,
-                    62.364256
-                ],
-                [
-                    17.314332,
-                    62.364256
-                ],
-                [
-                    17.314441,
-                    62.364244
-                ],
-                [
-                    17.314547,
-                    62.364223
-                ],
-                [
-                    17.314645,
-                    62.364198
-                ],
-                [
-                    17.314724,
-                    62.364151
-                ],
-                [
-                    17.314855,
-                    62.364057
-                ],
-                [
-                    17.314986,
-                    62.364016
-                ],
-                [
-                    17.315123,
-                    62.364007
-                ],
-                [
-                    17.315285,
-                    62.364006
-                ],
-                [
-                    17.315377,
-                    62.364011
-                ],
-                [
-                    17.315483,
-                    62.364008
-                ],
-                [
-                    17.315565,
-                    62.363993
-                ],
-                [
-                    17.315626,
-                    62.363975
-                ],
-                [
-                    17.315626,
-                    62.363975
-                ],
-                [
-                    17.315608,
-                    62.363877
-                ],
-                [
-                    17.315678,
-                    62.363779
-                ],
-                [
-                    17.315798,
-                    62.36372
-                ],
-                [
-                    17.316067,
-                    62.363642
-                ],
-                [
-                    17.316373,
-                    62.363576
-                ],
-                [
-                    17.31675,
-                    62.363463
-                ],
-                [
-                    17.316907,
-                    62.363386
-                ],
-                [
-                    17.317001,
-                    62.363282
-                ],
-                [
-                    17.317132,
-                    62.363183
-                ],
-                [
-                    17.317277,
-                    62.363106
-                ],
-                [
-                    17.317556,
-                    62.363011
-                ],
-                [
-                    17.317628,
-                    62.362965
-                ],
-                [
-                    17.317686,
-                    62.362878
-                ],
-                [
-                    17.317681,
-                    62.362786
-                ],
-                [
-                    17.317601,
-                    62.362678
-                ],
-                [
-                    17.317547,
-                    62.362575
-                ],
-                [
-                    17.317528,
-                    62.362443
-                ],
-                [
-                    17.31757,
-                    62.362299
-                ],
-                [
-                    17.317629,
-                    62.362246
-                ],
-                [
-                    17.317798,
-                    62.362158
-                ],
-                [
-                    17.318115,
-                    62.362069
-                ],
-                [
-                    17.318406,
-                    62.361951
-                ],
-                [
-                    17.318758,
-                    62.361815
-                ],
-                [
-                    17.318878,
-                    62.361739
-                ],
-                [
-                    17.319035,
-                    62.361668
-                ],
-                [
-                    17.319498,
-                    62.361536
-                ],
-                [
-                    17.319975,
-                    62.36139
-                ],
-                [
-                    17.320108,
-                    62.361357
-                ],
-                [
-                    17.320709,
-                    62.361259
-                ],
-                [
-                    17.321027,
-                    62.361198
-                ],
-                [
-                    17.32121,
-                    62.361144
-                ],
-                [
-                    17.321635,
-                    62.36099
-                ],
-                [
-                    17.321818,
-                    62.360942
-                ],
-                [
-                    17.321818,
-                    62.360942
-                ],
-                [
-                    17.321927,
-                    62.361024
-                ],
-                [
-                    17.321927,
-                    62.361024
-                ],
-                [
-                    17.321985,
-                    62.361055
-                ],
-                [
-                    17.322239,
-                    62.361185
-                ],
-                [
-                    17.322582,
-                    62.361359
-                ],
-                [
-                    17.32286,
-                    62.361477
-                ],
-                [
-                    17.323027,
-                    62.361607
-                ],
-                [
-                    17.323297,
-                    62.362007
-                ],
-                [
-                    17.323428,
-                    62.3622
-                ],
-                [
-                    17.323561,
-                    62.362334
-                ],
-                [
-                    17.323666,
-                    62.362497
-                ],
-                [
-                    17.323967,
-                    62.362742
-                ],
-                [
-                    17.324298,
-                    62.363013
-                ],
-                [
-                    17.324468,
-                    62.363189
-                ],
-                [
-                    17.324548,
-                    62.363309
-                ],
-                [
-                    17.324592,
-                    62.363446
-                ],
-                [
-                    17.324547,
-                    62.363533
-                ],
-                [
-                    17.324483,
-                    62.363586
-                ],
-                [
-                    17.324395,
-                    62.363662
-                ],
-                [
-                    17.324328,
-                    62.363709
-                ],
-                [
-                    17.324274,
-                    62.363748
-                ],
-                [
-                    17.324253,
-                    62.363823
-                ],
-                [
-                    17.324306,
-                    62.363903
-                ],
-                [
-                    17.32441,
-                    62.364
-                ],
-                [
-                    17.324502,
-                    62.364102
-                ],
-                [
-                    17.324533,
-                    62.364217
-                ],
-                [
-                    17.324528,
-                    62.364372
-                ],
-                [
-                    17.324504,
-                    62.364497
-                ],
-                [
-                    17.324504,
-                    62.364497
-                ],
-                [
-                    17.324483,
-                    62.364659
-                ],
-                [
-                    17.32461,
-                    62.36478
-                ],
-                [
-                    17.324825,
-                    62.364889
-                ],
-                [
-                    17.325032,
-                    62.364977
-                ],
-                [
-                    17.325136,
-                    62.365055
-                ],
-                [
-                    17.32516,
-                    62.36515
-                ],
-                [
-                    17.325209,
-                    62.365434
-                ],
-                [
-                    17.325289,
-                    62.365563
-                ],
-                [
-                    17.325323,
-                    62.365656
-                ],
-                [
-                    17.32531,
-                    62.365766
-                ],
-                [
-                    17.325284,
-                    62.365825
-                ],
-                [
-                    17.325226,
-                    62.365863
-                ],
-                [
-                    17.325151,
-                    62.365875
-                ],
-                [
-                    17.324998,
-                    62.36586
-                ],
-                [
-                    17.32485,
-                    62.365842
-                ],
-                [
-                    17.324691,
-                    62.365812
-                ],
-                [
-                    17.324406,
-                    62.365761
-                ],
-                [
-                    17.324048,
-                    62.36575
-                ],
-                [
-                    17.323683,
-                    62.365794
-                ],
-                [
-                    17.323472,
-                    62.36583
-                ],
-                [
-                    17.323295,
-                    62.365858
-                ],
-                [
-                    17.323217,
-                    62.365884
-                ],
-                [
-                    17.323149,
-                    62.365931
-                ],
-                [
-                    17.323096,
-                    62.365971
-                ],
-                [
-                    17.323069,
-                    62.366076
-                ],
-                [
-                    17.32306,
-                    62.36613
-                ],
-                [
-                    17.323102,
-                    62.366188
-                ],
-                [
-                    17.323179,
-                    62.366234
-                ],
-                [
-                    17.323227,
-                    62.366255
-                ],
-                [
-                    17.323344,
-                    62.36627
-                ],
-                [
-                    17.323518,
-                    62.366285
-                ],
-                [
-                    17.323652,
-                    62.366288
-                ],
-                [
-                    17.323816,
-                    62.3663
-                ],
-                [
-                    17.323981,
-                    62.366319
-                ],
-                [
-                    17.324171,
-                    62.366355
-                ],
-                [
-                    17.324348,
-                    62.366405
-                ],
-                [
-                    17.324556,
-                    62.36647
-                ],
-                [
-                    17.324657,
-                    62.366493
-                ],
-                [
-                    17.324823,
-                    62.366522
-                ],
-                [
-                    17.32502,
-                    62.366546
-                ],
-                [
-                    17.325219,
-                    62.366593
-                ],
-                [
-                    17.325222,
-                    62.366594
-                ],
-                [
-                    17.325373,
-                    62.366633
-                ],
-                [
-                    17.325512,
-                    62.3667
-                ],
-                [
-                    17.325615,
-                    62.366774
-                ],
-                [
-                    17.325663,
-                    62.366938
-                ],
-                [
-                    17.325697,
-                    62.367169
-                ],
-                [
-                    17.325758,
-                    62.367399
-                ],
-                [
-                    17.325763,
-                    62.367508
-                ],
-                [
-                    17.325694,
-                    62.367595
-                ],
-                [
-                    17.325609,
-                    62.367636
-                ],
-                [
-                    17.32545,
-                    62.367678
-                ],
-                [
-                    17.325291,
-                    62.367702
-                ],
-                [
-                    17.325107,
-                    62.367745
-                ],
-                [
-                    17.32495,
-                    62.367804
-                ],
-                [
-                    17.324758,
-                    62.367927
-                ],
-                [
-                    17.324565,
-                    62.368026
-                ],
-                [
-                    17.32447,
-                    62.368102
-                ],
-                [
-                    17.324424,
-                    62.368172
-                ],
-                [
-                    17.324163,
-                    62.368399
-                ],
-                [
-                    17.32401,
-                    62.368555
-                ],
-                [
-                    17.323854,
-                    62.368643
-                ],
-                [
-                    17.323695,
-                    62.368685
-                ],
-                [
-                    17.323571,
-                    62.368687
-                ],
-                [
-                    17.323441,
-                    62.368682
-                ],
-                [
-                    17.323308,
-                    62.36862
-                ],
-                [
-                    17.323208,
-                    62.368497
-                ],
-                [
-                    17.323069,
-                    62.36829
-                ],
-                [
-                    17.323069,
-                    62.36829
-                ],
-                [
-                    17.322989,
-                    62.36817
-                ],
-                [
-                    17.322925,
-                    62.368136
-                ],
-                [
-                    17.322798,
-                    62.36808
-                ],
-                [
-                    17.322746,
-                    62.368023
-                ],
-                [
-                    17.322689,
-                    62.36788
-                ],
-                [
-                    17.322473,
-                    62.367768
-                ],
-                [
-                    17.322273,
-                    62.367724
-                ],
-                [
-                    17.322099,
-                    62.367708
-                ],
-                [
-                    17.321948,
-                    62.367703
-                ],
-                [
-                    17.321755,
-                    62.367636
-                ],
-                [
-                    17.32175,
-                    62.367634
-                ],
-                [
-                    17.321565,
-                    62.367561
-                ],
-                [
-                    17.32149,
-                    62.367504
-                ],
-                [
-                    17.321338,
-                    62.367428
-                ],
-                [
-                    17.321256,
-                    62.367349
-                ],
-                [
-                    17.321229,
-                    62.367302
-                ],
-                [
-                    17.321219,
-                    62.367227
-                ],
-                [
-                    17.321199,
-                    62.367157
-                ],
-                [
-                    17.321122,
-                    62.367073
-                ],
-                [
-                    17.32106,
-                    62.367026
-                ],
-                [
-                    17.320988,
-                    62.366981
-                ],
-                [
-                    17.32084,
-                    62.366932
-                ],
-                [
-                    17.320657,
-                    62.366863
-                ],
-                [
-                    17.320544,
-                    62.366815
-                ],
-                [
-                    17.320384,
-                    62.36674
-                ],
-                [
-                    17.320305,
-                    62.366713
-                ],
-                [
-                    17.320213,
-                    62.366688
-                ],
-                [
-                    17.320134,
-                    62.366655
-                ],
-                [
-                    17.320069,
-                    62.366619
-                ],
-                [
-                    17.319911,
-                    62.366509
-                ],
-                [
-                    17.319749,
-                    62.366391
-                ],
-                [
-                    17.31932,
-                    62.366111
-                ],
-                [
-                    17.31925,
-                    62.366081
-                ],
-                [
-                    17.319172,
-                    62.366055
-                ],
-                [
-                    17.319031,
-                    62.366021
-                ],
-                [
-                    17.318937,
-                    62.366001
-                ],
-                [
-                    17.31886,
-                    62.365975
-                ],
-                [
-                    17.318654,
-                    62.365883
-                ],
-                [
-                    17.318551,
-                    62.365854
-                ],
-                [
-                    17.318439,
-                    62.365833
-                ],
-                [
-                    17.318207,
-                    62.365804
-                ],
-                [
-                    17.31811,
-                    62.365781
-                ],
-                [
-                    17.317965,
-                    62.365717
-                ],
-                [
-                    17.31782,
-                    62.365662
-                ],
-                [
-                    17.317659,
-                    62.36563
-                ],
-                [
-                    17.317488,
-                    62.36562
-                ],
-                [
-                    17.317311,
-                    62.365615
-                ],
-                [
-                    17.317132,
-                    62.365632
-                ],
-                [
-                    17.317132,
-                    62.365632
-                ],
-                [
-                    17.317048,
-                    62.365642
-                ],
-                [
-                    17.316849,
-                    62.365677
-                ],
-                [
-                    17.316597,
-                    62.365709
-                ],
-                [
-                    17.316227,
-                    62.365746
-                ],
-                [
-                    17.316098,
-                    62.365759
-                ],
-                [
-                    17.315964,
-                    62.365784
-                ],
-                [
-                    17.315835,
-                    62.365829
-                ],
-                [
-                    17.315685,
-                    62.365908
-                ],
-                [
-                    17.315465,
-                    62.365983
-                ],
-                [
-                    17.315215,
-                    62.36608
-                ],
-                [
-                    17.315069,
-                    62.366138
-                ],
-                [
-                    17.314928,
-                    62.366211
-                ],
-                [
-                    17.314601,
-                    62.366385
-                ],
-                [
-                    17.314454,
-                    62.366462
-                ],
-                [
-                    17.314336,
-                    62.366487
-                ],
-                [
-                    17.314246,
-                    62.3665
-                ],
-                [
-                    17.314246,
-                    62.3665
-                ],
-                [
-                    17.314175,
-                    62.366511
-                ],
-                [
-                    17.314033,
-                    62.366537
-                ],
-                [
-                    17.313836,
-                    62.366621
-                ],
-                [
-                    17.313745,
-                    62.36665
-                ],
-                [
-                    17.313679,
-                    62.366659
-                ],
-                [
-                    17.313617,
-                    62.366658
-                ],
-                [
-                    17.313293,
-                    62.366644
-                ],
-                [
-                    17.31326,
-                    62.366638
-                ],
-                [
-                    17.313283,
-                    62.366571
-                ],
-                [
-                    17.313283,
-                    62.366571
-                ],
-                [
-                    17.313167,
-                    62.366448
-                ],
-                [
-                    17.313113,
-                    62.366404
-                ],
-                [
-                    17.313029,
-                    62.36636
-                ],
-                [
-                    17.312897,
-                    62.366305
-                ],
-                [
-                    17.312897,
-                    62.366305
-                ],
-                [
-                    17.31264,
-                    62.366369
-                ],
-                [
-                    17.312549,
-                    62.36637
-                ],
-                [
-                    17.312549,
-                    62.36637
-                ],
-                [
-                    17.31219,
-                    62.36628
-                ],
-                [
-                    17.31219,
-                    62.36628
-                ],
-                [
-                    17.311702,
-                    62.36625
-                ],
-                [
-                    17.311415,
-                    62.366215
-                ],
-                [
-                    17.311298,
-                    62.366168
-                ],
-                [
-                    17.311196,
-                    62.366051
-                ],
-                [
-                    17.310908,
-                    62.365942
-                ],
-                [
-                    17.310908,
-                    62.365942
-                ],
-                [
-                    17.310824,
-                    62.365841
-                ],
-                [
-                    17.310403,
-                    62.365582
-                ],
-                [
-                    17.310403,
-                    62.365582
-                ],
-                [
-                    17.310208,
-                    62.36554
-                ],
-                [
-                    17.309706,
-                    62.365475
-                ],
-                [
-                    17.309568,
-                    62.365478
-                ],
-                [
-                    17.309439,
-                    62.365512
-                ],
-                [
-                    17.309419,
-                    62.36552
-                ],
-                [
-                    17.309212,
-                    62.365606
-                ],
-                [
-                    17.309156,
-                    62.365623
-                ],
-                [
-                    17.309004,
-                    62.365673
-                ],
-                [
-                    17.308851,
-                    62.365698
-                ],
-                [
-                    17.308565,
-                    62.365697
-                ],
-                [
-                    17.308227,
-                    62.365765
-                ],
-                [
-                    17.308096,
-                    62.365785
-                ],
-                [
-                    17.307871,
-                    62.365787
-                ],
-                [
-                    17.307426,
-                    62.365709
-                ],
-                [
-                    17.306837,
-                    62.3656
-                ],
-                [
-                    17.306656,
-                    62.365526
-                ],
-                [
-                    17.306525,
-                    62.365382
-                ],
-                [
-                    17.306373,
-                    62.36522
-                ],
-                [
-                    17.306323,
-                    62.365167
-                ],
-                [
-                    17.306323,
-                    62.365167
-                ],
-                [
-                    17.306266,
-                    62.365035
-                ],
-                [
-                    17.306237,
-                    62.364836
-                ],
-                [
-                    17.306223,
-                    62.364737
-                ],
-                [
-                    17.306152,
-                    62.364612
-                ],
-                [
-                    17.306019,
-                    62.364515
-                ],
-                [
-                    17.305796,
-                    62.36443
-                ],
-                [
-                    17.305517,
-                    62.3644
-                ],
-                [
-                    17.305211,
-                    62.364421
-                ],
-                [
-                    17.3049,
-                    62.364484
-                ],
-                [
-                    17.304246,
-                    62.364575
-                ],
-                [
-                    17.304246,
-                    62.364575
-                ],
-                [
-                    17.303961,
-                    62.364617
-                ],
-                [
-                    17.303745,
-                    62.364664
-                ],
-                [
-                    17.303574,
-                    62.364717
-                ],
-                [
-                    17.303502,
-                    62.364745
-                ],
-                [
-                    17.303446,
-                    62.364804
-                ],
-                [
-                    17.303445,
-                    62.364809
-                ],
-                [
-                    17.303441,
-                    62.364884
-                ],
-                [
-                    17.303458,
-                    62.36506
-                ],
-                [
-                    17.303504,
-                    62.365172
-                ],
-                [
-                    17.303635,
-                    62.365288
-                ],
-                [
-                    17.304366,
-                    62.365658
-                ],
-                [
-                    17.30484,
-                    62.365838
-                ],
-                [
-                    17.305007,
-                    62.365893
-                ],
-                [
-                    17.305228,
-                    62.365953
-                ],
-                [
-                    17.305228,
-                    62.365953
-                ],
-                [
-                    17.305378,
-                    62.365992
-                ],
-                [
-                    17.305508,
-                    62.366025
-                ],
-                [
-                    17.305609,
-                    62.366038
-                ],
-                [
-                    17.305609,
-                    62.366038
-                ],
-                [
-                    17.306497,
-                    62.366135
-                ],
-                [
-                    17.307302,
-                    62.366214
-                ],
-                [
-                    17.307726,
-                    62.366252
-                ],
-                [
-                    17.308231,
-                    62.366291
-                ],
-                [
-                    17.308619,
-                    62.366329
-                ],
-                [
-                    17.308707,
-                    62.366359
-                ]
-            ]
-        }
-    },
-    "managedBy": {
-        "type": "Relationship",
-        "object": "urn:ngsi-ld:Organisation:se:sundsvall:facilities:org:36"
-    },
-    "name": {
-        "type": "Property",
-        "value": "Hotellslingan 5 km"
-    },
-    "owner": {
-        "type": "Relationship",
-        "object": "urn:ngsi-ld:Organisation:se:sundsvall:facilities:org:36"
-    },
-    "paymentRequired": {
-        "type": "Property",
-        "value": "yes"
-    },
-    "source": {
-        "type": "Property",
-        "value": "https://api.sundsvall.se/facilities/2.1/get/703"
-    },
-    "status": {
-        "type": "Property",
-        "value": "closed"
-    },
-    "type": "ExerciseTrail"
-}`
@@ -2,11 +2,16 @@ package facilities
 
 import (
 	"context"
+	"encoding/json"
+	"iter"
 	"log/slog"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 )
@@ -14,22 +19,181 @@ import (
 const timeFormat string = "2006-01-02 15:04:05"
 
 type Storage interface {
-	StoreBeachesFromSource(context.Context, client.ContextBrokerClient, string, domain.FeatureCollection) error
-	StoreSportsFieldsFromSource(context.Context, client.ContextBrokerClient, string, domain.FeatureCollection) error
-	StoreSportsVenuesFromSource(context.Context, client.ContextBrokerClient, string, domain.FeatureCollection) error
-	StoreTrailsFromSource(context.Context, client.ContextBrokerClient, string, domain.FeatureCollection) error
+	StoreBeachesFromSource(context.Context, client.ContextBrokerClient, string, *Feed) error
+	StoreSportsFieldsFromSource(context.Context, client.ContextBrokerClient, string, *Feed) error
+	StoreSportsVenuesFromSource(context.Context, client.ContextBrokerClient, string, *Feed) error
+	StoreTrailsFromSource(context.Context, client.ContextBrokerClient, string, *Feed) error
+	// Unsettled returns the features whose deletion has not been carried out since it was last
+	// called, so that they can be retrieved again even if they have not changed at the source
+	Unsettled() []int64
+}
+
+// feedBuffer is the number of features that may wait for a pipeline that is busy writing
+const feedBuffer int = 16
+
+// Feed hands the features of a download from the facilities source to a pipeline one at a
+// time, as soon as they have been decoded
+type Feed struct {
+	features chan domain.Feature
+	// complete and err are set before features is closed
+	complete bool
+	err      error
+
+	// stopped is closed once the pipeline no longer reads the feed
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newFeed() *Feed {
+	return &Feed{features: make(chan domain.Feature, feedBuffer), stopped: make(chan struct{})}
+}
+
+// send hands a feature to the pipeline, and waits while the pipeline is busy. A feature is
+// dropped if the pipeline has stopped reading the feed, and the wait ends with an error if
+// the context is cancelled.
+func (f *Feed) send(ctx context.Context, feature domain.Feature) error {
+	select {
+	case f.features <- feature:
+		return nil
+	case <-f.stopped:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// stop tells the download that the pipeline no longer reads the feed. A pipeline must stop
+// its feed when it returns, whether it has read every feature or not.
+func (f *Feed) stop() {
+	f.stopOnce.Do(func() { close(f.stopped) })
+}
+
+// end tells the pipeline that the download is over, and whether it failed or held every
+// feature of the source
+func (f *Feed) end(complete bool, err error) {
+	f.complete, f.err = complete, err
+	close(f.features)
+}
+
+// All returns the features of the feed as they arrive. A pipeline that stops early stops the
+// feed, so that the rest of it is discarded without holding up the download.
+func (f *Feed) All() iter.Seq[domain.Feature] {
+	return func(yield func(domain.Feature) bool) {
+		for feature := range f.features {
+			if !yield(feature) {
+				f.stop()
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error that ended the download early, once all features have arrived
+func (f *Feed) Err() error {
+	return f.err
+}
+
+// Complete tells, once all features have arrived, if the feed held every feature of the
+// source rather than only those that have changed
+func (f *Feed) Complete() bool {
+	return f.complete
 }
 
 type storageImpl struct {
-	deleted map[int64]time.Time
-	m       sync.Mutex
+	deleted   map[int64]time.Time
+	unsettled map[int64]bool
+	m         sync.Mutex
+
+	tracker     *integrations.Tracker
+	seeAlsoRefs map[int64]extraInfo
+	workers     int
+	orphans     OrphanPolicy
+	safeguard   *integrations.Safeguard
+
+	fingerprints *integrations.Fingerprints
+}
+
+// WithTracker makes the storage report the outcome of its runs to the supplied tracker
+func WithTracker(tracker *integrations.Tracker) func(*storageImpl) {
+	return func(s *storageImpl) {
+		s.tracker = tracker
+	}
+}
+
+// withSeeAlsoRefs replaces the built in references to other sources of information about beaches
+func withSeeAlsoRefs(refs map[int64]extraInfo) func(*storageImpl) {
+	return func(s *storageImpl) {
+		s.seeAlsoRefs = refs
+	}
+}
+
+// WithWorkers sets the number of concurrent requests that are sent to the broker per entity type
+func WithWorkers(workers int) func(*storageImpl) {
+	return func(s *storageImpl) {
+		s.workers = workers
+	}
+}
+
+// WithSafeguard makes the storage hold back deletions until the safeguard confirms them
+func WithSafeguard(g *integrations.Safeguard) func(*storageImpl) {
+	return func(s *storageImpl) {
+		s.safeguard = g
+	}
+}
+
+func NewStorage(ctx context.Context, options ...func(*storageImpl)) Storage {
+	s := &storageImpl{
+		deleted:     make(map[int64]time.Time),
+		unsettled:   make(map[int64]bool),
+		m:           sync.Mutex{},
+		tracker:     integrations.NewTracker(IntegrationName),
+		seeAlsoRefs: seeAlsoRefs,
+		workers:     integrations.DefaultWorkers,
+		orphans:     OrphansIgnore,
+
+		fingerprints: integrations.NewFingerprints(integrations.DefaultFingerprintAge),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// storageState is the part of the storage that is persisted between restarts
+type storageState struct {
+	// Deleted holds the features that have been deleted from the context broker,
+	// and when they were deleted or unpublished at the source
+	Deleted map[int64]time.Time `json:"deleted"`
+	// Safeguard holds the recent feature counts and the deletions that are not yet confirmed
+	Safeguard *integrations.Safeguard `json:"safeguard,omitempty"`
+	// Fingerprints tell which entities and attributes have not changed since they were written
+	Fingerprints *integrations.Fingerprints `json:"fingerprints,omitempty"`
+}
+
+func (s *storageImpl) MarshalState() ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return json.Marshal(storageState{Deleted: s.deleted, Safeguard: s.safeguard, Fingerprints: s.fingerprints})
 }
 
-func NewStorage(ctx context.Context) Storage {
-	return &storageImpl{
-		deleted: make(map[int64]time.Time),
-		m:       sync.Mutex{},
+func (s *storageImpl) UnmarshalState(data []byte) error {
+	// a saved safeguard is restored into the configured one, and dropped if there is none
+	st := storageState{Safeguard: s.safeguard, Fingerprints: s.fingerprints}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if st.Deleted != nil {
+		s.deleted = st.Deleted
 	}
+
+	return nil
 }
 
 // shouldBeDeleted maintains a cache of deleted features so that we do not
@@ -89,3 +253,62 @@ func (s *storageImpl) shouldBeDeleted(ctx context.Context, feature domain.Featur
 
 	return
 }
+
+// deletion is the entity of a deleted or unpublished feature
+type deletion struct {
+	featureID int64
+	entityID  string
+}
+
+// deleteFeatures deletes the entities of the deleted or unpublished features in a feed, once
+// all of its features have arrived and the safeguard knows the size of the feed. If the
+// download or a write failed, nothing is deleted and the error is returned.
+func (s *storageImpl) deleteFeatures(ctx context.Context, w *integrations.Writer, run *integrations.EntityRun, feed *Feed, deletions []deletion) error {
+	// a pipeline that stopped because of a failed write has not seen the end of the feed
+	err := w.Err()
+	if err == nil {
+		err = feed.Err()
+	}
+
+	for _, d := range deletions {
+		if err == nil && s.confirmDelete(ctx, run, d.entityID) {
+			s.fingerprints.Forget(d.entityID)
+			w.Delete(d.entityID)
+
+			s.m.Lock()
+			delete(s.unsettled, d.featureID)
+			s.m.Unlock()
+			continue
+		}
+
+		// a deletion that did not happen is considered again in the next run
+		s.m.Lock()
+		delete(s.deleted, d.featureID)
+		s.unsettled[d.featureID] = true
+		s.m.Unlock()
+	}
+
+	return err
+}
+
+func (s *storageImpl) Unsettled() []int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	featureIDs := slices.Sorted(maps.Keys(s.unsettled))
+	clear(s.unsettled)
+
+	return featureIDs
+}
+
+// confirmDelete tells if an entity may be deleted from the context broker during this run
+func (s *storageImpl) confirmDelete(ctx context.Context, run *integrations.EntityRun, entityID string) bool {
+	if s.safeguard.ConfirmDelete(run.EntityType(), entityID) {
+		return true
+	}
+
+	logging.GetFromContext(ctx).Warn("holding back deletion until it has been confirmed", "entityID", entityID)
+	run.Held()
+
+	return false
+}
@@ -2,6 +2,8 @@ package facilities
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"testing"
 	"time"
 
@@ -35,3 +37,72 @@ func TestShouldBeDeleted(t *testing.T) {
 	is.True(ok)
 	is.True(alreadyDeleted)
 }
+
+func TestThatDeletedFeaturesAreRememberedAcrossRestarts(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	var aWeekAgo = time.Now().UTC().Add(-1 * 7 * 24 * time.Hour).Format("2006-01-02 15:04:05")
+	f := domain.Feature{ID: 1, Properties: domain.FeatureProps{Deleted: &aWeekAgo}}
+
+	before := NewStorage(ctx).(*storageImpl)
+	_, alreadyDeleted := before.shouldBeDeleted(ctx, f)
+	is.True(!alreadyDeleted)
+
+	data, err := before.MarshalState()
+	is.NoErr(err)
+
+	after := NewStorage(ctx).(*storageImpl)
+	is.NoErr(after.UnmarshalState(data))
+
+	_, alreadyDeleted = after.shouldBeDeleted(ctx, f)
+	is.True(alreadyDeleted)
+}
+
+func TestThatNothingIsDeletedWhenTheDownloadFails(t *testing.T) {
+	is, ctxBrokerMock, _ := testSetup(t, "", http.StatusOK, response)
+	withBeachesInBroker(ctxBrokerMock, "1545", "1234")
+	ctx := context.Background()
+
+	fc := features(t)
+	var aWeekAgo = time.Now().UTC().Add(-1 * 7 * 24 * time.Hour).Format("2006-01-02 15:04:05")
+	fc.Features[0].Properties.Deleted = &aWeekAgo
+
+	feed := newFeed()
+	for _, feature := range fc.Features {
+		feed.send(context.Background(), feature)
+	}
+	feed.end(false, errors.New("connection reset"))
+
+	storage := NewStorage(ctx, WithOrphanPolicy(OrphansDelete)).(*storageImpl)
+	err := storage.StoreBeachesFromSource(ctx, ctxBrokerMock, "", feed)
+
+	is.True(err != nil)
+	// neither the deleted beach nor the orphaned one
+	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 0)
+	// the deleted beach is considered again in the next run
+	is.Equal(len(storage.deleted), 0)
+}
+
+// feedOf returns a complete feed of the features in a collection
+func feedOf(fc domain.FeatureCollection) *Feed {
+	feed := &Feed{features: make(chan domain.Feature, len(fc.Features)), stopped: make(chan struct{})}
+	for _, feature := range fc.Features {
+		feed.send(context.Background(), feature)
+	}
+	feed.end(true, nil)
+
+	return feed
+}
+
+// download collects the facilities that a client hands over into a collection
+func download(ctx context.Context, c Client) (domain.FeatureCollection, error) {
+	fc := domain.FeatureCollection{Type: "FeatureCollection"}
+
+	_, err := c.Get(ctx, func(feature domain.Feature) error {
+		fc.Features = append(fc.Features, feature)
+		return nil
+	})
+
+	return fc, err
+}
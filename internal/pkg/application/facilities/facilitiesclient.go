@@ -3,12 +3,19 @@ package facilities
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/auth"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/geojson"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/retry"
 
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
@@ -18,65 +25,373 @@ import (
 
 var sdltracer = otel.Tracer("facilities-client")
 
+// ErrNotModified is returned by Get when the facilities have not changed since the
+// collection that was last marked as processed
+var ErrNotModified = errors.New("facilities information has not been modified")
+
 type Client interface {
-	Get(ctx context.Context) (*domain.FeatureCollection, error)
+	// Get hands the facilities to feature one at a time as they are downloaded, or returns
+	// ErrNotModified if they have not changed
+	Get(ctx context.Context, feature func(domain.Feature) error) (Download, error)
+	// Processed tells the client that the collection it returned last has been stored in
+	// the broker, so that an unchanged collection can be skipped from now on. The unsettled
+	// facilities are retrieved again by the next incremental download, changed or not.
+	Processed(unsettled ...int64)
+}
+
+// Download tells what a call to Get has handed over
+type Download struct {
+	// Total is the number of facilities in the source
+	Total int
+	// Complete is set if every facility was handed over, rather than only those that have changed
+	Complete bool
+}
+
+// FetchMode decides how the facilities are retrieved from the source between full refreshes
+type FetchMode string
+
+const (
+	// FetchFull downloads the complete list of facilities, unless it has not changed
+	FetchFull FetchMode = "full"
+	// FetchIncremental lists the id and updated timestamp of every facility, and downloads
+	// only the facilities whose timestamp has changed through /get/{id}. The others are left
+	// as they were stored by the previous download.
+	FetchIncremental FetchMode = "incremental"
+)
+
+// listingPath lists the facilities without their fields, files and trails, so that an
+// incremental download can tell which of them have changed
+const listingPath string = "/list?fields=id,updated"
+
+// listedFeature is the part of a facility that is needed to tell if it has changed. A source
+// that ignores the fields of the listing is still read without keeping the rest of each facility.
+type listedFeature struct {
+	ID         int64 `json:"id"`
+	Properties struct {
+		Updated *string `json:"updated"`
+	} `json:"properties"`
+}
+
+// DefaultFullRefresh is how often all facilities are downloaded and stored, whether they
+// appear to have changed or not
+const DefaultFullRefresh time.Duration = 24 * time.Hour
+
+func parseFetchMode(value string) (FetchMode, error) {
+	switch mode := FetchMode(value); mode {
+	case FetchFull, FetchIncremental:
+		return mode, nil
+	case "":
+		return FetchFull, nil
+	}
+
+	return FetchFull, fmt.Errorf("unknown fetch mode %q, expected %s or %s", value, FetchFull, FetchIncremental)
 }
 
 type clientImpl struct {
-	apiKey     string
-	sourceURL  string
-	httpClient http.Client
+	sourceURL   string
+	httpClient  http.Client
+	retryPolicy retry.Policy
+	limits      integrations.FetchLimits
+
+	mode        FetchMode
+	fullRefresh time.Duration
+
+	// validators of the collection that was last processed, and of the one last returned
+	validators validators
+	pending    *validators
+	// the updated timestamp of each facility in the collection that was last processed, and
+	// in the one last returned
+	known        map[int64]string
+	pendingKnown map[int64]string
+	lastRefresh  time.Time
+	now          func() time.Time
+	mu           sync.Mutex
+}
+
+// validators are sent with a request for the list of facilities so that the source can
+// answer that it has not been modified
+type validators struct {
+	etag         string
+	lastModified string
+	// refreshed is set when the list was downloaded as a full refresh
+	refreshed time.Time
+}
+
+// WithCredentials decides how requests to the facilities source are authenticated
+func WithCredentials(credentials auth.Credentials) func(*clientImpl) {
+	return func(c *clientImpl) {
+		c.httpClient.Transport = otelhttp.NewTransport(credentials.Transport())
+	}
+}
+
+// WithRetryPolicy controls how failed requests to the facilities source are retried
+func WithRetryPolicy(policy retry.Policy) func(*clientImpl) {
+	return func(c *clientImpl) {
+		c.retryPolicy = policy
+	}
+}
+
+// WithFetchLimits bounds the time and memory that a single request to the facilities source may use
+func WithFetchLimits(limits integrations.FetchLimits) func(*clientImpl) {
+	return func(c *clientImpl) {
+		c.limits = limits
+	}
 }
 
-func NewClient(ctx context.Context, apikey, sourceURL string) Client {
-	return &clientImpl{
-		apiKey:    apikey,
+// WithFetchMode decides how facilities are retrieved between full refreshes, and how
+// often a full refresh is made
+func WithFetchMode(mode FetchMode, fullRefresh time.Duration) func(*clientImpl) {
+	return func(c *clientImpl) {
+		c.mode = mode
+		c.fullRefresh = fullRefresh
+	}
+}
+
+func NewClient(ctx context.Context, sourceURL string, options ...func(*clientImpl)) Client {
+	c := &clientImpl{
 		sourceURL: sourceURL,
 		httpClient: http.Client{
 			Transport: otelhttp.NewTransport(http.DefaultTransport),
 		},
+		retryPolicy: retry.DefaultPolicy,
+		limits:      integrations.DefaultFetchLimits,
+		mode:        FetchFull,
+		fullRefresh: DefaultFullRefresh,
+		now:         time.Now,
 	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
 }
 
-func (c *clientImpl) Get(ctx context.Context) (*domain.FeatureCollection, error) {
-	var err error
+func (c *clientImpl) Get(ctx context.Context, feature func(domain.Feature) error) (download Download, err error) {
 	ctx, span := sdltracer.Start(ctx, "get-facilities-information")
 	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refresh := c.lastRefresh.IsZero() || c.now().Sub(c.lastRefresh) >= c.fullRefresh
+	incremental := c.mode == FetchIncremental && !refresh
+
+	conditions := c.validators
+	if refresh {
+		conditions = validators{}
+	}
+
+	var response fetched
+	known := map[int64]string{}
+	changed := []int64{}
+	handed := 0
+
+	err = retry.Do(ctx, c.retryPolicy, func() error {
+		clear(known)
+		changed = changed[:0]
+
+		if incremental {
+			response, err = c.fetch(ctx, listingPath, conditions, func(body io.Reader) error {
+				return geojson.Decode(body, nil, func(f listedFeature) error {
+					known[f.ID] = timestamp(f.Properties.Updated)
+
+					if previous, ok := c.known[f.ID]; !ok || previous != known[f.ID] {
+						changed = append(changed, f.ID)
+					}
+					return nil
+				})
+			})
+			return err
+		}
+
+		response, err = c.fetch(ctx, "/list", conditions, func(body io.Reader) error {
+			return geojson.Decode(body, nil, func(f domain.Feature) error {
+				known[f.ID] = timestamp(f.Properties.Updated)
+				handed++
+				return feature(f)
+			})
+		})
+		if err != nil && handed > 0 {
+			// a retry would hand the same facilities over again
+			return &integrations.InterruptedError{Err: err, Handed: handed}
+		}
+		return err
+	})
+	if err != nil {
+		var tooLarge *integrations.BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			logging.GetFromContext(ctx).Error("the facilities list is too large, check FACILITIES_MAX_BODY_SIZE", "err", err.Error())
+		} else if retry.IsPermanent(err) {
+			logging.GetFromContext(ctx).Error("the facilities source rejected the request, check FACILITIES_URL and the credentials", "err", err.Error())
+		}
+		return download, err
+	}
+
+	if response.notModified {
+		return download, ErrNotModified
+	}
+
+	if incremental {
+		err = c.getChanged(ctx, changed, feature)
+		if err != nil {
+			return download, err
+		}
+
+		logging.GetFromContext(ctx).Info("retrieved changed facilities", "changed", len(changed), "total", len(known))
+	}
+
+	next := response.validators
+	if refresh {
+		next.refreshed = c.now()
+	}
+	c.pending = &next
+	c.pendingKnown = known
+
+	return Download{Total: len(known), Complete: !incremental}, nil
+}
+
+// getChanged reads the facilities that have changed since the previous collection through
+// /get/{id}, and hands them to feature one at a time
+func (c *clientImpl) getChanged(ctx context.Context, changed []int64, feature func(domain.Feature) error) error {
+	for _, featureID := range changed {
+		var f domain.Feature
+
+		err := retry.Do(ctx, c.retryPolicy, func() (err error) {
+			_, err = c.fetch(ctx, fmt.Sprintf("/get/%d", featureID), validators{}, func(body io.Reader) error {
+				b, err := io.ReadAll(body)
+				if err != nil {
+					return err
+				}
+
+				f, err = decodeFeature(b)
+				return err
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to retrieve facility %d: %w", featureID, err)
+		}
+
+		if err = feature(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *clientImpl) Processed(unsettled ...int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending == nil {
+		return
+	}
+
+	c.validators = *c.pending
+	c.known = c.pendingKnown
+	c.pending = nil
+
+	// the next listing must not be answered with not modified while facilities are unsettled
+	if len(unsettled) > 0 {
+		c.validators.etag, c.validators.lastModified = "", ""
+	}
+	for _, featureID := range unsettled {
+		delete(c.known, featureID)
+	}
+
+	if !c.validators.refreshed.IsZero() {
+		c.lastRefresh = c.validators.refreshed
+	}
+}
+
+// fetched is the outcome of a single successful request to the facilities source
+type fetched struct {
+	notModified bool
+	validators  validators
+}
+
+// fetch makes a single attempt at retrieving the facilities information, and hands the
+// response body to decode while the request is still within its time and size limits
+func (c *clientImpl) fetch(ctx context.Context, path string, conditions validators, decode func(io.Reader) error) (response fetched, err error) {
+	var statusCode int
+	defer func(started time.Time) {
+		integrations.ObserveFetch(IntegrationName, started, statusCode, err)
+	}(time.Now())
+
 	log := logging.GetFromContext(ctx)
 
-	apiReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.sourceURL+"/list", nil)
+	ctx, cancel := c.limits.WithTimeout(ctx)
+	defer cancel()
+
+	apiReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.sourceURL+path, nil)
 	if err != nil {
-		return nil, err
+		return response, err
 	}
 
-	apiReq.Header.Set("apikey", c.apiKey)
+	if conditions.etag != "" {
+		apiReq.Header.Set("If-None-Match", conditions.etag)
+	}
+
+	if conditions.lastModified != "" {
+		apiReq.Header.Set("If-Modified-Since", conditions.lastModified)
+	}
 
 	apiResponse, err := c.httpClient.Do(apiReq)
 	if err != nil {
 		log.Error("failed to retrieve facilities information", "err", err.Error())
-		return nil, err
+		return response, err
 	}
 	defer apiResponse.Body.Close()
 
+	statusCode = apiResponse.StatusCode
+
+	if apiResponse.StatusCode == http.StatusNotModified {
+		response.notModified = true
+		return response, nil
+	}
+
 	if apiResponse.StatusCode != http.StatusOK {
 		log.Error("unexpected status code when attempting to retrieve facilities information", slog.Int("expected", http.StatusOK), slog.Int("received", apiResponse.StatusCode))
-		err = fmt.Errorf("expected status code %d, but got %d", http.StatusOK, apiResponse.StatusCode)
-		return nil, err
+		err = retry.NewStatusError(apiResponse)
+		return response, err
 	}
 
-	body, err := io.ReadAll(apiResponse.Body)
+	err = decode(c.limits.Body(apiResponse))
 	if err != nil {
 		log.Error("failed to read response body", "err", err.Error())
-		return nil, err
+		err = fmt.Errorf("failed to decode response from %s: %w", c.sourceURL+path, err)
+		return response, err
 	}
 
-	featureCollection := &domain.FeatureCollection{}
-	err = json.Unmarshal(body, featureCollection)
-	if err != nil {
-		err = fmt.Errorf("failed to unmarshal response from %s. (%s)", c.sourceURL, err.Error())
-		return nil, err
+	response.validators = validators{
+		etag:         apiResponse.Header.Get("ETag"),
+		lastModified: apiResponse.Header.Get("Last-Modified"),
 	}
 
-	return featureCollection, nil
+	return response, nil
+}
+
+// decodeFeature reads a single feature, on its own or as the only feature of a collection
+func decodeFeature(body []byte) (domain.Feature, error) {
+	fc := domain.FeatureCollection{}
+	if err := json.Unmarshal(body, &fc); err == nil && fc.Type == "FeatureCollection" {
+		if len(fc.Features) != 1 {
+			return domain.Feature{}, fmt.Errorf("expected a single feature, got %d", len(fc.Features))
+		}
+		return fc.Features[0], nil
+	}
+
+	feature := domain.Feature{}
+	err := json.Unmarshal(body, &feature)
+	return feature, err
+}
+
+// timestamp returns the value of a timestamp that may be missing
+func timestamp(ts *string) string {
+	if ts == nil {
+		return ""
+	}
+	return *ts
 }
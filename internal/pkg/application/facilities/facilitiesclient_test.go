@@ -0,0 +1,177 @@
+package facilities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/auth"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/auth/authtest"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/retry"
+	"github.com/matryer/is"
+)
+
+func TestThatAnUnchangedListIsNotDownloadedAgain(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	c := NewClient(ctx, server.URL)
+
+	_, err := download(ctx, c)
+	is.NoErr(err)
+
+	// the list has to be downloaded again until it has been processed
+	_, err = download(ctx, c)
+	is.NoErr(err)
+	c.Processed()
+
+	_, err = download(ctx, c)
+	is.True(errors.Is(err, ErrNotModified))
+	is.Equal(requests, 3)
+
+	// a full refresh ignores the validators
+	c.(*clientImpl).now = func() time.Time { return time.Now().Add(DefaultFullRefresh) }
+	_, err = download(ctx, c)
+	is.NoErr(err)
+}
+
+func TestThatOnlyChangedFeaturesAreRetrievedIncrementally(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	fc := domain.FeatureCollection{}
+	is.NoErr(json.Unmarshal([]byte(response), &fc))
+
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+		if strings.HasPrefix(r.URL.Path, "/get/") {
+			feature := fc.Features[1]
+			feature.Properties.Name = "Hämtad"
+			json.NewEncoder(w).Encode(feature)
+			return
+		}
+		json.NewEncoder(w).Encode(fc)
+	}))
+	defer server.Close()
+
+	c := NewClient(ctx, server.URL, WithFetchMode(FetchIncremental, time.Hour))
+
+	_, err := download(ctx, c)
+	is.NoErr(err)
+	c.Processed()
+
+	updated := "2030-01-01 00:00:00"
+	fc.Features[1].Properties.Updated = &updated
+
+	result, err := download(ctx, c)
+	is.NoErr(err)
+
+	// the first run is a full refresh, after which only the listing and the changed
+	// feature are requested
+	is.Equal(requests, []string{"/list", listingPath, "/get/703"})
+	is.Equal(len(result.Features), 1)
+	is.Equal(result.Features[0].Properties.Name, "Hämtad")
+
+	c.Processed()
+
+	_, err = download(ctx, c)
+	is.NoErr(err)
+	is.Equal(requests[3:], []string{listingPath})
+}
+
+func TestThatAnUnknownFetchModeIsAnError(t *testing.T) {
+	is := is.New(t)
+
+	_, err := parseFetchMode("sometimes")
+	is.True(err != nil)
+}
+
+func TestThatATooLargeListFailsWithoutRetrying(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	c := NewClient(ctx, server.URL, WithFetchLimits(integrations.FetchLimits{MaxBodySize: 100}))
+
+	_, err := download(ctx, c)
+
+	var tooLarge *integrations.BodyTooLargeError
+	is.True(errors.As(err, &tooLarge))
+	is.Equal(requests, 1)
+}
+
+func TestThatASlowSourceTimesOut(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the headers arrive in time, but the body does not
+		w.Write([]byte(`{"type":"FeatureCollection","features":[`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(ctx, server.URL,
+		WithRetryPolicy(retry.Policy{Attempts: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+		WithFetchLimits(integrations.FetchLimits{Timeout: 50 * time.Millisecond}))
+
+	started := time.Now()
+	_, err := download(ctx, c)
+
+	is.True(errors.Is(err, context.DeadlineExceeded))
+	is.True(time.Since(started) < 2*time.Second)
+}
+
+func TestThatTheListIsRequestedWithAnAccessToken(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	ts := authtest.NewTokenServer("integration-cip-sdl", "s3cr3t", time.Hour)
+	defer ts.Close()
+
+	server := httptest.NewServer(ts.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	})))
+	defer server.Close()
+
+	cc := auth.NewClientCredentials(ts.TokenURL(), "integration-cip-sdl", auth.StaticSecret("s3cr3t"))
+	c := NewClient(ctx, server.URL, WithCredentials(auth.Credentials{Authenticator: cc}))
+
+	_, err := download(ctx, c)
+	is.NoErr(err)
+	c.Processed()
+
+	_, err = download(ctx, c)
+	is.NoErr(err)
+	is.Equal(ts.Issued(), 1)
+}
@@ -0,0 +1,273 @@
+package facilities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/schedule"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+const IntegrationName string = "facilities"
+
+func init() {
+	integrations.Register(IntegrationName, NewIntegration, integrations.Settings{
+		Schedule:      schedule.Every(58 * time.Minute),
+		RetryInterval: 2 * time.Minute,
+		MaxSyncAge:    3 * time.Hour,
+	})
+}
+
+type facilitiesIntegration struct {
+	url string
+
+	client    Client
+	storage   Storage
+	brokers   integrations.Brokers
+	tracker   *integrations.Tracker
+	safeguard *integrations.Safeguard
+
+	configErrors []error
+}
+
+func NewIntegration(ctx context.Context, cfg integrations.Config, brokers integrations.Brokers) integrations.Integration {
+	url := cfg.Get("FACILITIES_URL")
+
+	tracker := integrations.NewTracker(IntegrationName)
+
+	var configErrors []error
+
+	refs, err := parseSeeAlsoRefs(cfg.Get("FACILITIES_SEE_ALSO_REFS"))
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("FACILITIES_SEE_ALSO_REFS: %w", err))
+		refs = seeAlsoRefs
+	}
+
+	orphans, err := parseOrphanPolicy(cfg.Get("FACILITIES_ORPHANS"))
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("FACILITIES_ORPHANS: %w", err))
+	}
+
+	credentials, err := integrations.CredentialsFromConfig(IntegrationName, cfg, integrations.AuthAPIKey)
+	if err != nil {
+		configErrors = append(configErrors, err)
+	}
+
+	retryPolicy, err := integrations.FetchRetryPolicy(IntegrationName, cfg)
+	if err != nil {
+		configErrors = append(configErrors, err)
+	}
+
+	limits, err := integrations.FetchLimitsFromConfig(IntegrationName, cfg)
+	if err != nil {
+		configErrors = append(configErrors, err)
+	}
+
+	fetchMode, err := parseFetchMode(cfg.Get("FACILITIES_FETCH_MODE"))
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("FACILITIES_FETCH_MODE: %w", err))
+	}
+
+	fullRefresh := DefaultFullRefresh
+	if value := cfg.Get("FACILITIES_FULL_REFRESH"); value != "" {
+		fullRefresh, err = time.ParseDuration(value)
+		if err != nil || fullRefresh <= 0 {
+			configErrors = append(configErrors, fmt.Errorf("FACILITIES_FULL_REFRESH must be a positive duration, not %q", value))
+			fullRefresh = DefaultFullRefresh
+		}
+	}
+
+	safeguard, err := integrations.SafeguardFromConfig(IntegrationName, cfg)
+	if err != nil {
+		configErrors = append(configErrors, err)
+	}
+
+	// an invalid value is reported when the service starts
+	workers, _ := integrations.BrokerWorkers(cfg)
+
+	return &facilitiesIntegration{
+		url:          url,
+		client:       NewClient(ctx, url, WithCredentials(credentials), WithRetryPolicy(retryPolicy), WithFetchLimits(limits), WithFetchMode(fetchMode, fullRefresh)),
+		storage:      NewStorage(ctx, WithTracker(tracker), withSeeAlsoRefs(refs), WithWorkers(workers), WithOrphanPolicy(orphans), WithSafeguard(safeguard)),
+		brokers:      brokers,
+		tracker:      tracker,
+		safeguard:    safeguard,
+		configErrors: configErrors,
+	}
+}
+
+func (fi *facilitiesIntegration) Name() string {
+	return IntegrationName
+}
+
+func (fi *facilitiesIntegration) Validate(ctx context.Context) error {
+	errs := slices.Clone(fi.configErrors)
+
+	if fi.url == "" {
+		errs = append(errs, errors.New("please set FACILITIES_URL to a valid Facilities URL"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// The entity types that a facilities run can be limited to
+const (
+	TypeTrails       string = "trails"
+	TypeBeaches      string = "beaches"
+	TypeSportsFields string = "sportsfields"
+	TypeSportsVenues string = "sportsvenues"
+)
+
+func (fi *facilitiesIntegration) EntityTypes() []string {
+	return []string{TypeTrails, TypeBeaches, TypeSportsFields, TypeSportsVenues}
+}
+
+type storeFunc func(context.Context, client.ContextBrokerClient, string, *Feed) error
+
+// pipeline stores the facilities of an entity type
+type pipeline struct {
+	entityType  string
+	description string
+	store       storeFunc
+}
+
+// Run downloads the facilities and hands each of them to the pipelines of the entity types
+// as soon as it has been decoded, so that the collection is never held in memory as a whole
+func (fi *facilitiesIntegration) Run(ctx context.Context, entityTypes ...string) (err error) {
+	fi.tracker.Attempt()
+	defer func() { fi.tracker.Done(err) }()
+
+	pipelines := []pipeline{}
+	for _, p := range []pipeline{
+		{TypeTrails, "exercise trails", fi.storage.StoreTrailsFromSource},
+		{TypeBeaches, "beaches", fi.storage.StoreBeachesFromSource},
+		{TypeSportsFields, "sports fields", fi.storage.StoreSportsFieldsFromSource},
+		{TypeSportsVenues, "sports venues", fi.storage.StoreSportsVenuesFromSource},
+	} {
+		if len(entityTypes) == 0 || slices.Contains(entityTypes, p.entityType) {
+			pipelines = append(pipelines, p)
+		}
+	}
+
+	logger := logging.GetFromContext(ctx)
+
+	feeds := make([]*Feed, len(pipelines))
+	storeErrs := make([]error, len(pipelines))
+	started := false
+	wg := sync.WaitGroup{}
+
+	// the pipelines start along with the first feature, so that nothing is read from the
+	// broker if the facilities have not been modified
+	start := func() {
+		started = true
+
+		for i, p := range pipelines {
+			feeds[i] = newFeed()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// each entity type may be written to a tenant of its own
+				storeErrs[i] = p.store(ctx, fi.brokers(p.entityType), fi.url, feeds[i])
+			}()
+		}
+	}
+
+	download, err := fi.client.Get(ctx, func(feature domain.Feature) error {
+		if !started {
+			start()
+		}
+
+		for _, feed := range feeds {
+			if err := feed.send(ctx, feature); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if errors.Is(err, ErrNotModified) {
+		logger.Info("facilities information has not changed since the last run")
+		return nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf("failed to retrieve facilities information: %w", err)
+		if !started {
+			return err
+		}
+	} else {
+		if !started {
+			start()
+		}
+
+		running := []string{}
+		for _, p := range pipelines {
+			running = append(running, p.entityType)
+		}
+
+		// the deletions of the entity types that are not part of the run are left pending
+		fi.safeguard.Begin(ctx, download.Total, running...)
+	}
+
+	for _, feed := range feeds {
+		feed.end(download.Complete, err)
+	}
+
+	wg.Wait()
+
+	// the pipelines have stored what was received before the download failed
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+
+	for i, p := range pipelines {
+		if storeErrs[i] != nil {
+			logger.Error("failed to store "+p.description+" information", "err", storeErrs[i].Error())
+			errs = append(errs, fmt.Errorf("failed to store %s: %w", p.description, storeErrs[i]))
+		}
+	}
+
+	// an unchanged collection may only be skipped once every entity type has been stored
+	if len(errs) == 0 && len(entityTypes) == 0 {
+		fi.client.Processed(fi.storage.Unsettled()...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// MarshalState returns the state of the storage so that deleted features are not
+// deleted again after a restart
+func (fi *facilitiesIntegration) MarshalState() ([]byte, error) {
+	if s, ok := fi.storage.(integrations.Stateful); ok {
+		return s.MarshalState()
+	}
+	return []byte("{}"), nil
+}
+
+func (fi *facilitiesIntegration) UnmarshalState(data []byte) error {
+	if s, ok := fi.storage.(integrations.Stateful); ok {
+		return s.UnmarshalState(data)
+	}
+	return nil
+}
+
+func (fi *facilitiesIntegration) Status() integrations.Status {
+	status := fi.tracker.Status()
+
+	if fi.safeguard != nil {
+		safeguard := fi.safeguard.Status()
+		status.Safeguard = &safeguard
+	}
+
+	return status
+}
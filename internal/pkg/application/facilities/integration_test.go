@@ -0,0 +1,155 @@
+package facilities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+)
+
+func TestThatEveryPipelineReceivesEveryFeature(t *testing.T) {
+	is, ctxBrokerMock, _ := testSetup(t, "", http.StatusOK, response)
+	ctxBrokerMock.CreateEntityFunc = func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+		return &ngsild.CreateEntityResult{}, nil
+	}
+
+	// more features than fit in the buffer of a feed
+	fc := domain.FeatureCollection{}
+	for range 10 {
+		fc.Features = append(fc.Features, features(t).Features...)
+	}
+
+	source := &fakeClient{fc: fc}
+	tracker := integrations.NewTracker(IntegrationName)
+	fi := &facilitiesIntegration{
+		client:  source,
+		storage: NewStorage(context.Background(), WithTracker(tracker)),
+		brokers: integrations.SingleBroker(ctxBrokerMock),
+		tracker: tracker,
+	}
+
+	is.NoErr(fi.Run(context.Background()))
+	is.True(source.processed)
+
+	status := tracker.Status()
+	is.Equal(len(status.EntityTypes), 4)
+	is.Equal(status.EntityTypes[TypeTrails].Processed, 20)
+	is.Equal(status.EntityTypes[TypeBeaches].Processed, 10)
+}
+
+func TestThatTheDownloadDoesNotWaitForPipelinesThatFailedToPrefetch(t *testing.T) {
+	is, ctxBrokerMock, _ := testSetup(t, "", http.StatusOK, response)
+	ctxBrokerMock.QueryEntitiesFunc = func(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+		return nil, errors.New("[code: 503] broker is unwell")
+	}
+
+	// more features than fit in the buffer of a feed
+	fc := domain.FeatureCollection{}
+	for range 10 {
+		fc.Features = append(fc.Features, features(t).Features...)
+	}
+
+	tracker := integrations.NewTracker(IntegrationName)
+	fi := &facilitiesIntegration{
+		client:  &fakeClient{fc: fc},
+		storage: NewStorage(context.Background(), WithTracker(tracker)),
+		brokers: integrations.SingleBroker(ctxBrokerMock),
+		tracker: tracker,
+	}
+
+	done := make(chan error)
+	go func() { done <- fi.Run(context.Background()) }()
+
+	select {
+	case err := <-done:
+		is.True(err != nil)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run did not return after the prefetch failed")
+	}
+}
+
+func TestThatAHeldDeletionIsCarriedOutWhenFetchingIncrementally(t *testing.T) {
+	is, ctxBrokerMock, _ := testSetup(t, "", http.StatusOK, response)
+	withBeachesInBroker(ctxBrokerMock, "1545")
+	ctxBrokerMock.CreateEntityFunc = func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+		return &ngsild.CreateEntityResult{}, nil
+	}
+
+	fc := features(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if featureID, ok := strings.CutPrefix(r.URL.Path, "/get/"); ok {
+			for _, f := range fc.Features {
+				if strconv.FormatInt(f.ID, 10) == featureID {
+					json.NewEncoder(w).Encode(f)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(fc)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	safeguard := integrations.NewSafeguard(0.3, 2, 3)
+	tracker := integrations.NewTracker(IntegrationName)
+	fi := &facilitiesIntegration{
+		client:    NewClient(ctx, server.URL, WithFetchMode(FetchIncremental, time.Hour)),
+		storage:   NewStorage(ctx, WithTracker(tracker), WithSafeguard(safeguard)),
+		brokers:   integrations.SingleBroker(ctxBrokerMock),
+		tracker:   tracker,
+		safeguard: safeguard,
+	}
+
+	is.NoErr(fi.Run(ctx))
+
+	// the beach is unpublished, which only changes its timestamp once
+	updated := time.Now().UTC().Format(timeFormat)
+	for i, f := range fc.Features {
+		if f.ID == 1545 {
+			fc.Features[i].Properties.Published = false
+			fc.Features[i].Properties.Updated = &updated
+		}
+	}
+
+	is.NoErr(fi.Run(ctx))
+	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 0)
+	is.Equal(tracker.Status().EntityTypes[TypeBeaches].Held, 1)
+
+	is.NoErr(fi.Run(ctx))
+	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
+
+	// once the deletion is carried out, the beach is left alone
+	is.NoErr(fi.Run(ctx))
+	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
+}
+
+// fakeClient hands over the features of a collection
+type fakeClient struct {
+	fc        domain.FeatureCollection
+	processed bool
+}
+
+func (c *fakeClient) Get(ctx context.Context, feature func(domain.Feature) error) (Download, error) {
+	for _, f := range c.fc.Features {
+		if err := feature(f); err != nil {
+			return Download{}, err
+		}
+	}
+	return Download{Total: len(c.fc.Features), Complete: true}, nil
+}
+
+func (c *fakeClient) Processed(unsettled ...int64) {
+	c.processed = true
+}
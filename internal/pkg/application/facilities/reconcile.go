@@ -0,0 +1,86 @@
+package facilities
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// OrphanPolicy decides what happens to entities in the context broker whose features
+// have disappeared from the source, or have changed into a type that is not mapped
+type OrphanPolicy string
+
+const (
+	// OrphansIgnore skips the reconciliation between the source and the broker
+	OrphansIgnore OrphanPolicy = "ignore"
+	// OrphansFlag reports orphaned entities in the status, but leaves them in the broker
+	OrphansFlag OrphanPolicy = "flag"
+	// OrphansDelete deletes orphaned entities from the broker
+	OrphansDelete OrphanPolicy = "delete"
+)
+
+func parseOrphanPolicy(value string) (OrphanPolicy, error) {
+	switch policy := OrphanPolicy(value); policy {
+	case OrphansIgnore, OrphansFlag, OrphansDelete:
+		return policy, nil
+	case "":
+		return OrphansFlag, nil
+	}
+
+	return OrphansFlag, fmt.Errorf("unknown policy %q, expected one of %s, %s or %s", value, OrphansIgnore, OrphansFlag, OrphansDelete)
+}
+
+// WithOrphanPolicy makes the storage look for orphaned entities after every run
+func WithOrphanPolicy(policy OrphanPolicy) func(*storageImpl) {
+	return func(s *storageImpl) {
+		s.orphans = policy
+	}
+}
+
+// prefetch returns an index of the entities of the given type in the broker that were
+// created from a facilities feature
+func prefetch(ctx context.Context, ctxBrokerClient client.ContextBrokerClient, entityType, idPrefix string) (*integrations.Index, error) {
+	index, err := integrations.PrefetchEntities(ctx, ctxBrokerClient, entityType, idPrefix+domain.SundsvallAnlaggningPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prefetch %s entities: %w", entityType, err)
+	}
+
+	return index, nil
+}
+
+// reconcile looks for entities in the index that were created from a facilities feature
+// that is not among the features in the feed, and handles them according to the orphan policy.
+// A feed that only holds the features that have changed can not tell which are missing.
+func (s *storageImpl) reconcile(ctx context.Context, w *integrations.Writer, run *integrations.EntityRun, idPrefix string, index *integrations.Index, feed *Feed, inFeed map[int64]bool) {
+	if s.orphans == OrphansIgnore || !feed.Complete() {
+		return
+	}
+
+	logger := logging.GetFromContext(ctx)
+	prefix := idPrefix + domain.SundsvallAnlaggningPrefix
+
+	for _, entityID := range index.IDs() {
+		featureID, err := strconv.ParseInt(strings.TrimPrefix(entityID, prefix), 10, 64)
+		if err == nil && inFeed[featureID] {
+			continue
+		}
+
+		run.Orphaned(entityID)
+
+		if s.orphans == OrphansDelete {
+			if s.confirmDelete(ctx, run, entityID) {
+				logger.Info("deleting orphaned entity", "entityID", entityID)
+				s.fingerprints.Forget(entityID)
+				w.Delete(entityID)
+			}
+		} else {
+			logger.Warn("found orphaned entity", "entityID", entityID)
+		}
+	}
+}
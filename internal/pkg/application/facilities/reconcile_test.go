@@ -0,0 +1,123 @@
+package facilities
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/diwise/context-broker/pkg/datamodels/fiware"
+	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+	"github.com/matryer/is"
+)
+
+const orphanedBeach string = fiware.BeachIDPrefix + domain.SundsvallAnlaggningPrefix + "1234"
+
+func TestThatOrphanedEntitiesAreDeletedIfThePolicySaysSo(t *testing.T) {
+	is, ctxBrokerMock, _ := testSetup(t, "", http.StatusOK, response)
+	withBeachesInBroker(ctxBrokerMock, "1545", "1234")
+
+	tracker := integrations.NewTracker(IntegrationName)
+	storage := NewStorage(context.Background(), WithTracker(tracker), WithOrphanPolicy(OrphansDelete))
+
+	err := storage.StoreBeachesFromSource(context.Background(), ctxBrokerMock, "", feedOf(features(t)))
+	is.NoErr(err)
+
+	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
+	is.Equal(ctxBrokerMock.DeleteEntityCalls()[0].EntityID, orphanedBeach)
+
+	query := ctxBrokerMock.QueryEntitiesCalls()[0].Query
+	is.Equal(query, "?idPattern=%5Eurn%3Angsi-ld%3ABeach%3Ase%3Asundsvall%3Afacilities%3A&limit=100&offset=0&type=Beach")
+
+	beaches := tracker.Status().EntityTypes[TypeBeaches]
+	is.Equal(beaches.Orphaned, 1)
+	is.Equal(beaches.Deleted, 1)
+}
+
+func TestThatOrphanedEntitiesAreOnlyFlaggedByDefault(t *testing.T) {
+	is, ctxBrokerMock, _ := testSetup(t, "", http.StatusOK, response)
+	withBeachesInBroker(ctxBrokerMock, "1545", "1234")
+
+	orphans, err := parseOrphanPolicy("")
+	is.NoErr(err)
+
+	tracker := integrations.NewTracker(IntegrationName)
+	storage := NewStorage(context.Background(), WithTracker(tracker), WithOrphanPolicy(orphans))
+
+	err = storage.StoreBeachesFromSource(context.Background(), ctxBrokerMock, "", feedOf(features(t)))
+	is.NoErr(err)
+
+	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 0)
+	is.Equal(tracker.Status().EntityTypes[TypeBeaches].Orphans, []string{orphanedBeach})
+}
+
+func TestThatOrphanedEntitiesAreOnlyDeletedOnceConfirmed(t *testing.T) {
+	is, ctxBrokerMock, _ := testSetup(t, "", http.StatusOK, response)
+	withBeachesInBroker(ctxBrokerMock, "1545", "1234")
+
+	ctx := context.Background()
+	safeguard := integrations.NewSafeguard(0.3, 2, 3)
+	tracker := integrations.NewTracker(IntegrationName)
+	storage := NewStorage(ctx, WithTracker(tracker), WithOrphanPolicy(OrphansDelete), WithSafeguard(safeguard))
+
+	safeguard.Begin(ctx, 1, TypeBeaches)
+	is.NoErr(storage.StoreBeachesFromSource(ctx, ctxBrokerMock, "", feedOf(features(t))))
+
+	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 0)
+	is.Equal(tracker.Status().EntityTypes[TypeBeaches].Held, 1)
+	is.Equal(safeguard.Status().PendingDeletions[0].EntityID, orphanedBeach)
+
+	safeguard.Begin(ctx, 1, TypeBeaches)
+	is.NoErr(storage.StoreBeachesFromSource(ctx, ctxBrokerMock, "", feedOf(features(t))))
+
+	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
+	is.Equal(len(safeguard.Status().PendingDeletions), 0)
+}
+
+func TestThatAnUnknownOrphanPolicyIsAnError(t *testing.T) {
+	is := is.New(t)
+
+	_, err := parseOrphanPolicy("shred")
+	is.True(err != nil)
+}
+
+func withBeachesInBroker(broker *test.ContextBrokerClientMock, featureIDs ...string) {
+	withEntitiesInBroker(broker, fiware.BeachTypeName, fiware.BeachIDPrefix, featureIDs...)
+
+	broker.DeleteEntityFunc = func(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
+		return &ngsild.DeleteEntityResult{}, nil
+	}
+
+	broker.MergeEntityFunc = func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+		return &ngsild.MergeEntityResult{}, nil
+	}
+}
+
+// withEntitiesInBroker makes the broker return entities of the given type, created from
+// the given features, when it is queried
+func withEntitiesInBroker(broker *test.ContextBrokerClientMock, entityType, idPrefix string, featureIDs ...string) {
+	broker.QueryEntitiesFunc = func(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+		result := ngsild.NewQueryEntitiesResult()
+		go func() {
+			for _, id := range featureIDs {
+				e, _ := entities.New(idPrefix+domain.SundsvallAnlaggningPrefix+id, entityType)
+				result.Found <- e
+			}
+			result.Found <- nil
+		}()
+		return result, nil
+	}
+}
+
+func features(t *testing.T) domain.FeatureCollection {
+	fc := domain.FeatureCollection{}
+	if err := json.Unmarshal([]byte(response), &fc); err != nil {
+		t.Fatal(err)
+	}
+	return fc
+}
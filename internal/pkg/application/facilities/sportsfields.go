@@ -12,10 +12,10 @@ import (
 
 	"github.com/diwise/context-broker/pkg/datamodels/diwise"
 	"github.com/diwise/context-broker/pkg/ngsild/client"
-	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
 	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
 	"github.com/diwise/context-broker/pkg/ngsild/types/properties"
 	"github.com/diwise/context-broker/pkg/ngsild/types/relationships"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 
@@ -25,18 +25,44 @@ import (
 
 var ErrSportsFieldIsOfIgnoredType error = errors.New("sportsfield is of non supported type")
 
-func (s *storageImpl) StoreSportsFieldsFromSource(ctx context.Context, ctxBrokerClient client.ContextBrokerClient, sourceURL string, featureCollection domain.FeatureCollection) error {
+func isSportsField(t string) bool {
+	return t == "Aktivitetsyta"
+}
+
+func (s *storageImpl) StoreSportsFieldsFromSource(ctx context.Context, ctxBrokerClient client.ContextBrokerClient, sourceURL string, features *Feed) error {
+	defer features.stop()
 
 	logger := logging.GetFromContext(ctx)
+	run := s.tracker.BeginEntityType(TypeSportsFields)
+	inFeed := map[int64]bool{}
+	deletions := []deletion{}
+
+	index, err := prefetch(ctx, ctxBrokerClient, diwise.SportsFieldTypeName, diwise.SportsFieldIDPrefix)
+	if err != nil {
+		run.Done(err)
+		return err
+	}
 
-	headers := map[string][]string{"Content-Type": {"application/ld+json"}}
+	w := integrations.NewWriter(ctx, ctxBrokerClient, run, s.workers, integrations.WithIndex(index))
+
+	for feature := range features.All() {
+		if w.Err() != nil {
+			break
+		}
+
+		if isSportsField(feature.Properties.Type) {
+			run.Processed()
+			inFeed[feature.ID] = true
 
-	for _, feature := range featureCollection.Features {
-		if feature.Properties.Type == "Aktivitetsyta" {
 			sportsField, err := parseSportsField(ctx, feature)
 			if err != nil {
-				if !errors.Is(err, ErrSportsFieldIsOfIgnoredType) {
+				if errors.Is(err, ErrSportsFieldIsOfIgnoredType) {
+					// an entity that was created before the type was ignored is an orphan
+					delete(inFeed, feature.ID)
+					run.Skipped()
+				} else {
 					logger.Error("failed to parse sports field", slog.Int64("featureID", feature.ID), "err", err.Error())
+					run.Rejected(err)
 				}
 				continue
 			}
@@ -44,11 +70,10 @@ func (s *storageImpl) StoreSportsFieldsFromSource(ctx context.Context, ctxBroker
 			entityID := diwise.SportsFieldIDPrefix + sportsField.ID
 
 			if okToDel, alreadyDeleted := s.shouldBeDeleted(ctx, feature); okToDel {
-				if !alreadyDeleted {
-					_, err := ctxBrokerClient.DeleteEntity(ctx, entityID)
-					if err != nil {
-						logger.Info("could not delete entity", "entityID", entityID, "err", err.Error())
-					}
+				if alreadyDeleted {
+					run.Skipped()
+				} else {
+					deletions = append(deletions, deletion{featureID: feature.ID, entityID: entityID})
 				}
 				continue
 			}
@@ -57,37 +82,21 @@ func (s *storageImpl) StoreSportsFieldsFromSource(ctx context.Context, ctxBroker
 
 			attributes := convertDBSportsFieldToFiwareSportsField(*sportsField)
 
-			fragment, _ := entities.NewFragment(attributes...)
-
-			_, err = ctxBrokerClient.MergeEntity(ctx, entityID, fragment, headers)
-
-			// Throttle so we dont kill the broker
-			time.Sleep(100 * time.Millisecond)
+			w.UpsertChanges(s.fingerprints, entityID, diwise.SportsFieldTypeName, attributes)
 
-			if err != nil {
-				if !errors.Is(err, ngsierrors.ErrNotFound) {
-					logger.Error("failed to merge entity", "entityID", entityID, "err", err.Error())
-					logger.Info("waiting for context broker to recover...")
-					time.Sleep(10 * time.Second)
-					continue
-				}
-				entity, err := entities.New(entityID, diwise.SportsFieldTypeName, attributes...)
-				if err != nil {
-					logger.Error("entities.New failed", "entityID", entityID, "err", err.Error())
-					continue
-				}
+		}
+	}
 
-				_, err = ctxBrokerClient.CreateEntity(ctx, entity, headers)
-				if err != nil {
-					logger.Error("failed to post sports field to context broker", "entityID", entityID, "err", err.Error())
-					continue
-				}
-			}
+	if err = s.deleteFeatures(ctx, w, run, features, deletions); err == nil {
+		s.reconcile(ctx, w, run, diwise.SportsFieldIDPrefix, index, features, inFeed)
+	}
 
-		}
+	if writeErr := w.Wait(); writeErr != nil {
+		err = writeErr
 	}
+	run.Done(err)
 
-	return nil
+	return err
 }
 
 func parseSportsField(ctx context.Context, feature domain.Feature) (*domain.SportsField, error) {
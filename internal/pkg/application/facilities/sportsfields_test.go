@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/diwise/context-broker/pkg/datamodels/diwise"
 	"github.com/diwise/context-broker/pkg/ngsild"
 	"github.com/diwise/context-broker/pkg/ngsild/types"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
@@ -25,10 +26,11 @@ func TestSportsFieldLoad(t *testing.T) {
 
 	ctx := context.Background()
 	storage := NewStorage(ctx)
-	err := storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err := storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 
 	is.NoErr(err)
-	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 1)
+	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 0) // the sports field is not in the broker
+	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 1)
 }
 
 func TestSportsField(t *testing.T) {
@@ -41,13 +43,13 @@ func TestSportsField(t *testing.T) {
 
 	ctx := context.Background()
 
-	client := NewClient(ctx, "apiKey", server.URL)
+	client := NewClient(ctx, server.URL)
 
-	featureCollection, err := client.Get(ctx)
+	featureCollection, err := download(ctx, client)
 	is.NoErr(err)
 
 	storage := NewStorage(ctx)
-	err = storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, *featureCollection)
+	err = storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(featureCollection))
 	is.NoErr(err)
 
 	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 1)
@@ -69,13 +71,13 @@ func TestSportsFieldHasManagedByAndOwnerProperties(t *testing.T) {
 		return &ngsild.CreateEntityResult{}, nil
 	}
 
-	client := NewClient(ctx, "apiKey", server.URL)
+	client := NewClient(ctx, server.URL)
 
-	featureCollection, err := client.Get(ctx)
+	featureCollection, err := download(ctx, client)
 	is.NoErr(err)
 
 	storage := NewStorage(ctx)
-	err = storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, *featureCollection)
+	err = storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(featureCollection))
 	is.NoErr(err)
 
 	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 1)
@@ -97,10 +99,11 @@ func TestDeletedSportsField(t *testing.T) {
 	fc.Features[0].ID = 789 // id needs to be unique for each test
 	var aWeekAgo = time.Now().UTC().Add(-1 * 7 * 24 * time.Hour).Format("2006-01-02 15:04:05")
 	fc.Features[0].Properties.Deleted = &aWeekAgo
+	withEntitiesInBroker(ctxBrokerMock, diwise.SportsFieldTypeName, diwise.SportsFieldIDPrefix, "789")
 
 	ctx := context.Background()
 	storage := NewStorage(ctx)
-	err := storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err := storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 
 	is.NoErr(err)
 	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
@@ -114,13 +117,14 @@ func TestUnpublishedSportsField(t *testing.T) {
 
 	fc.Features[0].ID = 456 // id needs to be unique for each test
 	fc.Features[0].Properties.Published = false
+	withEntitiesInBroker(ctxBrokerMock, diwise.SportsFieldTypeName, diwise.SportsFieldIDPrefix, "456")
 
 	var aWeekAgo = time.Now().UTC().Add(-1 * 7 * 24 * time.Hour).Format("2006-01-02 15:04:05")
 	fc.Features[0].Properties.Updated = &aWeekAgo
 
 	ctx := context.Background()
 	storage := NewStorage(ctx)
-	err := storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err := storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 
 	is.NoErr(err)
 	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
@@ -135,16 +139,17 @@ func TestDeletedSportsFieldOnlyOnce(t *testing.T) {
 	fc.Features[0].ID = 123 // id needs to be unique for each test
 	var aWeekAgo = time.Now().UTC().Add(-1 * 7 * 24 * time.Hour).Format("2006-01-02 15:04:05")
 	fc.Features[0].Properties.Deleted = &aWeekAgo
+	withEntitiesInBroker(ctxBrokerMock, diwise.SportsFieldTypeName, diwise.SportsFieldIDPrefix, "123")
 
 	ctx := context.Background()
 	storage := NewStorage(ctx)
-	err := storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err := storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 	is.NoErr(err)
 
 	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
 
 	// "store" again, this time no delete should be executed
-	err = storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err = storage.StoreSportsFieldsFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 	is.NoErr(err)
 
 	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
@@ -11,10 +11,10 @@ import (
 
 	"github.com/diwise/context-broker/pkg/datamodels/diwise"
 	"github.com/diwise/context-broker/pkg/ngsild/client"
-	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
 	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
 	"github.com/diwise/context-broker/pkg/ngsild/types/properties"
 	"github.com/diwise/context-broker/pkg/ngsild/types/relationships"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 
@@ -24,22 +24,44 @@ import (
 
 var ErrSportsVenueIsOfIgnoredType error = errors.New("sports venue is of non supported type")
 
-func (s *storageImpl) StoreSportsVenuesFromSource(ctx context.Context, ctxBrokerClient client.ContextBrokerClient, sourceURL string, featureCollection domain.FeatureCollection) error {
+func isSportsVenue(t string) bool {
+	return t == "Badhus" || t == "Ishall" || t == "Sporthall"
+}
 
-	logger := logging.GetFromContext(ctx)
+func (s *storageImpl) StoreSportsVenuesFromSource(ctx context.Context, ctxBrokerClient client.ContextBrokerClient, sourceURL string, features *Feed) error {
+	defer features.stop()
 
-	headers := map[string][]string{"Content-Type": {"application/ld+json"}}
+	logger := logging.GetFromContext(ctx)
+	run := s.tracker.BeginEntityType(TypeSportsVenues)
+	inFeed := map[int64]bool{}
+	deletions := []deletion{}
 
-	isSupportedType := func(t string) bool {
-		return t == "Badhus" || t == "Ishall" || t == "Sporthall"
+	index, err := prefetch(ctx, ctxBrokerClient, diwise.SportsVenueTypeName, diwise.SportsVenueIDPrefix)
+	if err != nil {
+		run.Done(err)
+		return err
 	}
 
-	for _, feature := range featureCollection.Features {
-		if isSupportedType(feature.Properties.Type) {
+	w := integrations.NewWriter(ctx, ctxBrokerClient, run, s.workers, integrations.WithIndex(index))
+
+	for feature := range features.All() {
+		if w.Err() != nil {
+			break
+		}
+
+		if isSportsVenue(feature.Properties.Type) {
+			run.Processed()
+			inFeed[feature.ID] = true
+
 			sportsVenue, err := parseSportsVenue(ctx, feature)
 			if err != nil {
-				if !errors.Is(err, ErrSportsVenueIsOfIgnoredType) {
+				if errors.Is(err, ErrSportsVenueIsOfIgnoredType) {
+					// an entity that was created before the type was ignored is an orphan
+					delete(inFeed, feature.ID)
+					run.Skipped()
+				} else {
 					logger.Error("failed to parse sports venue", slog.Int64("featureID", feature.ID), "err", err.Error())
+					run.Rejected(err)
 				}
 				continue
 			}
@@ -47,11 +69,10 @@ func (s *storageImpl) StoreSportsVenuesFromSource(ctx context.Context, ctxBroker
 			entityID := diwise.SportsVenueIDPrefix + sportsVenue.ID
 
 			if okToDel, alreadyDeleted := s.shouldBeDeleted(ctx, feature); okToDel {
-				if !alreadyDeleted {
-					_, err := ctxBrokerClient.DeleteEntity(ctx, entityID)
-					if err != nil {
-						logger.Info("could not delete entity", "entityID", entityID, "err", err.Error())
-					}
+				if alreadyDeleted {
+					run.Skipped()
+				} else {
+					deletions = append(deletions, deletion{featureID: feature.ID, entityID: entityID})
 				}
 				continue
 			}
@@ -60,36 +81,20 @@ func (s *storageImpl) StoreSportsVenuesFromSource(ctx context.Context, ctxBroker
 
 			attributes := convertDBSportsVenueToFiwareSportsVenue(*sportsVenue)
 
-			fragment, _ := entities.NewFragment(attributes...)
-
-			_, err = ctxBrokerClient.MergeEntity(ctx, entityID, fragment, headers)
-
-			// Throttle so we dont kill the broker
-			time.Sleep(100 * time.Millisecond)
+			w.UpsertChanges(s.fingerprints, entityID, diwise.SportsVenueTypeName, attributes)
+		}
+	}
 
-			if err != nil {
-				if !errors.Is(err, ngsierrors.ErrNotFound) {
-					logger.Error("failed to merge entity", "entityID", entityID, "err", err.Error())
-					logger.Info("waiting for context broker to recover...")
-					time.Sleep(10 * time.Second)
-					continue
-				}
-				entity, err := entities.New(entityID, diwise.SportsVenueTypeName, attributes...)
-				if err != nil {
-					logger.Error("entities.New failed", "entityID", entityID, "err", err.Error())
-					continue
-				}
+	if err = s.deleteFeatures(ctx, w, run, features, deletions); err == nil {
+		s.reconcile(ctx, w, run, diwise.SportsVenueIDPrefix, index, features, inFeed)
+	}
 
-				_, err = ctxBrokerClient.CreateEntity(ctx, entity, headers)
-				if err != nil {
-					logger.Error("failed to post sports venue to context broker", "entityID", entityID, "err", err.Error())
-					continue
-				}
-			}
-		}
+	if writeErr := w.Wait(); writeErr != nil {
+		err = writeErr
 	}
+	run.Done(err)
 
-	return nil
+	return err
 }
 
 func parseSportsVenue(ctx context.Context, feature domain.Feature) (*domain.SportsVenue, error) {
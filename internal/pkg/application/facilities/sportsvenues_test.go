@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/diwise/context-broker/pkg/datamodels/diwise"
 	"github.com/diwise/context-broker/pkg/ngsild"
 	"github.com/diwise/context-broker/pkg/ngsild/types"
 	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
@@ -33,10 +35,11 @@ func TestSportsVenueLoad(t *testing.T) {
 
 	ctx := context.Background()
 	storage := NewStorage(ctx)
-	err := storage.StoreSportsVenuesFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err := storage.StoreSportsVenuesFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 
 	is.NoErr(err)
-	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 1)
+	is.Equal(len(ctxBrokerMock.MergeEntityCalls()), 0) // the sports venue is not in the broker
+	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 1)
 }
 
 func TestSportsVenue(t *testing.T) {
@@ -48,13 +51,13 @@ func TestSportsVenue(t *testing.T) {
 		return &ngsild.CreateEntityResult{}, nil
 	}
 
-	client := NewClient(ctx, "apiKey", server.URL)
+	client := NewClient(ctx, server.URL)
 
-	featureCollection, err := client.Get(ctx)
+	featureCollection, err := download(ctx, client)
 	is.NoErr(err)
 
 	storage := NewStorage(ctx)
-	err = storage.StoreSportsVenuesFromSource(ctx, ctxBrokerMock, server.URL, *featureCollection)
+	err = storage.StoreSportsVenuesFromSource(ctx, ctxBrokerMock, server.URL, feedOf(featureCollection))
 	is.NoErr(err)
 
 	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 1)
@@ -74,13 +77,13 @@ func TestSportsVenueContainsManagedByAndOwnerProperties(t *testing.T) {
 		return &ngsild.CreateEntityResult{}, nil
 	}
 
-	client := NewClient(ctx, "apiKey", server.URL)
+	client := NewClient(ctx, server.URL)
 
-	featureCollection, err := client.Get(ctx)
+	featureCollection, err := download(ctx, client)
 	is.NoErr(err)
 
 	storage := NewStorage(ctx)
-	err = storage.StoreSportsVenuesFromSource(ctx, ctxBrokerMock, server.URL, *featureCollection)
+	err = storage.StoreSportsVenuesFromSource(ctx, ctxBrokerMock, server.URL, feedOf(featureCollection))
 	is.NoErr(err)
 
 	is.Equal(len(ctxBrokerMock.CreateEntityCalls()), 1)
@@ -101,10 +104,11 @@ func TestDeletedSportsVenue(t *testing.T) {
 
 	var aWeekAgo = time.Now().UTC().Add(-1 * 7 * 24 * time.Hour).Format("2006-01-02 15:04:05")
 	fc.Features[0].Properties.Deleted = &aWeekAgo
+	withEntitiesInBroker(ctxBrokerMock, diwise.SportsVenueTypeName, diwise.SportsVenueIDPrefix, strconv.FormatInt(fc.Features[0].ID, 10))
 
 	ctx := context.Background()
 	storage := NewStorage(ctx)
-	err := storage.StoreSportsVenuesFromSource(ctx, ctxBrokerMock, server.URL, fc)
+	err := storage.StoreSportsVenuesFromSource(ctx, ctxBrokerMock, server.URL, feedOf(fc))
 	is.NoErr(err)
 	is.Equal(len(ctxBrokerMock.DeleteEntityCalls()), 1)
 }
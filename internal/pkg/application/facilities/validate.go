@@ -0,0 +1,80 @@
+package facilities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+)
+
+// FeatureError describes a source feature that could not be converted into an entity
+type FeatureError struct {
+	FeatureID  int64
+	Name       string
+	SourceType string
+	EntityType string
+	Err        error
+}
+
+func (e FeatureError) Error() string {
+	return fmt.Sprintf("feature %d (%s %q) could not be converted into %s: %s", e.FeatureID, e.SourceType, e.Name, e.EntityType, e.Err.Error())
+}
+
+func (e FeatureError) Unwrap() error {
+	return e.Err
+}
+
+var parsers = []struct {
+	entityType string
+	matches    func(string) bool
+	parse      func(context.Context, domain.Feature) error
+	ignored    error
+}{
+	{TypeTrails, isExerciseTrail, func(ctx context.Context, f domain.Feature) error {
+		_, err := parseExerciseTrail(ctx, f)
+		return err
+	}, nil},
+	{TypeBeaches, isBeach, func(ctx context.Context, f domain.Feature) error {
+		_, err := parseBeach(ctx, f)
+		return err
+	}, nil},
+	{TypeSportsFields, isSportsField, func(ctx context.Context, f domain.Feature) error {
+		_, err := parseSportsField(ctx, f)
+		return err
+	}, ErrSportsFieldIsOfIgnoredType},
+	{TypeSportsVenues, isSportsVenue, func(ctx context.Context, f domain.Feature) error {
+		_, err := parseSportsVenue(ctx, f)
+		return err
+	}, ErrSportsVenueIsOfIgnoredType},
+}
+
+// ValidateFeatures parses every feature in the collection in the same way as a run would,
+// and returns the features that would be rejected. Features of types that are deliberately
+// ignored are not reported.
+func ValidateFeatures(ctx context.Context, featureCollection domain.FeatureCollection) (checked int, failures []FeatureError) {
+	for _, feature := range featureCollection.Features {
+		for _, p := range parsers {
+			if !p.matches(feature.Properties.Type) {
+				continue
+			}
+
+			checked++
+
+			err := p.parse(ctx, feature)
+			if err == nil || (p.ignored != nil && errors.Is(err, p.ignored)) {
+				continue
+			}
+
+			failures = append(failures, FeatureError{
+				FeatureID:  feature.ID,
+				Name:       feature.Properties.Name,
+				SourceType: feature.Properties.Type,
+				EntityType: p.entityType,
+				Err:        err,
+			})
+		}
+	}
+
+	return checked, failures
+}
@@ -0,0 +1,28 @@
+package facilities
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/domain"
+	"github.com/matryer/is"
+)
+
+func TestThatInvalidFeaturesAreReported(t *testing.T) {
+	is := is.New(t)
+
+	fc := domain.FeatureCollection{}
+	is.NoErr(json.Unmarshal([]byte(`{"type":"FeatureCollection","features":[
+		{"id":1,"type":"Feature","properties":{"name":"Stranden","type":"Strandbad","published":true,"fields":[]},"geometry":{"type":"MultiPolygon","coordinates":"not coordinates"}},
+		{"id":2,"type":"Feature","properties":{"name":"Opublicerad","type":"Strandbad","published":false},"geometry":{"type":"MultiPolygon","coordinates":[]}},
+		{"id":3,"type":"Feature","properties":{"name":"Något annat","type":"Lekplats","published":true},"geometry":{"type":"Point","coordinates":[17.3,62.4]}}
+	]}`), &fc))
+
+	checked, failures := ValidateFeatures(context.Background(), fc)
+
+	is.Equal(checked, 2)
+	is.Equal(len(failures), 1)
+	is.Equal(failures[0].FeatureID, int64(1))
+	is.Equal(failures[0].EntityType, TypeBeaches)
+}
@@ -0,0 +1,146 @@
+package integrations
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/auth"
+)
+
+// The ways in which an integration can authenticate against its source system, and the
+// service against the context broker
+const (
+	AuthNone   string = "none"
+	AuthAPIKey string = "apikey"
+	AuthBearer string = "bearer"
+	AuthOAuth2 string = "oauth2"
+	AuthMTLS   string = "mtls"
+)
+
+// CredentialsFromConfig returns the credentials for the source system of the named
+// integration, or for the context broker if the name is "broker". They authenticate as
+// defaultAuth unless configured otherwise by the following keys:
+//
+//	<NAME>_AUTH                   none, apikey, bearer, oauth2 or mtls
+//	<NAME>_API_KEY                the key for apikey
+//	<NAME>_API_KEY_HEADER         the header that carries the key (default apikey)
+//	<NAME>_BEARER_TOKEN           a static token for bearer
+//	<NAME>_OAUTH2_TOKEN_URL       the token endpoint for oauth2
+//	<NAME>_OAUTH2_CLIENT_ID
+//	<NAME>_OAUTH2_CLIENT_SECRET
+//	<NAME>_OAUTH2_SCOPES          space or comma separated scopes to request, if any
+//	<NAME>_TLS_CERT_FILE          a client certificate, required for mtls and presented
+//	<NAME>_TLS_KEY_FILE           along with an API key or access token if set
+//	<NAME>_TLS_CA_FILE            CA certificates to verify the source against, if not
+//	                              the system roots
+//
+// The API key, bearer token and client secret can also be read from a file, such as a
+// mounted secret, by setting <NAME>_API_KEY_FILE, <NAME>_BEARER_TOKEN_FILE or
+// <NAME>_OAUTH2_CLIENT_SECRET_FILE to its path. The bearer token is read again for every
+// request, and the client secret whenever a new access token is requested.
+func CredentialsFromConfig(name string, cfg Config, defaultAuth string) (auth.Credentials, error) {
+	prefix := strings.ToUpper(name)
+	creds := auth.Credentials{Authenticator: auth.None}
+
+	var errs []error
+
+	mode := cfg.Get(prefix + "_AUTH")
+	if mode == "" {
+		mode = defaultAuth
+	}
+
+	certFile, keyFile := cfg.Get(prefix+"_TLS_CERT_FILE"), cfg.Get(prefix+"_TLS_KEY_FILE")
+
+	if certFile != "" || keyFile != "" || mode == AuthMTLS {
+		tlsConfig, err := auth.ClientCertificate(certFile, keyFile, cfg.Get(prefix+"_TLS_CA_FILE"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s_TLS_CERT_FILE and %s_TLS_KEY_FILE: %w", prefix, prefix, err))
+		}
+		creds.TLS = tlsConfig
+	} else if caFile := cfg.Get(prefix + "_TLS_CA_FILE"); caFile != "" {
+		errs = append(errs, fmt.Errorf("%s_TLS_CA_FILE requires %s_TLS_CERT_FILE and %s_TLS_KEY_FILE", prefix, prefix, prefix))
+	}
+
+	switch mode {
+	case AuthNone, AuthMTLS:
+	case AuthAPIKey:
+		key, err := secretValue(cfg, prefix+"_API_KEY")
+		if err != nil {
+			errs = append(errs, err)
+		} else if key == "" {
+			errs = append(errs, fmt.Errorf("please set %s_API_KEY or %s_API_KEY_FILE to a valid API key", prefix, prefix))
+		}
+
+		header := cfg.Get(prefix + "_API_KEY_HEADER")
+		if header == "" {
+			header = "apikey"
+		}
+
+		creds.Authenticator = auth.APIKey(header, key)
+	case AuthBearer:
+		token, err := secretSource(cfg, prefix+"_BEARER_TOKEN")
+		if err != nil {
+			errs = append(errs, err)
+		} else if cfg.Get(prefix+"_BEARER_TOKEN") == "" && cfg.Get(prefix+"_BEARER_TOKEN_FILE") == "" {
+			errs = append(errs, fmt.Errorf("please set %s_BEARER_TOKEN or %s_BEARER_TOKEN_FILE", prefix, prefix))
+		}
+
+		creds.Authenticator = auth.BearerToken(token)
+	case AuthOAuth2:
+		tokenURL, clientID := cfg.Get(prefix+"_OAUTH2_TOKEN_URL"), cfg.Get(prefix+"_OAUTH2_CLIENT_ID")
+		if tokenURL == "" || clientID == "" {
+			errs = append(errs, fmt.Errorf("please set %s_OAUTH2_TOKEN_URL and %s_OAUTH2_CLIENT_ID", prefix, prefix))
+		}
+
+		secret, err := secretSource(cfg, prefix+"_OAUTH2_CLIENT_SECRET")
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		options := []func(*auth.ClientCredentials){
+			auth.WithScopes(strings.FieldsFunc(cfg.Get(prefix+"_OAUTH2_SCOPES"), func(r rune) bool { return r == ' ' || r == ',' })...),
+		}
+
+		// the token endpoint of a gateway that requires a client certificate requires it as well
+		if creds.TLS != nil {
+			options = append(options, auth.WithTokenTransport(auth.TLSTransport(creds.TLS)))
+		}
+
+		creds.Authenticator = auth.NewClientCredentials(tokenURL, clientID, secret, options...)
+	default:
+		errs = append(errs, fmt.Errorf("%s_AUTH must be one of %s, %s, %s, %s or %s, not %q", prefix, AuthNone, AuthAPIKey, AuthBearer, AuthOAuth2, AuthMTLS, mode))
+	}
+
+	return creds, errors.Join(errs...)
+}
+
+// secretValue returns the value of key, or the contents of the file named by key_FILE
+func secretValue(cfg Config, key string) (string, error) {
+	secret, err := secretSource(cfg, key)
+	if err != nil {
+		return "", err
+	}
+	return secret()
+}
+
+// secretSource returns a secret that holds the value of key, or that reads the file named
+// by key_FILE whenever it is needed
+func secretSource(cfg Config, key string) (auth.Secret, error) {
+	value, path := cfg.Get(key), cfg.Get(key+"_FILE")
+
+	if value != "" && path != "" {
+		return auth.StaticSecret(value), fmt.Errorf("%s and %s_FILE can not both be set", key, key)
+	}
+
+	if path == "" {
+		return auth.StaticSecret(value), nil
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return auth.SecretFile(path), fmt.Errorf("%s_FILE: %w", key, err)
+	}
+
+	return auth.SecretFile(path), nil
+}
@@ -0,0 +1,78 @@
+package integrations
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/auth"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/auth/authtest"
+	"github.com/matryer/is"
+)
+
+func TestThatAnAPIKeyIsRequiredByDefault(t *testing.T) {
+	is := is.New(t)
+
+	_, err := CredentialsFromConfig("facilities", mapConfig{}, AuthAPIKey)
+	is.True(err != nil)
+
+	path := filepath.Join(t.TempDir(), "apikey")
+	is.NoErr(os.WriteFile(path, []byte("s3cr3t\n"), 0600))
+
+	creds, err := CredentialsFromConfig("facilities", mapConfig{"FACILITIES_API_KEY_FILE": path, "FACILITIES_API_KEY_HEADER": "X-Api-Key"}, AuthAPIKey)
+	is.NoErr(err)
+	is.Equal(creds.Authenticator, auth.APIKey("X-Api-Key", "s3cr3t"))
+
+	_, err = CredentialsFromConfig("facilities", mapConfig{"FACILITIES_API_KEY": "abc", "FACILITIES_API_KEY_FILE": path}, AuthAPIKey)
+	is.True(err != nil)
+}
+
+func TestThatOAuth2CredentialsAreReadFromConfig(t *testing.T) {
+	is := is.New(t)
+
+	ts := authtest.NewTokenServer("integration-cip-sdl", "s3cr3t", time.Hour)
+	defer ts.Close()
+
+	source := httptest.NewServer(ts.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer source.Close()
+
+	path := filepath.Join(t.TempDir(), "client-secret")
+	is.NoErr(os.WriteFile(path, []byte("s3cr3t"), 0600))
+
+	creds, err := CredentialsFromConfig("citywork", mapConfig{
+		"CITYWORK_AUTH":                      AuthOAuth2,
+		"CITYWORK_OAUTH2_TOKEN_URL":          ts.TokenURL(),
+		"CITYWORK_OAUTH2_CLIENT_ID":          "integration-cip-sdl",
+		"CITYWORK_OAUTH2_CLIENT_SECRET_FILE": path,
+		"CITYWORK_OAUTH2_SCOPES":             "citywork,read",
+	}, AuthNone)
+	is.NoErr(err)
+
+	c := http.Client{Transport: creds.Transport()}
+	resp, err := c.Get(source.URL)
+	is.NoErr(err)
+	resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(ts.Scope(), "citywork read")
+}
+
+func TestThatInvalidCredentialsAreReported(t *testing.T) {
+	is := is.New(t)
+
+	_, err := CredentialsFromConfig("citywork", mapConfig{"CITYWORK_AUTH": "kerberos"}, AuthNone)
+	is.True(err != nil)
+
+	_, err = CredentialsFromConfig("citywork", mapConfig{"CITYWORK_AUTH": AuthOAuth2}, AuthNone)
+	is.True(err != nil)
+
+	_, err = CredentialsFromConfig("citywork", mapConfig{"CITYWORK_AUTH": AuthMTLS}, AuthNone)
+	is.True(err != nil)
+
+	creds, err := CredentialsFromConfig("citywork", mapConfig{}, AuthNone)
+	is.NoErr(err)
+	is.Equal(creds.Authenticator, auth.None)
+}
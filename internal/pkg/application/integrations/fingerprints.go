@@ -0,0 +1,211 @@
+package integrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+)
+
+// DefaultFingerprintAge is how long an entity is trusted to be unchanged in the broker
+// before all of its attributes are written again. This repairs entities that have been
+// changed or removed by someone else.
+const DefaultFingerprintAge time.Duration = 24 * time.Hour
+
+// Fingerprints remembers a fingerprint of every attribute that has been written to the
+// context broker, per entity, so that entities and attributes that have not changed since
+// they were last written do not have to be written again.
+//
+// A nil Fingerprints treats every attribute as changed.
+type Fingerprints struct {
+	maxAge  time.Duration
+	entries map[string]*fingerprint
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+type fingerprint struct {
+	// Attributes maps the name of each attribute to a hash of its contents
+	Attributes map[string]string `json:"attributes"`
+	Written    time.Time         `json:"written"`
+}
+
+// NewFingerprints returns an empty set of fingerprints that expire after maxAge
+func NewFingerprints(maxAge time.Duration) *Fingerprints {
+	return &Fingerprints{
+		maxAge:  maxAge,
+		entries: map[string]*fingerprint{},
+		now:     time.Now,
+	}
+}
+
+// Changes returns the attributes that differ from what was last written to the entity,
+// or all of them if the entity is unknown or its fingerprint has expired. An attribute
+// that can not be fingerprinted is always considered changed. The returned function
+// records the attributes as written, and should be called once the write has succeeded.
+func (f *Fingerprints) Changes(entityID, entityType string, attributes []entities.EntityDecoratorFunc) ([]entities.EntityDecoratorFunc, func()) {
+	if f == nil {
+		return attributes, func() {}
+	}
+
+	current := map[string]string{}
+	hashes := make([]map[string]string, len(attributes))
+
+	for i, attr := range attributes {
+		hashes[i] = attributeHashes(entityID, entityType, attr)
+		maps.Copy(current, hashes[i])
+	}
+
+	f.mu.Lock()
+	previous, ok := f.entries[entityID]
+	if ok && f.now().Sub(previous.Written) > f.maxAge {
+		ok = false
+	}
+	f.mu.Unlock()
+
+	changed := attributes
+
+	if ok {
+		changed = nil
+		for i, attr := range attributes {
+			if hashes[i] == nil || differs(hashes[i], previous.Attributes) {
+				changed = append(changed, attr)
+			}
+		}
+	}
+
+	commit := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		written := f.now()
+		if ok {
+			// a partial write does not prove that the rest of the entity is still in place
+			written = previous.Written
+		}
+
+		f.entries[entityID] = &fingerprint{Attributes: current, Written: written}
+	}
+
+	return changed, commit
+}
+
+// Seed fingerprints an entity as it is in the broker, unless it already has a fingerprint
+func (f *Fingerprints) Seed(entityID string, entity types.Entity) {
+	if f == nil {
+		return
+	}
+
+	hashes := entityHashes(entity)
+	if hashes == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.entries[entityID]; !ok {
+		f.entries[entityID] = &fingerprint{Attributes: hashes, Written: f.now()}
+	}
+}
+
+// Has reports whether the attribute was part of the entity when it was last written, e.g. so
+// that a value that has been cleared in the source can be cleared in the broker as well
+func (f *Fingerprints) Has(entityID, attributeName string) bool {
+	if f == nil {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	previous, ok := f.entries[entityID]
+	if !ok {
+		return false
+	}
+
+	_, ok = previous.Attributes[attributeName]
+	return ok
+}
+
+// Forget drops the fingerprint of an entity, e.g. because it has been deleted
+func (f *Fingerprints) Forget(entityID string) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.entries, entityID)
+}
+
+func (f *Fingerprints) MarshalJSON() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return json.Marshal(f.entries)
+}
+
+func (f *Fingerprints) UnmarshalJSON(data []byte) error {
+	entries := map[string]*fingerprint{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.entries = entries
+	return nil
+}
+
+// attributeHashes returns a hash of every attribute that the decorator adds to an entity,
+// or nil if the attributes can not be serialized
+func attributeHashes(entityID, entityType string, attr entities.EntityDecoratorFunc) map[string]string {
+	e, err := entities.New(entityID, entityType, attr)
+	if err != nil {
+		return nil
+	}
+
+	return entityHashes(e)
+}
+
+// entityHashes returns a hash of every attribute of the entity, or nil if it can not be serialized
+func entityHashes(e types.Entity) map[string]string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil
+	}
+
+	contents := map[string]json.RawMessage{}
+	if err = json.Unmarshal(b, &contents); err != nil {
+		return nil
+	}
+
+	hashes := map[string]string{}
+	for name, value := range contents {
+		if name == "id" || name == "type" || name == "@context" {
+			continue
+		}
+
+		sum := sha256.Sum256(value)
+		hashes[name] = hex.EncodeToString(sum[:8])
+	}
+
+	return hashes
+}
+
+func differs(current, previous map[string]string) bool {
+	for name, hash := range current {
+		if previous[name] != hash {
+			return true
+		}
+	}
+	return false
+}
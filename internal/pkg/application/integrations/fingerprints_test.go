@@ -0,0 +1,83 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
+	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/matryer/is"
+)
+
+func TestThatOnlyChangedAttributesAreReturned(t *testing.T) {
+	is := is.New(t)
+
+	f := NewFingerprints(time.Hour)
+
+	changed, commit := f.Changes("urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Sandig"))
+	is.Equal(len(changed), 2)
+	commit()
+
+	changed, _ = f.Changes("urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Sandig"))
+	is.Equal(len(changed), 0)
+
+	changed, _ = f.Changes("urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Stenig"))
+	is.Equal(len(changed), 1)
+
+	// an expired fingerprint makes every attribute count as changed
+	f.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	changed, _ = f.Changes("urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Sandig"))
+	is.Equal(len(changed), 2)
+}
+
+func TestThatFingerprintsCanBeSavedAndRestored(t *testing.T) {
+	is := is.New(t)
+
+	before := NewFingerprints(time.Hour)
+	_, commit := before.Changes("urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Sandig"))
+	commit()
+
+	data, err := json.Marshal(before)
+	is.NoErr(err)
+
+	after := NewFingerprints(time.Hour)
+	is.NoErr(json.Unmarshal(data, after))
+
+	changed, _ := after.Changes("urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Sandig"))
+	is.Equal(len(changed), 0)
+}
+
+func TestThatAMissingEntityIsCreatedWithAllAttributes(t *testing.T) {
+	is := is.New(t)
+
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return nil, ngsierrors.ErrNotFound
+		},
+		CreateEntityFunc: func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+			return ngsild.NewCreateEntityResult(""), nil
+		},
+	}
+
+	f := NewFingerprints(time.Hour)
+	_, commit := f.Changes("urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Sandig"))
+	commit()
+
+	tracker := NewTracker("fingerprinttest")
+	w := NewWriter(context.Background(), broker, tracker.BeginEntityType("beaches"), 1)
+	w.UpsertChanges(f, "urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Stenig"))
+	is.NoErr(w.Wait())
+
+	created, _ := json.Marshal(broker.CreateEntityCalls()[0].Entity)
+	is.Equal(string(created), `{"@context":["https://raw.githubusercontent.com/diwise/context-broker/main/assets/jsonldcontexts/default-context.jsonld"],"description":{"type":"Property","value":"Stenig"},"id":"urn:ngsi-ld:Beach:1","name":{"type":"Property","value":"Stranden"},"type":"Beach"}`)
+}
+
+func beach(name, description string) []entities.EntityDecoratorFunc {
+	return []entities.EntityDecoratorFunc{decorators.Name(name), decorators.Description(description)}
+}
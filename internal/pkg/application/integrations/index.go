@@ -0,0 +1,52 @@
+package integrations
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+)
+
+// Index holds the entities of a single type that were in the context broker at the start
+// of a run, so that a writer can tell whether to create, merge or skip an entity without
+// asking the broker first.
+//
+// A nil index knows nothing, and makes a writer ask the broker instead. An index is not
+// changed after it has been built, and is safe for concurrent use.
+type Index struct {
+	entities map[string]types.Entity
+}
+
+// PrefetchEntities builds an index of every entity of the given type whose id starts with
+// the prefix, requesting the entities from the broker one page at a time
+func PrefetchEntities(ctx context.Context, broker client.ContextBrokerClient, entityType, idPrefix string) (*Index, error) {
+	ix := &Index{entities: map[string]types.Entity{}}
+
+	err := QueryEntities(ctx, broker, entityType, idPrefix, func(e types.Entity) {
+		ix.entities[e.ID()] = e
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// Get returns the entity as it was in the broker at the start of the run
+func (ix *Index) Get(entityID string) (types.Entity, bool) {
+	e, ok := ix.entities[entityID]
+	return e, ok
+}
+
+// Contains tells if the entity is in the broker
+func (ix *Index) Contains(entityID string) bool {
+	_, ok := ix.entities[entityID]
+	return ok
+}
+
+// IDs returns the ids of the entities in the broker, sorted
+func (ix *Index) IDs() []string {
+	return slices.Sorted(maps.Keys(ix.entities))
+}
@@ -0,0 +1,194 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/schedule"
+)
+
+// Integration is a source of information that is periodically synchronised
+// with the context broker.
+type Integration interface {
+	Name() string
+	// Validate reports all problems with the configuration of the integration
+	Validate(ctx context.Context) error
+	// Run performs a single synchronisation pass, limited to the given entity types if any
+	Run(ctx context.Context, entityTypes ...string) error
+	Status() Status
+}
+
+// Config provides access to configuration values, keyed by their environment
+// variable names.
+type Config interface {
+	Get(key string) string
+}
+
+type envConfig struct{}
+
+func (envConfig) Get(key string) string {
+	return os.Getenv(key)
+}
+
+// EnvConfig returns a Config that reads its values from the environment
+func EnvConfig() Config {
+	return envConfig{}
+}
+
+// Factory creates a new instance of an integration
+type Factory func(ctx context.Context, cfg Config, brokers Brokers) Integration
+
+// Settings controls if and when an integration is run. Every registered integration
+// gets its settings from the following configuration keys:
+//
+//	<NAME>_ENABLED            true or false
+//	<NAME>_POLLING_INTERVAL   a schedule as understood by schedule.Parse, e.g. "58", "10m" or "*/10 5-21 * * *"
+//	<NAME>_POLLING_JITTER     an optional random delay that is added before each run, e.g. "2m"
+//	<NAME>_RETRY_INTERVAL     time to wait before retrying a failed run, in minutes or as a duration
+//	<NAME>_MAX_SYNC_AGE       the integration is reported as unhealthy if it has not succeeded within
+//	                          this duration, or never if set to 0
+//	<NAME>_ENTITY_TYPES       a comma separated list of the entity types to synchronise, or all if empty
+type Settings struct {
+	Enabled       bool
+	Schedule      schedule.Schedule
+	Jitter        time.Duration
+	RetryInterval time.Duration
+	MaxSyncAge    time.Duration
+	EntityTypes   []string
+}
+
+type registration struct {
+	factory  Factory
+	defaults Settings
+}
+
+var (
+	registry map[string]registration = map[string]registration{}
+	mu       sync.Mutex
+)
+
+// Register makes an integration available under the given name. It is meant to
+// be called from the init function of the package implementing the integration.
+func Register(name string, factory Factory, defaults Settings) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("integration %s is already registered", name))
+	}
+
+	registry[name] = registration{factory: factory, defaults: defaults}
+}
+
+// Names returns the names of all registered integrations in alphabetical order
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// New creates the named integration and resolves its settings from the supplied configuration
+func New(ctx context.Context, name string, cfg Config, brokers Brokers) (Integration, Settings, error) {
+	mu.Lock()
+	reg, ok := registry[name]
+	mu.Unlock()
+
+	if !ok {
+		return nil, Settings{}, fmt.Errorf("no integration named %s has been registered", name)
+	}
+
+	settings, err := settingsFromConfig(name, cfg, reg.defaults)
+	if err != nil {
+		return nil, settings, err
+	}
+
+	i := reg.factory(ctx, cfg, brokers)
+
+	if err = SupportsEntityTypes(i, settings.EntityTypes...); err != nil {
+		return nil, settings, fmt.Errorf("%s_ENTITY_TYPES: %w", strings.ToUpper(name), err)
+	}
+
+	entityTypes := []string{""}
+	if lister, ok := i.(EntityTypeLister); ok {
+		entityTypes = append(entityTypes, lister.EntityTypes()...)
+	}
+
+	var errs []error
+	for _, entityType := range entityTypes {
+		_, err := JSONLDContextFromConfig(name, entityType, cfg)
+		// a key that every entity type falls back on is only reported once
+		if err != nil && !slices.ContainsFunc(errs, func(e error) bool { return e.Error() == err.Error() }) {
+			errs = append(errs, err)
+		}
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return nil, settings, err
+	}
+
+	// create the clients of every tenant now, rather than while the integrations are running
+	for _, entityType := range entityTypes {
+		brokers(entityType)
+	}
+
+	return i, settings, nil
+}
+
+func settingsFromConfig(name string, cfg Config, defaults Settings) (Settings, error) {
+	var errs []error
+
+	prefix := strings.ToUpper(name)
+	settings := defaults
+
+	if enabled := cfg.Get(prefix + "_ENABLED"); enabled != "" {
+		settings.Enabled = (enabled == "true")
+	}
+
+	if spec := cfg.Get(prefix + "_POLLING_INTERVAL"); spec != "" {
+		sched, err := schedule.Parse(spec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s_POLLING_INTERVAL: %w", prefix, err))
+		} else {
+			settings.Schedule = sched
+		}
+	}
+
+	duration := func(key string, value *time.Duration) {
+		if str := cfg.Get(key); str != "" {
+			d, err := schedule.ParseDuration(str)
+			if err != nil || d < 0 {
+				errs = append(errs, fmt.Errorf("%s must be set to a number of minutes or a valid duration", key))
+				return
+			}
+			*value = d
+		}
+	}
+
+	duration(prefix+"_POLLING_JITTER", &settings.Jitter)
+	duration(prefix+"_RETRY_INTERVAL", &settings.RetryInterval)
+	duration(prefix+"_MAX_SYNC_AGE", &settings.MaxSyncAge)
+
+	if types := cfg.Get(prefix + "_ENTITY_TYPES"); types != "" {
+		settings.EntityTypes = []string{}
+		for t := range strings.SplitSeq(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				settings.EntityTypes = append(settings.EntityTypes, t)
+			}
+		}
+	}
+
+	return settings, errors.Join(errs...)
+}
@@ -0,0 +1,69 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/schedule"
+	"github.com/matryer/is"
+)
+
+func TestThatSettingsCanBeOverriddenByConfig(t *testing.T) {
+	is := is.New(t)
+
+	Register("settingstest", newTestIntegration, Settings{Schedule: schedule.Every(time.Hour), RetryInterval: time.Minute})
+
+	cfg := mapConfig{"SETTINGSTEST_ENABLED": "true", "SETTINGSTEST_POLLING_INTERVAL": "10", "SETTINGSTEST_POLLING_JITTER": "30s"}
+	i, settings, err := New(context.Background(), "settingstest", cfg, SingleBroker(nil))
+
+	is.NoErr(err)
+	is.Equal(i.Name(), "settingstest")
+	is.True(settings.Enabled)
+	is.Equal(settings.Jitter, 30*time.Second)
+
+	now := time.Now()
+	is.Equal(settings.Schedule.Next(now), now.Add(10*time.Minute))
+	is.Equal(settings.RetryInterval, time.Minute)
+}
+
+func TestThatInvalidSettingsAreReported(t *testing.T) {
+	is := is.New(t)
+
+	Register("invalidtest", newTestIntegration, Settings{})
+
+	cfg := mapConfig{"INVALIDTEST_POLLING_INTERVAL": "* * *", "INVALIDTEST_RETRY_INTERVAL": "soon"}
+	_, _, err := New(context.Background(), "invalidtest", cfg, SingleBroker(nil))
+
+	is.True(err != nil)
+	is.Equal(err.Error(), "INVALIDTEST_POLLING_INTERVAL: invalid schedule \"* * *\": expected 5 fields in cron expression, but found 3\nINVALIDTEST_RETRY_INTERVAL must be set to a number of minutes or a valid duration")
+}
+
+func TestThatEntityTypesMustBeSupported(t *testing.T) {
+	is := is.New(t)
+
+	Register("entitytypestest", newTestIntegration, Settings{})
+
+	_, _, err := New(context.Background(), "entitytypestest", mapConfig{"ENTITYTYPESTEST_ENTITY_TYPES": "beaches"}, SingleBroker(nil))
+	is.True(errors.Is(err, ErrUnsupportedType))
+}
+
+type mapConfig map[string]string
+
+func (m mapConfig) Get(key string) string {
+	return m[key]
+}
+
+type testIntegration struct {
+	tracker *Tracker
+}
+
+func newTestIntegration(ctx context.Context, cfg Config, brokers Brokers) Integration {
+	return &testIntegration{tracker: NewTracker("settingstest")}
+}
+
+func (ti *testIntegration) Name() string                                   { return "settingstest" }
+func (ti *testIntegration) Validate(ctx context.Context) error             { return nil }
+func (ti *testIntegration) Run(ctx context.Context, types ...string) error { return nil }
+func (ti *testIntegration) Status() Status                                 { return ti.tracker.Status() }
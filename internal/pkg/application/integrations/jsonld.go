@@ -0,0 +1,76 @@
+package integrations
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/jsonld"
+)
+
+// The ways in which the JSON-LD context of an entity can be sent to the context broker
+const (
+	JSONLDContextInline string = "inline"
+	JSONLDContextLink   string = "link"
+)
+
+// JSONLDContextFromConfig returns the JSON-LD context that an entity type of the named
+// integration is written with. An empty entity type stands for the integration as a whole.
+// Like the tenant, the context is configured by the most specific of the following keys:
+//
+//	<NAME>_<TYPE>_JSONLD_CONTEXT   the url of the context of an entity type
+//	<NAME>_JSONLD_CONTEXT          the url of the context of an integration
+//	BROKER_JSONLD_CONTEXT          the url of the context of everything else (default
+//	                               the diwise default context)
+//
+// and sent inline, or in a Link header, as configured by the same keys with a _MODE suffix.
+// jsonld.Default is returned along with any error.
+func JSONLDContextFromConfig(name, entityType string, cfg Config) (jsonld.Context, error) {
+	var errs []error
+
+	context := jsonld.Default
+
+	key, value := mostSpecific(cfg, name, entityType, "JSONLD_CONTEXT")
+	if value != "" {
+		if u, err := url.Parse(value); err != nil || !u.IsAbs() {
+			errs = append(errs, fmt.Errorf("%s must be set to the absolute url of a JSON-LD context, not %q", key, value))
+		} else {
+			context.URL = value
+		}
+	}
+
+	key, mode := mostSpecific(cfg, name, entityType, "JSONLD_CONTEXT_MODE")
+	switch mode {
+	case "", JSONLDContextInline:
+	case JSONLDContextLink:
+		context.Link = true
+	default:
+		errs = append(errs, fmt.Errorf("%s must be %s or %s, not %q", key, JSONLDContextInline, JSONLDContextLink, mode))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return jsonld.Default, err
+	}
+
+	return context, nil
+}
+
+// mostSpecific returns the first key that is set, and its value, of <NAME>_<TYPE>_<KEY>,
+// <NAME>_<KEY> and BROKER_<KEY>
+func mostSpecific(cfg Config, name, entityType, key string) (string, string) {
+	prefix := strings.ToUpper(name)
+
+	keys := []string{prefix + "_" + key, "BROKER_" + key}
+	if entityType != "" {
+		keys = append([]string{prefix + "_" + strings.ToUpper(entityType) + "_" + key}, keys...)
+	}
+
+	for _, k := range keys {
+		if value := cfg.Get(k); value != "" {
+			return k, value
+		}
+	}
+
+	return keys[len(keys)-1], ""
+}
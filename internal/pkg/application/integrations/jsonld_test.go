@@ -0,0 +1,42 @@
+package integrations
+
+import (
+	"testing"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/jsonld"
+	"github.com/matryer/is"
+)
+
+func TestThatTheMostSpecificJSONLDContextIsUsed(t *testing.T) {
+	is := is.New(t)
+
+	context, err := JSONLDContextFromConfig("facilities", "beaches", mapConfig{})
+	is.NoErr(err)
+	is.Equal(context, jsonld.Default)
+
+	cfg := mapConfig{
+		"BROKER_JSONLD_CONTEXT":                  "https://example.com/broker.jsonld",
+		"FACILITIES_BEACHES_JSONLD_CONTEXT":      "https://example.com/beaches.jsonld",
+		"FACILITIES_BEACHES_JSONLD_CONTEXT_MODE": JSONLDContextLink,
+	}
+
+	context, err = JSONLDContextFromConfig("facilities", "beaches", cfg)
+	is.NoErr(err)
+	is.Equal(context, jsonld.Context{URL: "https://example.com/beaches.jsonld", Link: true})
+
+	context, err = JSONLDContextFromConfig("facilities", "trails", cfg)
+	is.NoErr(err)
+	is.Equal(context, jsonld.Context{URL: "https://example.com/broker.jsonld"})
+}
+
+func TestThatInvalidJSONLDContextsAreReported(t *testing.T) {
+	is := is.New(t)
+
+	context, err := JSONLDContextFromConfig("citywork", "", mapConfig{
+		"CITYWORK_JSONLD_CONTEXT":    "context.jsonld",
+		"BROKER_JSONLD_CONTEXT_MODE": "header",
+	})
+
+	is.True(err != nil)
+	is.Equal(context, jsonld.Default)
+}
@@ -0,0 +1,169 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FetchLimits bound the time and memory that a single request to a source system may use
+type FetchLimits struct {
+	// Timeout is how long a request may take, including reading the whole response
+	Timeout time.Duration
+	// MaxBodySize is the largest response body, in bytes, that will be read
+	MaxBodySize int64
+}
+
+// DefaultFetchLimits are used by source clients unless they are configured otherwise
+var DefaultFetchLimits = FetchLimits{Timeout: time.Minute, MaxBodySize: 64 << 20}
+
+// BodyTooLargeError is returned while reading a response body that is larger than allowed
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("the response body is larger than the limit of %d bytes", e.Limit)
+}
+
+// Permanent tells the retry package not to request a body that is too large again
+func (e *BodyTooLargeError) Permanent() bool {
+	return true
+}
+
+// InterruptedError is returned when a response fails after some of its features have been
+// handed over to be stored. It is never retried, since a retry would hand the same features
+// over again.
+type InterruptedError struct {
+	Err    error
+	Handed int
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("the response failed after %d features: %s", e.Handed, e.Err.Error())
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent tells the retry package not to request a response that was partly handed over again
+func (e *InterruptedError) Permanent() bool {
+	return true
+}
+
+// FetchLimitsFromConfig returns the limits for requests to the source system of the named
+// integration. They are configured by the following keys:
+//
+//	<NAME>_FETCH_TIMEOUT   how long a request may take, e.g. "30s" (default 1m)
+//	<NAME>_MAX_BODY_SIZE   the largest response body in bytes, or with a KiB, MiB or GiB
+//	                       suffix, e.g. "64MiB" (the default)
+//
+// DefaultFetchLimits provides the values that are not set, and is returned along with any error.
+func FetchLimitsFromConfig(name string, cfg Config) (FetchLimits, error) {
+	prefix := strings.ToUpper(name)
+	limits := DefaultFetchLimits
+
+	var errs []error
+
+	if value := cfg.Get(prefix + "_FETCH_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s_FETCH_TIMEOUT must be set to a positive duration, such as 30s or 2m, not %q", prefix, value))
+		}
+		limits.Timeout = d
+	}
+
+	if value := cfg.Get(prefix + "_MAX_BODY_SIZE"); value != "" {
+		size, err := parseSize(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s_MAX_BODY_SIZE must be set to a positive number of bytes, such as 64MiB, not %q", prefix, value))
+		}
+		limits.MaxBodySize = size
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return DefaultFetchLimits, err
+	}
+
+	return limits, nil
+}
+
+// WithTimeout returns a context that ends a request when it has taken too long
+func (l FetchLimits) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, l.Timeout)
+}
+
+// Body returns the body of the response, which fails with a BodyTooLargeError as soon as
+// more than MaxBodySize bytes have been read, or before anything is read if the response
+// announces a larger body
+func (l FetchLimits) Body(resp *http.Response) io.Reader {
+	if l.MaxBodySize <= 0 {
+		return resp.Body
+	}
+
+	if resp.ContentLength > l.MaxBodySize {
+		return &limitedBody{limit: l.MaxBodySize}
+	}
+
+	return &limitedBody{r: resp.Body, remaining: l.MaxBodySize, limit: l.MaxBodySize}
+}
+
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+	limit     int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.r == nil {
+		return 0, &BodyTooLargeError{Limit: b.limit}
+	}
+
+	// read one byte more than allowed to tell a body that fits exactly from one that does not
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.r.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+
+	n = int(b.remaining)
+	b.remaining = 0
+	b.r = nil
+
+	return n, &BodyTooLargeError{Limit: b.limit}
+}
+
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+
+	for suffix, m := range map[string]int64{"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			value, multiplier = strings.TrimSpace(number), m
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if size <= 0 {
+		return 0, fmt.Errorf("size must be positive")
+	}
+
+	return size * multiplier, nil
+}
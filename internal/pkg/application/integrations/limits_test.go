@@ -0,0 +1,51 @@
+package integrations
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/retry"
+	"github.com/matryer/is"
+)
+
+func TestThatFetchLimitsAreReadFromConfig(t *testing.T) {
+	is := is.New(t)
+
+	limits, err := FetchLimitsFromConfig("facilities", mapConfig{"FACILITIES_FETCH_TIMEOUT": "30s", "FACILITIES_MAX_BODY_SIZE": "16MiB"})
+	is.NoErr(err)
+	is.Equal(limits, FetchLimits{Timeout: 30 * time.Second, MaxBodySize: 16 << 20})
+
+	limits, err = FetchLimitsFromConfig("facilities", mapConfig{"FACILITIES_FETCH_TIMEOUT": "-1s", "FACILITIES_MAX_BODY_SIZE": "lots"})
+	is.True(err != nil)
+	is.Equal(limits, DefaultFetchLimits)
+}
+
+func TestThatABodyLargerThanTheLimitIsNotRead(t *testing.T) {
+	is := is.New(t)
+
+	limits := FetchLimits{MaxBodySize: 10}
+
+	response := func(body string, contentLength int64) *http.Response {
+		return &http.Response{Body: io.NopCloser(strings.NewReader(body)), ContentLength: contentLength}
+	}
+
+	b, err := io.ReadAll(limits.Body(response("0123456789", -1)))
+	is.NoErr(err)
+	is.Equal(string(b), "0123456789")
+
+	b, err = io.ReadAll(limits.Body(response("0123456789A", -1)))
+	var tooLarge *BodyTooLargeError
+	is.True(errors.As(err, &tooLarge))
+	is.Equal(len(b), 10)
+
+	// a body that announces its size is refused before it is read
+	b, err = io.ReadAll(limits.Body(response("0123456789A", 11)))
+	is.True(errors.As(err, &tooLarge))
+	is.Equal(len(b), 0)
+
+	is.True(retry.IsPermanent(err))
+}
@@ -0,0 +1,501 @@
+package integrations
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/circuitbreaker"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/lifecycle"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/retry"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/schedule"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/state"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// EntityTypeLister is implemented by integrations that can limit a run to a
+// subset of the entity types that they produce.
+type EntityTypeLister interface {
+	EntityTypes() []string
+}
+
+var ErrNotFound error = errors.New("not found")
+var ErrNotStarted error = errors.New("manager has not been started")
+var ErrUnsupportedType error = errors.New("unsupported entity type")
+
+type RunState string
+
+const (
+	RunQueued    RunState = "queued"
+	RunRunning   RunState = "running"
+	RunSucceeded RunState = "succeeded"
+	RunFailed    RunState = "failed"
+)
+
+// RunInfo describes a single run of an integration
+type RunInfo struct {
+	ID          string    `json:"id"`
+	Integration string    `json:"integration"`
+	EntityTypes []string  `json:"entityTypes,omitempty"`
+	Trigger     string    `json:"trigger"`
+	State       RunState  `json:"state"`
+	Queued      time.Time `json:"queued"`
+	Started     time.Time `json:"started,omitzero"`
+	Finished    time.Time `json:"finished,omitzero"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// maxRunHistory limits the number of runs that are remembered by the manager
+const maxRunHistory int = 100
+
+type runner struct {
+	integration Integration
+	settings    Settings
+	// queued is the triggered run that waits for the current run of the integration to
+	// complete, if there is one. It is guarded by the lock of the manager.
+	queued *queuedRun
+	// mu makes sure that only one run per integration is in progress at any time
+	mu sync.Mutex
+}
+
+// queuedRun is a triggered run that has not started yet
+type queuedRun struct {
+	id          string
+	entityTypes []string
+}
+
+// Manager runs integrations according to their schedules and allows runs to
+// be triggered on demand.
+type Manager struct {
+	runners map[string]*runner
+	store   state.Store
+	breaker *circuitbreaker.Breaker
+	outbox  *Outbox
+
+	ctx     context.Context
+	started time.Time
+	wg      sync.WaitGroup
+
+	runs    map[string]*RunInfo
+	history []string
+	mu      sync.Mutex
+}
+
+// WithStateStore makes the manager restore the state of its integrations when it is
+// started, and save it after every run
+func WithStateStore(store state.Store) func(*Manager) {
+	return func(m *Manager) {
+		m.store = store
+	}
+}
+
+// WithCircuitBreaker includes the state of the breaker that guards the context broker in reports
+func WithCircuitBreaker(breaker *circuitbreaker.Breaker) func(*Manager) {
+	return func(m *Manager) {
+		m.breaker = breaker
+	}
+}
+
+// WithOutbox collects the writes of all runs that the context broker failed to accept
+// in the outbox, and replays them in the background once the manager has been started
+func WithOutbox(outbox *Outbox) func(*Manager) {
+	return func(m *Manager) {
+		m.outbox = outbox
+	}
+}
+
+func NewManager(options ...func(*Manager)) *Manager {
+	m := &Manager{
+		runners: map[string]*runner{},
+		runs:    map[string]*RunInfo{},
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+// Add adds an integration to the manager. It must be called before Start.
+func (m *Manager) Add(i Integration, settings Settings) {
+	m.runners[i.Name()] = &runner{integration: i, settings: settings}
+}
+
+// Integrations returns all integrations that have been added to the manager, ordered by name
+func (m *Manager) Integrations() []Integration {
+	result := make([]Integration, 0, len(m.runners))
+	for _, r := range m.runners {
+		result = append(result, r.integration)
+	}
+
+	slices.SortFunc(result, func(a, b Integration) int {
+		if a.Name() < b.Name() {
+			return -1
+		}
+		return 1
+	})
+
+	return result
+}
+
+// Start runs every integration according to its schedule until the context is cancelled
+func (m *Manager) Start(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.started = time.Now().UTC()
+	m.mu.Unlock()
+
+	for _, r := range m.runners {
+		// a missing or unreadable state only means that some work is redone
+		if err := RestoreState(ctx, r.integration, m.store); err != nil {
+			logging.GetFromContext(ctx).Error("failed to restore state", "integration", r.integration.Name(), "err", err.Error())
+		}
+	}
+
+	if m.outbox != nil {
+		if err := m.outbox.Restore(ctx); err != nil {
+			logging.GetFromContext(ctx).Error("failed to restore outbox", "err", err.Error())
+		}
+
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.outbox.Run(ctx)
+		}()
+	}
+
+	for _, r := range m.runners {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.poll(ctx, r)
+		}()
+	}
+}
+
+// Outbox returns the outbox of the manager, or nil if it does not have one
+func (m *Manager) Outbox() *Outbox {
+	return m.outbox
+}
+
+// Wait blocks until all polling loops and triggered runs have returned
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// SupportsEntityTypes returns ErrUnsupportedType unless a run of the integration can be
+// limited to all of the given entity types
+func SupportsEntityTypes(i Integration, entityTypes ...string) error {
+	if len(entityTypes) == 0 {
+		return nil
+	}
+
+	lister, ok := i.(EntityTypeLister)
+	if !ok {
+		return fmt.Errorf("integration %s can not be limited to entity types: %w", i.Name(), ErrUnsupportedType)
+	}
+
+	supported := lister.EntityTypes()
+	for _, t := range entityTypes {
+		if !slices.Contains(supported, t) {
+			return fmt.Errorf("integration %s does not handle %s (supported types are %v): %w", i.Name(), t, supported, ErrUnsupportedType)
+		}
+	}
+
+	return nil
+}
+
+// Trigger queues an immediate run of the named integration, optionally limited to a
+// number of entity types. Only the entity types that are enabled for the integration
+// can be synced. The returned run can be followed by calling Run with its ID.
+//
+// There is at most one queued run per integration. A trigger that arrives while a run is
+// queued is merged into it, so that the queued run syncs the entity types of both.
+func (m *Manager) Trigger(name string, entityTypes ...string) (RunInfo, error) {
+	r, ok := m.runners[name]
+	if !ok {
+		return RunInfo{}, fmt.Errorf("integration %s: %w", name, ErrNotFound)
+	}
+
+	if err := SupportsEntityTypes(r.integration, entityTypes...); err != nil {
+		return RunInfo{}, err
+	}
+
+	if enabled := r.settings.EntityTypes; len(enabled) > 0 {
+		if len(entityTypes) == 0 {
+			entityTypes = enabled
+		}
+
+		for _, t := range entityTypes {
+			if !slices.Contains(enabled, t) {
+				return RunInfo{}, fmt.Errorf("%s is not enabled for integration %s: %w", t, name, ErrUnsupportedType)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ctx := m.ctx
+
+	if ctx == nil {
+		return RunInfo{}, ErrNotStarted
+	}
+
+	if ctx.Err() != nil {
+		return RunInfo{}, ctx.Err()
+	}
+
+	if q := r.queued; q != nil {
+		q.entityTypes = mergeEntityTypes(q.entityTypes, entityTypes)
+
+		info, ok := m.runs[q.id]
+		if ok {
+			info.EntityTypes = q.entityTypes
+			return *info, nil
+		}
+
+		// the queued run has dropped out of the history, and is replaced by a new one
+		entityTypes = q.entityTypes
+	}
+
+	info := m.addRun(name, "manual", entityTypes)
+	r.queued = &queuedRun{id: info.ID, entityTypes: entityTypes}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.executeQueued(ctx, r)
+	}()
+
+	return info, nil
+}
+
+// mergeEntityTypes returns the entity types of two runs that are merged into one. A run
+// that is not limited to any entity types syncs all of them.
+func mergeEntityTypes(a, b []string) []string {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+
+	merged := slices.Clone(a)
+	for _, t := range b {
+		if !slices.Contains(merged, t) {
+			merged = append(merged, t)
+		}
+	}
+
+	return merged
+}
+
+// Run returns information about a run that has been queued or executed by the manager
+func (m *Manager) Run(id string) (RunInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info, ok := m.runs[id]
+	if !ok {
+		return RunInfo{}, false
+	}
+
+	return *info, true
+}
+
+// Report is a snapshot of the status of all integrations
+type Report struct {
+	Healthy       bool                   `json:"healthy"`
+	Integrations  []Status               `json:"integrations"`
+	ContextBroker *circuitbreaker.Status `json:"contextBroker,omitempty"`
+	PendingWrites int                    `json:"pendingWrites"`
+}
+
+// Report returns the status of all integrations. An integration is considered unhealthy
+// if it has not completed a successful run within its configured max sync age.
+func (m *Manager) Report() Report {
+	m.mu.Lock()
+	started := m.started
+	m.mu.Unlock()
+
+	report := Report{Healthy: true, Integrations: []Status{}}
+	now := time.Now().UTC()
+
+	for _, i := range m.Integrations() {
+		status := i.Status()
+		maxAge := m.runners[i.Name()].settings.MaxSyncAge
+
+		// give integrations that have never succeeded some slack after startup
+		lastSuccess := status.LastSuccess
+		if lastSuccess.IsZero() {
+			lastSuccess = started
+		}
+
+		status.Healthy = maxAge == 0 || now.Sub(lastSuccess) <= maxAge
+		report.Healthy = report.Healthy && status.Healthy
+		report.Integrations = append(report.Integrations, status)
+	}
+
+	// an open circuit shows up as failed runs, so it does not affect health on its own
+	if m.breaker != nil {
+		status := m.breaker.Status()
+		report.ContextBroker = &status
+	}
+
+	if m.outbox != nil {
+		report.PendingWrites = m.outbox.Len()
+	}
+
+	return report
+}
+
+func (m *Manager) poll(ctx context.Context, r *runner) {
+	logger := logging.GetFromContext(ctx).With(slog.String("integration", r.integration.Name()))
+
+	for {
+		info := m.newRun(r.integration.Name(), "schedule", r.settings.EntityTypes)
+		err := m.execute(ctx, r, info.ID, r.settings.EntityTypes...)
+
+		now := time.Now()
+		sleepDuration := r.settings.Schedule.Next(now).Sub(now)
+
+		switch {
+		case retry.IsPermanent(err):
+			// retrying soon would only fail again, e.g. with an invalid API key
+			logger.Error("integration run failed with an error that requires attention, waiting for the next scheduled run",
+				"retry_in", sleepDuration.String(), "err", err.Error())
+		case err != nil:
+			logger.Error("integration run failed", "retry_in", r.settings.RetryInterval.String(), "err", err.Error())
+			sleepDuration = r.settings.RetryInterval
+		}
+
+		// spread the load on the sources if several instances are scheduled at the same time
+		sleepDuration += schedule.Jitter(r.settings.Jitter)
+
+		logger.Debug("next run scheduled", "at", now.Add(sleepDuration).Format(time.RFC3339))
+
+		if lifecycle.Sleep(ctx, sleepDuration) != nil {
+			logger.Info("integration stopped")
+			return
+		}
+	}
+}
+
+// execute runs the integration once, waiting for any other run of the same integration to
+// complete first
+func (m *Manager) execute(ctx context.Context, r *runner, runID string, entityTypes ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return m.perform(ctx, r, runID, entityTypes...)
+}
+
+// executeQueued runs the queued run of the integration once any other run has completed,
+// with the entity types of every trigger that has been merged into it
+func (m *Manager) executeQueued(ctx context.Context, r *runner) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// triggers that arrive from now on queue another run
+	m.mu.Lock()
+	q := r.queued
+	r.queued = nil
+	m.mu.Unlock()
+
+	// the run has already been performed along with a run that replaced it in the queue
+	if q == nil {
+		return nil
+	}
+
+	return m.perform(ctx, r, q.id, q.entityTypes...)
+}
+
+// perform runs the integration while the caller holds the lock of the runner. A run that is
+// in progress when the context is cancelled is given a bounded period of time to complete.
+func (m *Manager) perform(ctx context.Context, r *runner, runID string, entityTypes ...string) error {
+	if ctx.Err() != nil {
+		m.update(runID, func(info *RunInfo) {
+			info.State = RunFailed
+			info.Error = ctx.Err().Error()
+		})
+		return ctx.Err()
+	}
+
+	m.update(runID, func(info *RunInfo) {
+		info.State = RunRunning
+		info.Started = time.Now().UTC()
+	})
+
+	runCtx, cancelRun := lifecycle.WithDrainPeriod(ctx, lifecycle.DefaultDrainPeriod)
+	runCtx = logging.NewContextWithLogger(runCtx, logging.GetFromContext(ctx).With(
+		slog.String("integration", r.integration.Name()), slog.String("runID", runID),
+	))
+	runCtx = withOutbox(runCtx, m.outbox)
+	err := r.integration.Run(runCtx, entityTypes...)
+	cancelRun()
+
+	// a failed run may still have made progress that is worth keeping
+	if stateErr := CheckpointState(r.integration, m.store); stateErr != nil {
+		logging.GetFromContext(runCtx).Error("failed to checkpoint state", "err", stateErr.Error())
+	}
+
+	if m.outbox != nil {
+		if outboxErr := m.outbox.Flush(); outboxErr != nil {
+			logging.GetFromContext(runCtx).Error("failed to flush outbox", "err", outboxErr.Error())
+		}
+	}
+
+	m.update(runID, func(info *RunInfo) {
+		info.Finished = time.Now().UTC()
+		info.State = RunSucceeded
+
+		if err != nil {
+			info.State = RunFailed
+			info.Error = err.Error()
+		}
+	})
+
+	return err
+}
+
+func (m *Manager) newRun(name, trigger string, entityTypes []string) RunInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addRun(name, trigger, entityTypes)
+}
+
+// addRun records a new run while the caller holds the lock of the manager
+func (m *Manager) addRun(name, trigger string, entityTypes []string) RunInfo {
+	info := &RunInfo{
+		ID:          rand.Text(),
+		Integration: name,
+		EntityTypes: entityTypes,
+		Trigger:     trigger,
+		State:       RunQueued,
+		Queued:      time.Now().UTC(),
+	}
+
+	m.runs[info.ID] = info
+	m.history = append(m.history, info.ID)
+
+	if len(m.history) > maxRunHistory {
+		delete(m.runs, m.history[0])
+		m.history = m.history[1:]
+	}
+
+	return *info
+}
+
+func (m *Manager) update(runID string, fn func(*RunInfo)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if info, ok := m.runs[runID]; ok {
+		fn(info)
+	}
+}
@@ -0,0 +1,87 @@
+package integrations
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/schedule"
+	"github.com/matryer/is"
+)
+
+func TestThatTriggersAreMergedWhileARunIsInProgress(t *testing.T) {
+	is := is.New(t)
+
+	i := &blockingIntegration{
+		testIntegration: newTestIntegration(context.Background(), nil, nil).(*testIntegration),
+		release:         make(chan struct{}),
+	}
+
+	m := NewManager()
+	m.Add(i, Settings{Schedule: schedule.Every(time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Start(ctx)
+
+	// the scheduled run blocks until it is released
+	is.NoErr(waitFor(func() bool { return len(i.calls()) == 1 }))
+
+	first, err := m.Trigger("blockingtest", "beaches")
+	is.NoErr(err)
+	second, err := m.Trigger("blockingtest", "trails")
+	is.NoErr(err)
+	third, err := m.Trigger("blockingtest", "beaches")
+	is.NoErr(err)
+
+	is.Equal(second.ID, first.ID)
+	is.Equal(third.ID, first.ID)
+	is.Equal(third.EntityTypes, []string{"beaches", "trails"})
+
+	close(i.release)
+
+	is.NoErr(waitFor(func() bool {
+		run, _ := m.Run(first.ID)
+		return run.State == RunSucceeded
+	}))
+
+	is.Equal(i.calls(), [][]string{nil, {"beaches", "trails"}})
+
+	// a trigger after the queued run has started queues a run of its own
+	next, err := m.Trigger("blockingtest")
+	is.NoErr(err)
+	is.True(next.ID != first.ID)
+
+	is.NoErr(waitFor(func() bool { return len(i.calls()) == 3 }))
+
+	cancel()
+	m.Wait()
+}
+
+// blockingIntegration blocks every run until it is released, and records the entity types of its runs
+type blockingIntegration struct {
+	*testIntegration
+	release chan struct{}
+	runs    [][]string
+	mu      sync.Mutex
+}
+
+func (bi *blockingIntegration) Name() string { return "blockingtest" }
+
+func (bi *blockingIntegration) EntityTypes() []string { return []string{"beaches", "trails"} }
+
+func (bi *blockingIntegration) Run(ctx context.Context, types ...string) error {
+	bi.mu.Lock()
+	bi.runs = append(bi.runs, types)
+	bi.mu.Unlock()
+
+	<-bi.release
+	return nil
+}
+
+func (bi *blockingIntegration) calls() [][]string {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	return append([][]string{}, bi.runs...)
+}
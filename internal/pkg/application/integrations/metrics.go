@@ -0,0 +1,109 @@
+package integrations
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace string = "cip_sdl"
+
+var (
+	fetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "source_fetch_duration_seconds",
+		Help:      "Time spent fetching data from a source system, by response status.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"integration", "status"})
+
+	featuresSeen = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "features_seen_total",
+		Help:      "Number of source features that matched an entity type.",
+	}, []string{"integration", "entity_type"})
+
+	featuresRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "features_rejected_total",
+		Help:      "Number of source features that could not be converted into entities.",
+	}, []string{"integration", "entity_type"})
+
+	entityOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "entities_total",
+		Help:      "Number of entities created, merged, deleted or skipped in the context broker.",
+	}, []string{"integration", "entity_type", "operation"})
+
+	brokerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "broker_errors_total",
+		Help:      "Number of failed requests to the context broker, by kind of error.",
+	}, []string{"integration", "entity_type", "kind"})
+
+	runDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of integration runs, by result.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"integration", "result"})
+
+	entityTypeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "entity_type_run_duration_seconds",
+		Help:      "Duration of processing a single entity type during a run, by result.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"integration", "entity_type", "result"})
+
+	lastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful sync of an entity type.",
+	}, []string{"integration", "entity_type"})
+)
+
+// ObserveFetch records the duration and outcome of a request to a source system.
+// A non nil error without a status code is reported with the status "error".
+func ObserveFetch(integration string, started time.Time, statusCode int, err error) {
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	} else if err == nil {
+		status = "ok"
+	}
+
+	fetchDuration.WithLabelValues(integration, status).Observe(time.Since(started).Seconds())
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+var brokerErrorKinds = []struct {
+	err  error
+	kind string
+}{
+	{ngsierrors.ErrNotFound, "not_found"},
+	{ngsierrors.ErrAlreadyExists, "already_exists"},
+	{ngsierrors.ErrBadRequest, "bad_request"},
+	{ngsierrors.ErrInvalidRequest, "invalid_request"},
+	{ngsierrors.ErrUnknownTenant, "unknown_tenant"},
+	{ngsierrors.ErrRequest, "request"},
+	{ngsierrors.ErrBadResponse, "bad_response"},
+	{ngsierrors.ErrInternal, "internal"},
+}
+
+func brokerErrorKind(err error) string {
+	for _, k := range brokerErrorKinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,46 @@
+package integrations
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestThatEntityRunsAreCounted(t *testing.T) {
+	is := is.New(t)
+	tracker := NewTracker("metrics")
+
+	run := tracker.BeginEntityType("beaches")
+	run.Processed()
+	run.Processed()
+	run.Processed()
+	run.Skipped()
+	run.Created()
+	run.Rejected(errors.New("missing geometry"))
+	run.Done(nil)
+
+	is.Equal(testutil.ToFloat64(featuresSeen.WithLabelValues("metrics", "beaches")), 3.0)
+	is.Equal(testutil.ToFloat64(featuresRejected.WithLabelValues("metrics", "beaches")), 1.0)
+	is.Equal(testutil.ToFloat64(entityOperations.WithLabelValues("metrics", "beaches", "skipped")), 1.0)
+	is.Equal(testutil.ToFloat64(entityOperations.WithLabelValues("metrics", "beaches", "created")), 1.0)
+	is.True(testutil.ToFloat64(lastSuccess.WithLabelValues("metrics", "beaches")) > 0)
+
+	is.Equal(tracker.Status().EntityTypes["beaches"].Counters, Counters{Processed: 3, Created: 1, Skipped: 1, Failed: 1})
+}
+
+func TestThatBrokerErrorsAreCountedByKind(t *testing.T) {
+	is := is.New(t)
+	tracker := NewTracker("brokererrors")
+
+	run := tracker.BeginEntityType("trails")
+	run.Failed(fmt.Errorf("%w: 503 service unavailable", ngsierrors.ErrBadResponse))
+	run.Failed(errors.New("connection refused"))
+	run.Done(nil)
+
+	is.Equal(testutil.ToFloat64(brokerErrors.WithLabelValues("brokererrors", "trails", "bad_response")), 1.0)
+	is.Equal(testutil.ToFloat64(brokerErrors.WithLabelValues("brokererrors", "trails", "other")), 1.0)
+}
@@ -0,0 +1,377 @@
+package integrations
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/retry"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/state"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+type Operation string
+
+const (
+	OperationUpsert Operation = "upsert"
+	OperationDelete Operation = "delete"
+)
+
+// OutboxEntry is a write to the context broker that has failed and is waiting to be replayed
+type OutboxEntry struct {
+	EntityID    string    `json:"entityId"`
+	Integration string    `json:"integration"`
+	EntityType  string    `json:"entityType"`
+	Operation   Operation `json:"operation"`
+	// Entity is the complete entity to upsert
+	Entity      json.RawMessage `json:"entity,omitempty"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"lastError"`
+	FirstFailed time.Time       `json:"firstFailed"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+
+	// onSuccess holds the callbacks of the failed write, which are called when it is replayed
+	// successfully. They are not saved, so a write that is replayed after a restart leaves it
+	// to the next run of its integration to record that it has been written.
+	onSuccess []func()
+}
+
+// DefaultOutboxPolicy decides how often failed writes are replayed. The number of
+// attempts is ignored, since entries are only dropped after a successful write or when
+// they are discarded.
+var DefaultOutboxPolicy = retry.Policy{InitialDelay: time.Minute, MaxDelay: time.Hour}
+
+// outboxInterval is how often the outbox looks for entries that are due to be replayed
+const outboxInterval time.Duration = 15 * time.Second
+
+// outboxStateName is the name under which the outbox is saved in the state store
+const outboxStateName string = "outbox"
+
+// Outbox keeps the writes that the context broker failed to accept, so that they can be
+// replayed without waiting for the next run of the integration, and even after a restart.
+// There is at most one entry per entity, holding the latest write that failed. An entry is
+// dropped when a write of the entity succeeds, or when it is discarded.
+type Outbox struct {
+	broker  client.ContextBrokerClient
+	tenants *Tenants
+	store   state.Store
+	policy  retry.Policy
+
+	entries map[string]*OutboxEntry
+	dirty   bool
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// WithOutboxPolicy changes how often failed writes are replayed
+func WithOutboxPolicy(policy retry.Policy) func(*Outbox) {
+	return func(o *Outbox) {
+		o.policy = policy
+	}
+}
+
+// WithTenants replays each write to the tenant of its integration and entity type, rather
+// than to the broker that the outbox was created with
+func WithTenants(tenants *Tenants) func(*Outbox) {
+	return func(o *Outbox) {
+		o.tenants = tenants
+	}
+}
+
+// NewOutbox returns an outbox that replays writes to the broker and is saved in the store
+func NewOutbox(broker client.ContextBrokerClient, store state.Store, options ...func(*Outbox)) *Outbox {
+	o := &Outbox{
+		broker:  broker,
+		store:   store,
+		policy:  DefaultOutboxPolicy,
+		entries: map[string]*OutboxEntry{},
+		now:     time.Now,
+	}
+
+	for _, option := range options {
+		option(o)
+	}
+
+	return o
+}
+
+// Restore loads the entries that were saved before the last shutdown
+func (o *Outbox) Restore(ctx context.Context) error {
+	data, err := o.store.Load(outboxStateName)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to load outbox: %w", err)
+	}
+
+	saved := []*OutboxEntry{}
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to restore outbox: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, e := range saved {
+		o.entries[e.EntityID] = e
+	}
+
+	logging.GetFromContext(ctx).Info("restored outbox", "entries", len(saved))
+
+	return nil
+}
+
+// Flush saves the outbox if it has changed since it was last saved
+func (o *Outbox) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(append([]*OutboxEntry{}, o.sorted()...))
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox: %w", err)
+	}
+
+	if err = o.store.Save(outboxStateName, data); err != nil {
+		return fmt.Errorf("failed to save outbox: %w", err)
+	}
+
+	o.dirty = false
+	return nil
+}
+
+// Entries returns all pending writes, oldest first
+func (o *Outbox) Entries() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	result := []OutboxEntry{}
+	for _, e := range o.sorted() {
+		result = append(result, *e)
+	}
+
+	return result
+}
+
+// Len returns the number of pending writes
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.entries)
+}
+
+// Discard drops the pending write of an entity without replaying it
+func (o *Outbox) Discard(entityID string) error {
+	o.mu.Lock()
+	_, ok := o.entries[entityID]
+	delete(o.entries, entityID)
+	o.dirty = o.dirty || ok
+	o.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no pending write of %s: %w", entityID, ErrNotFound)
+	}
+
+	return o.Flush()
+}
+
+// Run replays pending writes as they become due until the context is cancelled
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := o.now()
+			o.Replay(ctx, func(e OutboxEntry) bool { return !e.NextAttempt.After(now) })
+		}
+	}
+}
+
+// Replay immediately replays the pending writes that are selected by the filter, or all of
+// them if the filter is nil, and returns the number of writes that succeeded and failed
+func (o *Outbox) Replay(ctx context.Context, filter func(OutboxEntry) bool) (replayed, failed int) {
+	logger := logging.GetFromContext(ctx)
+
+	for _, e := range o.Entries() {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if filter != nil && !filter(e) {
+			continue
+		}
+
+		err := o.replay(ctx, e)
+
+		var onSuccess []func()
+
+		o.mu.Lock()
+		current, ok := o.entries[e.EntityID]
+		// the entity may have been written, or have failed again, while it was replayed
+		if ok && current.Attempts == e.Attempts && current.Operation == e.Operation {
+			if err == nil {
+				onSuccess = current.onSuccess
+				delete(o.entries, e.EntityID)
+			} else {
+				current.Attempts++
+				current.LastError = err.Error()
+				current.NextAttempt = o.now().Add(o.policy.Backoff(current.Attempts))
+			}
+			o.dirty = true
+		}
+		o.mu.Unlock()
+
+		if err != nil {
+			logger.Info("failed to replay write", "entityID", e.EntityID, "operation", e.Operation, "err", err.Error())
+			failed++
+			continue
+		}
+
+		for _, fn := range onSuccess {
+			fn()
+		}
+
+		logger.Info("replayed write", "entityID", e.EntityID, "operation", e.Operation)
+		replayed++
+	}
+
+	if err := o.Flush(); err != nil {
+		logger.Error("failed to flush outbox", "err", err.Error())
+	}
+
+	return replayed, failed
+}
+
+func (o *Outbox) replay(ctx context.Context, e OutboxEntry) error {
+	broker := o.broker
+	if o.tenants != nil {
+		broker = o.tenants.Broker(e.Integration, e.EntityType)
+	}
+
+	if e.Operation == OperationDelete {
+		_, err := broker.DeleteEntity(ctx, e.EntityID)
+		if errors.Is(err, ngsierrors.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	attributes := map[string]any{}
+	if err := json.Unmarshal(e.Entity, &attributes); err != nil {
+		return fmt.Errorf("invalid entity in outbox: %w", err)
+	}
+
+	delete(attributes, "id")
+	delete(attributes, "type")
+
+	body, _ := json.Marshal(attributes)
+	fragment, err := entities.NewFragmentFromJSON(body)
+	if err != nil {
+		return fmt.Errorf("invalid entity in outbox: %w", err)
+	}
+
+	_, err = broker.MergeEntity(ctx, e.EntityID, fragment, writeHeaders)
+	if !errors.Is(err, ngsierrors.ErrNotFound) {
+		return err
+	}
+
+	entity, err := entities.NewFromJSON(e.Entity)
+	if err != nil {
+		return fmt.Errorf("invalid entity in outbox: %w", err)
+	}
+
+	_, err = broker.CreateEntity(ctx, entity, writeHeaders)
+	return err
+}
+
+// add records a failed write, replacing any earlier write of the same entity. The entity
+// is nil for deletes. The callbacks are called if the write is replayed successfully.
+func (o *Outbox) add(run *EntityRun, op Operation, entityID string, entity types.Entity, err error, onSuccess []func()) {
+	if o == nil {
+		return
+	}
+
+	e := &OutboxEntry{
+		EntityID:    entityID,
+		Integration: run.tracker.status.Name,
+		EntityType:  run.entityType,
+		Operation:   op,
+		Attempts:    1,
+		LastError:   err.Error(),
+		onSuccess:   onSuccess,
+	}
+
+	if entity != nil {
+		e.Entity, _ = json.Marshal(entity)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := o.now()
+	e.FirstFailed = now
+	if previous, ok := o.entries[entityID]; ok {
+		e.FirstFailed = previous.FirstFailed
+		e.Attempts = previous.Attempts + 1
+	}
+
+	e.NextAttempt = now.Add(o.policy.Backoff(e.Attempts))
+
+	o.entries[entityID] = e
+	o.dirty = true
+}
+
+// done drops any pending write of an entity that has now been written successfully
+func (o *Outbox) done(entityID string) {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.entries[entityID]; ok {
+		delete(o.entries, entityID)
+		o.dirty = true
+	}
+}
+
+func (o *Outbox) sorted() []*OutboxEntry {
+	return slices.SortedFunc(maps.Values(o.entries), func(a, b *OutboxEntry) int {
+		return cmp.Or(a.FirstFailed.Compare(b.FirstFailed), cmp.Compare(a.EntityID, b.EntityID))
+	})
+}
+
+type outboxKey struct{}
+
+// withOutbox makes writers that are created with the context add their failed writes to the outbox
+func withOutbox(ctx context.Context, o *Outbox) context.Context {
+	if o == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, outboxKey{}, o)
+}
+
+func outboxFromContext(ctx context.Context) *Outbox {
+	o, _ := ctx.Value(outboxKey{}).(*Outbox)
+	return o
+}
@@ -0,0 +1,227 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
+	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/circuitbreaker"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/state"
+	"github.com/matryer/is"
+)
+
+func TestThatFailedWritesAreKeptInTheOutbox(t *testing.T) {
+	is := is.New(t)
+
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return nil, errors.New("[code: 500] broker is unwell")
+		},
+		DeleteEntityFunc: func(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
+			return nil, errors.New("[code: 500] broker is unwell")
+		},
+	}
+
+	store := state.NewMemoryStore()
+	outbox := NewOutbox(broker, store)
+
+	tracker := NewTracker("outboxtest")
+	w := NewWriter(withOutbox(context.Background(), outbox), broker, tracker.BeginEntityType("beaches"), 2)
+
+	w.Upsert("urn:ngsi-ld:Beach:1", "Beach", []entities.EntityDecoratorFunc{decorators.Name("Stranden")})
+	w.Delete("urn:ngsi-ld:Beach:2")
+	w.Wait()
+
+	is.NoErr(outbox.Flush())
+
+	restored := NewOutbox(broker, store)
+	is.NoErr(restored.Restore(context.Background()))
+
+	entries := restored.Entries()
+	is.Equal(len(entries), 2)
+
+	upsert := entries[0]
+	if upsert.Operation != OperationUpsert {
+		upsert = entries[1]
+	}
+
+	is.Equal(upsert.EntityID, "urn:ngsi-ld:Beach:1")
+	is.Equal(upsert.Integration, "outboxtest")
+	is.Equal(upsert.EntityType, "beaches")
+	is.Equal(upsert.Attempts, 1)
+	is.True(upsert.NextAttempt.After(upsert.FirstFailed))
+}
+
+func TestThatWritesRefusedByAnOpenCircuitAreKeptInTheOutbox(t *testing.T) {
+	is := is.New(t)
+
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return nil, errors.New("[code: 503] broker is unwell")
+		},
+		DeleteEntityFunc: func(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
+			return nil, errors.New("[code: 503] broker is unwell")
+		},
+	}
+
+	outbox := NewOutbox(broker, state.NewMemoryStore())
+
+	tracker := NewTracker("outboxtest")
+	w := NewWriter(withOutbox(context.Background(), outbox), circuitbreaker.NewClient(broker, circuitbreaker.New(2, time.Minute)), tracker.BeginEntityType("beaches"), 1)
+
+	for i := range 5 {
+		w.Upsert(fmt.Sprintf("urn:ngsi-ld:Beach:%d", i), "Beach", []entities.EntityDecoratorFunc{decorators.Name("Stranden")})
+	}
+	w.Delete("urn:ngsi-ld:Beach:5")
+	err := w.Wait()
+
+	is.True(errors.Is(err, circuitbreaker.ErrOpen))
+	is.Equal(len(broker.MergeEntityCalls()), 2)
+	is.Equal(outbox.Len(), 6)
+
+	for _, e := range outbox.Entries() {
+		if e.EntityID == "urn:ngsi-ld:Beach:5" {
+			is.Equal(e.Operation, OperationDelete)
+		} else {
+			is.Equal(e.Operation, OperationUpsert)
+			is.True(len(e.Entity) > 0)
+		}
+	}
+}
+
+func TestThatASuccessfulWriteDropsThePendingWrite(t *testing.T) {
+	is := is.New(t)
+
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return &ngsild.MergeEntityResult{}, nil
+		},
+	}
+
+	outbox := NewOutbox(broker, state.NewMemoryStore())
+	outbox.add(NewTracker("outboxtest").BeginEntityType("beaches"), OperationDelete, "urn:ngsi-ld:Beach:1", nil, errors.New("failed"), nil)
+
+	tracker := NewTracker("outboxtest")
+	w := NewWriter(withOutbox(context.Background(), outbox), broker, tracker.BeginEntityType("beaches"), 1)
+	w.Upsert("urn:ngsi-ld:Beach:1", "Beach", nil)
+	w.Wait()
+
+	is.Equal(outbox.Len(), 0)
+}
+
+func TestThatReplayCreatesEntitiesThatDoNotExist(t *testing.T) {
+	is := is.New(t)
+
+	fail := true
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return nil, ngsierrors.ErrNotFound
+		},
+		CreateEntityFunc: func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+			if fail {
+				return nil, errors.New("[code: 503] broker is unwell")
+			}
+			return ngsild.NewCreateEntityResult(""), nil
+		},
+	}
+
+	entity, _ := entities.New("urn:ngsi-ld:Beach:1", "Beach", decorators.Name("Stranden"))
+
+	outbox := NewOutbox(broker, state.NewMemoryStore())
+	outbox.add(NewTracker("outboxtest").BeginEntityType("beaches"), OperationUpsert, entity.ID(), entity, errors.New("failed"), nil)
+
+	replayed, failed := outbox.Replay(context.Background(), nil)
+	is.Equal(replayed, 0)
+	is.Equal(failed, 1)
+	is.Equal(outbox.Entries()[0].Attempts, 2)
+	is.Equal(outbox.Entries()[0].LastError, "[code: 503] broker is unwell")
+
+	fail = false
+	replayed, _ = outbox.Replay(context.Background(), nil)
+	is.Equal(replayed, 1)
+	is.Equal(outbox.Len(), 0)
+
+	created := broker.CreateEntityCalls()[1].Entity
+	is.Equal(created.ID(), "urn:ngsi-ld:Beach:1")
+	is.Equal(created.Type(), "Beach")
+	is.Equal(len(broker.MergeEntityCalls()), 2)
+}
+
+func TestThatAReplayedWriteIsFingerprinted(t *testing.T) {
+	is := is.New(t)
+
+	fail := true
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			if fail {
+				return nil, errors.New("[code: 503] broker is unwell")
+			}
+			return &ngsild.MergeEntityResult{}, nil
+		},
+	}
+
+	outbox := NewOutbox(broker, state.NewMemoryStore())
+	fingerprints := NewFingerprints(time.Hour)
+	ctx := withOutbox(context.Background(), outbox)
+
+	tracker := NewTracker("outboxtest")
+	w := NewWriter(ctx, broker, tracker.BeginEntityType("beaches"), 1)
+	w.UpsertChanges(fingerprints, "urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Sandig"))
+	w.Wait()
+
+	fail = false
+	replayed, _ := outbox.Replay(context.Background(), nil)
+	is.Equal(replayed, 1)
+
+	// the replay wrote the entity, so there is nothing left to write in the next run
+	w = NewWriter(ctx, broker, tracker.BeginEntityType("beaches"), 1)
+	w.UpsertChanges(fingerprints, "urn:ngsi-ld:Beach:1", "Beach", beach("Stranden", "Sandig"))
+	w.Wait()
+
+	is.Equal(len(broker.MergeEntityCalls()), 2)
+	is.Equal(tracker.Status().EntityTypes["beaches"].Counters, Counters{Skipped: 1})
+}
+
+func TestThatEntriesAreOnlyReplayedWhenDue(t *testing.T) {
+	is := is.New(t)
+
+	broker := &test.ContextBrokerClientMock{
+		DeleteEntityFunc: func(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
+			return nil, ngsierrors.ErrNotFound
+		},
+	}
+
+	outbox := NewOutbox(broker, state.NewMemoryStore())
+	outbox.add(NewTracker("outboxtest").BeginEntityType("beaches"), OperationDelete, "urn:ngsi-ld:Beach:1", nil, errors.New("failed"), nil)
+
+	now := time.Now()
+	replayed, _ := outbox.Replay(context.Background(), func(e OutboxEntry) bool { return !e.NextAttempt.After(now) })
+	is.Equal(replayed, 0)
+
+	now = now.Add(DefaultOutboxPolicy.InitialDelay)
+	replayed, _ = outbox.Replay(context.Background(), func(e OutboxEntry) bool { return !e.NextAttempt.After(now) })
+	is.Equal(replayed, 1) // a delete of an entity that does not exist has succeeded
+}
+
+func TestThatPendingWritesCanBeDiscarded(t *testing.T) {
+	is := is.New(t)
+
+	store := state.NewMemoryStore()
+	outbox := NewOutbox(nil, store)
+	outbox.add(NewTracker("outboxtest").BeginEntityType("beaches"), OperationDelete, "urn:ngsi-ld:Beach:1", nil, errors.New("failed"), nil)
+
+	is.NoErr(outbox.Discard("urn:ngsi-ld:Beach:1"))
+	is.True(errors.Is(outbox.Discard("urn:ngsi-ld:Beach:1"), ErrNotFound))
+
+	data, err := store.Load(outboxStateName)
+	is.NoErr(err)
+	is.Equal(string(data), "[]")
+}
@@ -0,0 +1,47 @@
+package integrations
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+)
+
+// QueryPageSize is the number of entities that are requested from the broker at a time
+const QueryPageSize int = 100
+
+// QueryEntities calls fn for every entity of the given type whose id starts with the
+// prefix, requesting the entities from the broker one page at a time
+func QueryEntities(ctx context.Context, broker client.ContextBrokerClient, entityType, idPrefix string, fn func(types.Entity)) error {
+	params := url.Values{}
+	params.Set("type", entityType)
+	params.Set("idPattern", "^"+regexp.QuoteMeta(idPrefix))
+	params.Set("limit", strconv.Itoa(QueryPageSize))
+
+	for offset := 0; ; offset += QueryPageSize {
+		params.Set("offset", strconv.Itoa(offset))
+
+		result, err := broker.QueryEntities(ctx, []string{entityType}, nil, "?"+params.Encode(), nil)
+		if err != nil {
+			return err
+		}
+
+		count := 0
+		for e := range result.Found {
+			// the client marks the end of the result with a nil entity
+			if e == nil {
+				break
+			}
+
+			count++
+			fn(e)
+		}
+
+		if count < QueryPageSize {
+			return nil
+		}
+	}
+}
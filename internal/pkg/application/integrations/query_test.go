@@ -0,0 +1,47 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/matryer/is"
+)
+
+func TestThatEntitiesAreQueriedOnePageAtATime(t *testing.T) {
+	is := is.New(t)
+
+	const total int = QueryPageSize + 5
+
+	broker := &test.ContextBrokerClientMock{
+		QueryEntitiesFunc: func(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+			params, _ := url.ParseQuery(query[1:])
+
+			offset := 0
+			fmt.Sscan(params.Get("offset"), &offset)
+
+			result := ngsild.NewQueryEntitiesResult()
+			go func() {
+				for i := offset; i < min(offset+QueryPageSize, total); i++ {
+					e, _ := entities.New(fmt.Sprintf("urn:ngsi-ld:Beach:%d", i), "Beach")
+					result.Found <- e
+				}
+				result.Found <- nil
+			}()
+
+			return result, nil
+		},
+	}
+
+	found := 0
+	err := QueryEntities(context.Background(), broker, "Beach", "urn:ngsi-ld:Beach:", func(e types.Entity) { found++ })
+
+	is.NoErr(err)
+	is.Equal(found, total)
+	is.Equal(len(broker.QueryEntitiesCalls()), 2)
+}
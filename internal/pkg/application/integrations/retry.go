@@ -0,0 +1,54 @@
+package integrations
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/retry"
+)
+
+// FetchRetryPolicy returns the policy for retrying failed requests to the source system
+// of the named integration. It is configured by the following keys:
+//
+//	<NAME>_FETCH_ATTEMPTS      the total number of attempts per fetch, 1 to never retry
+//	<NAME>_FETCH_BACKOFF       the upper bound of the first, randomised, delay, e.g. "1s"
+//	<NAME>_FETCH_MAX_BACKOFF   the longest delay between two attempts, e.g. "30s"
+//
+// retry.DefaultPolicy provides the values that are not set, and is returned along with any error.
+func FetchRetryPolicy(name string, cfg Config) (retry.Policy, error) {
+	prefix := strings.ToUpper(name)
+	policy := retry.DefaultPolicy
+
+	var errs []error
+
+	if value := cfg.Get(prefix + "_FETCH_ATTEMPTS"); value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 1 {
+			errs = append(errs, fmt.Errorf("%s_FETCH_ATTEMPTS must be set to a positive number, not %q", prefix, value))
+		}
+		policy.Attempts = attempts
+	}
+
+	duration := func(key string, value *time.Duration) {
+		if str := cfg.Get(key); str != "" {
+			d, err := time.ParseDuration(str)
+			if err != nil || d <= 0 {
+				errs = append(errs, fmt.Errorf("%s must be set to a positive duration, such as 1s or 2m, not %q", key, str))
+				return
+			}
+			*value = d
+		}
+	}
+
+	duration(prefix+"_FETCH_BACKOFF", &policy.InitialDelay)
+	duration(prefix+"_FETCH_MAX_BACKOFF", &policy.MaxDelay)
+
+	if err := errors.Join(errs...); err != nil {
+		return retry.DefaultPolicy, err
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,300 @@
+package integrations
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// maxFeatureCountHistory is the number of runs whose feature counts are kept by a safeguard
+const maxFeatureCountHistory int = 10
+
+// Safeguard protects the context broker against mass deletions caused by a source that
+// returns a truncated or empty response. Destructive actions are held back during a run
+// whose feature count has dropped too much compared to the last normal run, and every
+// deletion has to be requested in a number of consecutive runs before it is carried out.
+// The runs are counted per entity type, since a run may be limited to some of the types
+// of an integration.
+//
+// A nil safeguard allows every deletion.
+type Safeguard struct {
+	maxDrop         float64
+	confirmations   int
+	acceptDropAfter int
+
+	state safeguardState
+	now   func() time.Time
+	mu    sync.Mutex
+}
+
+type safeguardState struct {
+	// Baseline is the feature count of the last run that did not trip the safeguard
+	Baseline int `json:"baseline"`
+	// Held is the number of consecutive runs, up to and including the current one, that
+	// have tripped the safeguard
+	Held    int            `json:"held"`
+	History []FeatureCount `json:"history"`
+	// EntityTypes holds the runs and pending deletions of each entity type
+	EntityTypes map[string]*entityTypeSafeguard `json:"entityTypes"`
+}
+
+type entityTypeSafeguard struct {
+	Run     int                         `json:"run"`
+	Pending map[string]*pendingDeletion `json:"pending"`
+}
+
+type pendingDeletion struct {
+	FirstRequested time.Time `json:"firstRequested"`
+	Confirmations  int       `json:"confirmations"`
+	LastRun        int       `json:"lastRun"`
+}
+
+// FeatureCount is the number of features that a source returned in a run
+type FeatureCount struct {
+	Time    time.Time `json:"time"`
+	Count   int       `json:"count"`
+	Tripped bool      `json:"tripped,omitempty"`
+}
+
+// PendingDeletion is a deletion that is waiting to be confirmed by more runs
+type PendingDeletion struct {
+	EntityType     string    `json:"entityType"`
+	EntityID       string    `json:"entityId"`
+	FirstRequested time.Time `json:"firstRequested"`
+	Confirmations  int       `json:"confirmations"`
+}
+
+// SafeguardStatus describes the feature counts and pending deletions of a safeguard
+type SafeguardStatus struct {
+	// Tripped tells if destructive actions were held back in the last run
+	Tripped          bool              `json:"tripped"`
+	Baseline         int               `json:"baseline"`
+	FeatureCounts    []FeatureCount    `json:"featureCounts"`
+	PendingDeletions []PendingDeletion `json:"pendingDeletions,omitempty"`
+}
+
+// NewSafeguard returns a safeguard that trips when the feature count drops by more than
+// maxDrop, a fraction between 0 and 1, and carries out a deletion once it has been requested
+// in the given number of consecutive runs. A lower feature count becomes the new baseline
+// after it has tripped the safeguard in acceptDropAfter consecutive runs.
+func NewSafeguard(maxDrop float64, confirmations, acceptDropAfter int) *Safeguard {
+	return &Safeguard{
+		maxDrop:         maxDrop,
+		confirmations:   confirmations,
+		acceptDropAfter: acceptDropAfter,
+		state:           safeguardState{EntityTypes: map[string]*entityTypeSafeguard{}},
+		now:             time.Now,
+	}
+}
+
+// SafeguardFromConfig creates a safeguard for the named integration from the following keys:
+//
+//	<NAME>_MAX_FEATURE_DROP       the largest drop in the feature count, in percent, that is
+//	                              allowed before deletions are held back (default 30)
+//	<NAME>_DELETE_CONFIRMATIONS   the number of consecutive runs that must request a deletion
+//	                              before it is carried out (default 2)
+//	<NAME>_ACCEPT_DROP_AFTER      the number of consecutive runs after which a lower feature
+//	                              count is accepted (default 3)
+func SafeguardFromConfig(name string, cfg Config) (*Safeguard, error) {
+	prefix := strings.ToUpper(name)
+	errs := []error{}
+
+	maxDrop := 30.0
+	if value := cfg.Get(prefix + "_MAX_FEATURE_DROP"); value != "" {
+		var err error
+		maxDrop, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || maxDrop < 0 || maxDrop > 100 {
+			errs = append(errs, fmt.Errorf("%s_MAX_FEATURE_DROP must be a percentage between 0 and 100, not %q", prefix, value))
+		}
+	}
+
+	runs := func(key string, defaultValue int) int {
+		value := cfg.Get(key)
+		if value == "" {
+			return defaultValue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			errs = append(errs, fmt.Errorf("%s must be set to a positive number of runs, not %q", key, value))
+			return defaultValue
+		}
+
+		return n
+	}
+
+	confirmations := runs(prefix+"_DELETE_CONFIRMATIONS", 2)
+	acceptDropAfter := runs(prefix+"_ACCEPT_DROP_AFTER", 3)
+
+	return NewSafeguard(maxDrop/100, confirmations, acceptDropAfter), errors.Join(errs...)
+}
+
+// Begin starts a new run of the entity types in which the source returned the given number of
+// features, and decides whether destructive actions are held back during the run. Deletions
+// of other entity types are left pending, since the run does not request them again.
+func (g *Safeguard) Begin(ctx context.Context, featureCount int, entityTypes ...string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	logger := logging.GetFromContext(ctx)
+	st := &g.state
+
+	for _, entityType := range entityTypes {
+		t := st.entityType(entityType)
+		t.Run++
+
+		// a tripped run neither confirms a pending deletion nor makes it start over
+		if st.Held > 0 {
+			for _, p := range t.Pending {
+				p.LastRun = t.Run - 1
+			}
+		}
+
+		// deletions that were not requested in the previous run have to start over
+		maps.DeleteFunc(t.Pending, func(_ string, p *pendingDeletion) bool { return p.LastRun < t.Run-1 })
+	}
+
+	tripped := st.Baseline > 0 && float64(featureCount) < float64(st.Baseline)*(1-g.maxDrop)
+
+	if tripped {
+		st.Held++
+
+		if st.Held >= g.acceptDropAfter {
+			logger.Warn("the feature count has stayed low, accepting it as the new baseline",
+				"baseline", st.Baseline, "count", featureCount, "runs", st.Held)
+			tripped = false
+		} else {
+			logger.Error("the feature count has dropped too much, holding back all deletions",
+				"baseline", st.Baseline, "count", featureCount, "maxDrop", g.maxDrop)
+		}
+	}
+
+	if !tripped {
+		st.Baseline = featureCount
+		st.Held = 0
+	}
+
+	st.History = append(st.History, FeatureCount{Time: g.now().UTC(), Count: featureCount, Tripped: tripped})
+	if len(st.History) > maxFeatureCountHistory {
+		st.History = st.History[len(st.History)-maxFeatureCountHistory:]
+	}
+}
+
+// ConfirmDelete records that the current run of the entity type wants to delete the entity,
+// and tells if the deletion should be carried out
+func (g *Safeguard) ConfirmDelete(entityType, entityID string) bool {
+	if g == nil {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	st := &g.state
+	t := st.entityType(entityType)
+
+	p, ok := t.Pending[entityID]
+	if !ok {
+		p = &pendingDeletion{FirstRequested: g.now().UTC()}
+		t.Pending[entityID] = p
+	}
+
+	if st.Held > 0 {
+		p.LastRun = t.Run
+		return false
+	}
+
+	if p.LastRun != t.Run {
+		p.Confirmations++
+		p.LastRun = t.Run
+	}
+
+	if p.Confirmations < g.confirmations {
+		return false
+	}
+
+	delete(t.Pending, entityID)
+	return true
+}
+
+func (st *safeguardState) entityType(name string) *entityTypeSafeguard {
+	t, ok := st.EntityTypes[name]
+	if !ok {
+		t = &entityTypeSafeguard{Pending: map[string]*pendingDeletion{}}
+		st.EntityTypes[name] = t
+	}
+	return t
+}
+
+// Status returns the recent feature counts and the deletions that are waiting to be confirmed
+func (g *Safeguard) Status() SafeguardStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	status := SafeguardStatus{
+		Tripped:       g.state.Held > 0,
+		Baseline:      g.state.Baseline,
+		FeatureCounts: slices.Clone(g.state.History),
+	}
+
+	for entityType, t := range g.state.EntityTypes {
+		for entityID, p := range t.Pending {
+			status.PendingDeletions = append(status.PendingDeletions, PendingDeletion{
+				EntityType:     entityType,
+				EntityID:       entityID,
+				FirstRequested: p.FirstRequested,
+				Confirmations:  p.Confirmations,
+			})
+		}
+	}
+
+	slices.SortFunc(status.PendingDeletions, func(a, b PendingDeletion) int {
+		return cmp.Or(a.FirstRequested.Compare(b.FirstRequested), cmp.Compare(a.EntityType, b.EntityType), cmp.Compare(a.EntityID, b.EntityID))
+	})
+
+	return status
+}
+
+// MarshalJSON saves the feature counts and pending deletions so that they survive a restart
+func (g *Safeguard) MarshalJSON() ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return json.Marshal(g.state)
+}
+
+func (g *Safeguard) UnmarshalJSON(data []byte) error {
+	st := safeguardState{}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	if st.EntityTypes == nil {
+		st.EntityTypes = map[string]*entityTypeSafeguard{}
+	}
+	for _, t := range st.EntityTypes {
+		if t.Pending == nil {
+			t.Pending = map[string]*pendingDeletion{}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.state = st
+	return nil
+}
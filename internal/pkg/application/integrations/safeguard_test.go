@@ -0,0 +1,108 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatDeletionsMustBeConfirmedInConsecutiveRuns(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	g := NewSafeguard(0.3, 2, 3)
+
+	g.Begin(ctx, 100, "beaches")
+	is.True(!g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:1"))
+	is.True(!g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:2"))
+
+	g.Begin(ctx, 100, "beaches")
+	is.True(g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:1"))
+
+	// the second beach was not requested again and has to start over
+	g.Begin(ctx, 100, "beaches")
+	is.True(!g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:2"))
+	is.Equal(len(g.Status().PendingDeletions), 1)
+}
+
+func TestThatARunOfAnotherEntityTypeLeavesDeletionsPending(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	g := NewSafeguard(0.3, 2, 3)
+
+	g.Begin(ctx, 100, "beaches", "trails")
+	is.True(!g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:1"))
+	is.True(!g.ConfirmDelete("trails", "urn:ngsi-ld:ExerciseTrail:1"))
+
+	// a run that is limited to trails neither confirms nor resets the deletion of the beach
+	g.Begin(ctx, 100, "trails")
+	is.True(g.ConfirmDelete("trails", "urn:ngsi-ld:ExerciseTrail:1"))
+
+	pending := g.Status().PendingDeletions
+	is.Equal(len(pending), 1)
+	is.Equal(pending[0].EntityType, "beaches")
+	is.Equal(pending[0].Confirmations, 1)
+
+	g.Begin(ctx, 100, "beaches")
+	is.True(g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:1"))
+}
+
+func TestThatALargeDropInTheFeatureCountHoldsBackDeletions(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	g := NewSafeguard(0.3, 1, 3)
+
+	g.Begin(ctx, 100, "beaches")
+	is.True(g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:1"))
+
+	g.Begin(ctx, 71, "beaches")
+	is.True(g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:2"))
+
+	g.Begin(ctx, 0, "beaches")
+	is.True(g.Status().Tripped)
+	is.True(!g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:3"))
+
+	g.Begin(ctx, 40, "beaches")
+	is.True(!g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:3"))
+	is.Equal(g.Status().Baseline, 71)
+
+	// a drop that persists is eventually accepted
+	g.Begin(ctx, 40, "beaches")
+	is.True(g.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:3"))
+	is.Equal(g.Status().Baseline, 40)
+	is.Equal(len(g.Status().FeatureCounts), 5)
+}
+
+func TestThatTheSafeguardCanBeSavedAndRestored(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	before := NewSafeguard(0.3, 2, 3)
+	before.Begin(ctx, 100, "beaches")
+	before.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:1")
+
+	data, err := json.Marshal(before)
+	is.NoErr(err)
+
+	after := NewSafeguard(0.3, 2, 3)
+	is.NoErr(json.Unmarshal(data, after))
+
+	after.Begin(ctx, 50, "beaches")
+	is.True(after.Status().Tripped)
+
+	after.Begin(ctx, 100, "beaches")
+	is.True(after.ConfirmDelete("beaches", "urn:ngsi-ld:Beach:1"))
+}
+
+func TestThatSafeguardSettingsAreValidated(t *testing.T) {
+	is := is.New(t)
+
+	cfg := mapConfig{"FACILITIES_MAX_FEATURE_DROP": "120%", "FACILITIES_DELETE_CONFIRMATIONS": "0"}
+
+	_, err := SafeguardFromConfig("facilities", cfg)
+	is.True(err != nil)
+}
@@ -0,0 +1,60 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/state"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// Stateful is implemented by integrations that keep bookkeeping between runs, such
+// as features that have already been deleted, which should survive a restart.
+type Stateful interface {
+	MarshalState() ([]byte, error)
+	UnmarshalState(data []byte) error
+}
+
+// RestoreState loads the state that was last saved for the integration, if it keeps any
+func RestoreState(ctx context.Context, i Integration, store state.Store) error {
+	s, ok := i.(Stateful)
+	if !ok || store == nil {
+		return nil
+	}
+
+	data, err := store.Load(i.Name())
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to load state of %s: %w", i.Name(), err)
+	}
+
+	if err = s.UnmarshalState(data); err != nil {
+		return fmt.Errorf("failed to restore state of %s: %w", i.Name(), err)
+	}
+
+	logging.GetFromContext(ctx).Info("restored integration state", "integration", i.Name())
+
+	return nil
+}
+
+// CheckpointState saves the current state of the integration, if it keeps any
+func CheckpointState(i Integration, store state.Store) error {
+	s, ok := i.(Stateful)
+	if !ok || store == nil {
+		return nil
+	}
+
+	data, err := s.MarshalState()
+	if err != nil {
+		return fmt.Errorf("failed to marshal state of %s: %w", i.Name(), err)
+	}
+
+	if err = store.Save(i.Name(), data); err != nil {
+		return fmt.Errorf("failed to save state of %s: %w", i.Name(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package integrations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/schedule"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/state"
+	"github.com/matryer/is"
+)
+
+func TestThatStateIsRestoredAndCheckpointedByTheManager(t *testing.T) {
+	is := is.New(t)
+
+	store := state.NewMemoryStore()
+	is.NoErr(store.Save("statefultest", []byte("3")))
+
+	i := &statefulIntegration{testIntegration: newTestIntegration(context.Background(), nil, nil).(*testIntegration)}
+
+	m := NewManager(WithStateStore(store))
+	m.Add(i, Settings{Schedule: schedule.Every(time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Start(ctx)
+
+	is.NoErr(waitFor(func() bool {
+		data, _ := store.Load("statefultest")
+		return string(data) == "4"
+	}))
+
+	cancel()
+	m.Wait()
+}
+
+type statefulIntegration struct {
+	*testIntegration
+	runs int
+}
+
+func (si *statefulIntegration) Name() string { return "statefultest" }
+
+func (si *statefulIntegration) Run(ctx context.Context, types ...string) error {
+	si.runs++
+	return nil
+}
+
+func (si *statefulIntegration) MarshalState() ([]byte, error) {
+	return []byte{byte('0' + si.runs)}, nil
+}
+
+func (si *statefulIntegration) UnmarshalState(data []byte) error {
+	si.runs = int(data[0] - '0')
+	return nil
+}
+
+func waitFor(condition func() bool) error {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return context.DeadlineExceeded
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package integrations
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Status describes the outcome of the most recent runs of an integration
+type Status struct {
+	Name        string                  `json:"name"`
+	Healthy     bool                    `json:"healthy"`
+	Running     bool                    `json:"running"`
+	LastAttempt time.Time               `json:"lastAttempt,omitzero"`
+	LastSuccess time.Time               `json:"lastSuccess,omitzero"`
+	LastError   string                  `json:"lastError,omitempty"`
+	EntityTypes map[string]EntityStatus `json:"entityTypes,omitempty"`
+	// Safeguard is set by integrations that protect the broker against mass deletions
+	Safeguard *SafeguardStatus `json:"safeguard,omitempty"`
+}
+
+// EntityStatus describes the outcome of the most recent run of a single entity type
+type EntityStatus struct {
+	LastAttempt time.Time `json:"lastAttempt,omitzero"`
+	LastSuccess time.Time `json:"lastSuccess,omitzero"`
+	LastError   string    `json:"lastError,omitempty"`
+	Counters
+	// Orphans lists entities in the broker whose features are no longer in the source
+	Orphans []string `json:"orphans,omitempty"`
+}
+
+// Counters holds the number of features that were handled in different ways during a run
+type Counters struct {
+	Processed int `json:"processed"`
+	Created   int `json:"created"`
+	Merged    int `json:"merged"`
+	Upserted  int `json:"upserted"`
+	Deleted   int `json:"deleted"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+	Orphaned  int `json:"orphaned"`
+	Held      int `json:"held"`
+}
+
+// Tracker keeps track of the status of an integration and is safe for concurrent use
+type Tracker struct {
+	status   Status
+	entities map[string]*EntityStatus
+	started  time.Time
+	mu       sync.Mutex
+}
+
+func NewTracker(name string) *Tracker {
+	return &Tracker{
+		status:   Status{Name: name},
+		entities: map[string]*EntityStatus{},
+	}
+}
+
+// Attempt marks the start of a new run
+func (t *Tracker) Attempt() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.started = time.Now()
+	t.status.Running = true
+	t.status.LastAttempt = t.started.UTC()
+}
+
+// Done marks the end of the current run, successful or not
+func (t *Tracker) Done(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Running = false
+
+	if !t.started.IsZero() {
+		runDuration.WithLabelValues(t.status.Name, result(err)).Observe(time.Since(t.started).Seconds())
+	}
+
+	if err != nil {
+		t.status.LastError = err.Error()
+		return
+	}
+
+	t.status.LastError = ""
+	t.status.LastSuccess = time.Now().UTC()
+}
+
+// Status returns a snapshot of the current status
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.status
+	status.EntityTypes = make(map[string]EntityStatus, len(t.entities))
+
+	for name, es := range t.entities {
+		snapshot := *es
+		snapshot.Orphans = slices.Clone(es.Orphans)
+		status.EntityTypes[name] = snapshot
+	}
+
+	return status
+}
+
+// EntityRun collects the outcome of processing a single entity type during a run
+type EntityRun struct {
+	entityType string
+	tracker    *Tracker
+	started    time.Time
+}
+
+// BeginEntityType marks the start of processing an entity type and resets its counters
+func (t *Tracker) BeginEntityType(entityType string) *EntityRun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	es, ok := t.entities[entityType]
+	if !ok {
+		es = &EntityStatus{}
+		t.entities[entityType] = es
+	}
+
+	es.LastAttempt = time.Now().UTC()
+	es.LastError = ""
+	es.Counters = Counters{}
+	es.Orphans = nil
+
+	return &EntityRun{entityType: entityType, tracker: t, started: time.Now()}
+}
+
+// EntityType returns the entity type that the run processes
+func (r *EntityRun) EntityType() string {
+	return r.entityType
+}
+
+func (r *EntityRun) update(fn func(*EntityStatus)) {
+	r.tracker.mu.Lock()
+	defer r.tracker.mu.Unlock()
+
+	fn(r.tracker.entities[r.entityType])
+}
+
+func (r *EntityRun) labels(extra ...string) []string {
+	return append([]string{r.tracker.status.Name, r.entityType}, extra...)
+}
+
+// Processed records that a source feature matched the entity type
+func (r *EntityRun) Processed() {
+	r.update(func(es *EntityStatus) { es.Processed++ })
+	featuresSeen.WithLabelValues(r.labels()...).Inc()
+}
+
+func (r *EntityRun) Created() { r.operation("created", func(es *EntityStatus) { es.Created++ }) }
+func (r *EntityRun) Merged()  { r.operation("merged", func(es *EntityStatus) { es.Merged++ }) }
+func (r *EntityRun) Deleted() { r.operation("deleted", func(es *EntityStatus) { es.Deleted++ }) }
+
+// Upserted records that an entity in a batch was either created or merged, when the
+// broker does not tell which
+func (r *EntityRun) Upserted() { r.operation("upserted", func(es *EntityStatus) { es.Upserted++ }) }
+
+// Skipped records that a feature needed no changes in the context broker
+func (r *EntityRun) Skipped() { r.operation("skipped", func(es *EntityStatus) { es.Skipped++ }) }
+
+// maxReportedOrphans limits the number of orphaned entities that are listed in the status
+const maxReportedOrphans int = 100
+
+// Orphaned records that an entity in the context broker no longer has a feature in the source
+func (r *EntityRun) Orphaned(entityID string) {
+	r.operation("orphaned", func(es *EntityStatus) {
+		es.Orphaned++
+		if len(es.Orphans) < maxReportedOrphans {
+			es.Orphans = append(es.Orphans, entityID)
+		}
+	})
+}
+
+// Held records that the deletion of an entity was held back by a safeguard
+func (r *EntityRun) Held() { r.operation("held", func(es *EntityStatus) { es.Held++ }) }
+
+func (r *EntityRun) operation(name string, fn func(*EntityStatus)) {
+	r.update(fn)
+	entityOperations.WithLabelValues(r.labels(name)...).Inc()
+}
+
+// Failed records that a request to the context broker failed for a single
+// feature. The run as a whole can still be successful.
+func (r *EntityRun) Failed(err error) {
+	r.failed(err)
+	brokerErrors.WithLabelValues(r.labels(brokerErrorKind(err))...).Inc()
+}
+
+// Rejected records that a source feature could not be converted into an entity
+func (r *EntityRun) Rejected(err error) {
+	r.failed(err)
+	featuresRejected.WithLabelValues(r.labels()...).Inc()
+}
+
+func (r *EntityRun) failed(err error) {
+	r.update(func(es *EntityStatus) {
+		es.Failed++
+		es.LastError = err.Error()
+	})
+}
+
+// Done marks the end of processing the entity type
+func (r *EntityRun) Done(err error) {
+	now := time.Now()
+
+	r.update(func(es *EntityStatus) {
+		if err != nil {
+			es.LastError = err.Error()
+			return
+		}
+
+		es.LastSuccess = now.UTC()
+	})
+
+	entityTypeDuration.WithLabelValues(r.labels(result(err))...).Observe(now.Sub(r.started).Seconds())
+	if err == nil {
+		lastSuccess.WithLabelValues(r.labels()...).Set(float64(now.Unix()))
+	}
+}
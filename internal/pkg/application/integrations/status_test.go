@@ -0,0 +1,51 @@
+package integrations
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatEntityCountersAreResetBetweenRuns(t *testing.T) {
+	is := is.New(t)
+	tracker := NewTracker("test")
+
+	run := tracker.BeginEntityType("beaches")
+	run.Processed()
+	run.Processed()
+	run.Created()
+	run.Failed(errors.New("broker said no"))
+	run.Done(nil)
+
+	beaches := tracker.Status().EntityTypes["beaches"]
+	is.Equal(beaches.Counters, Counters{Processed: 2, Created: 1, Failed: 1})
+	is.Equal(beaches.LastError, "broker said no")
+	is.True(!beaches.LastSuccess.IsZero())
+
+	run = tracker.BeginEntityType("beaches")
+	run.Processed()
+	run.Merged()
+	run.Done(nil)
+
+	beaches = tracker.Status().EntityTypes["beaches"]
+	is.Equal(beaches.Counters, Counters{Processed: 1, Merged: 1})
+	is.Equal(beaches.LastError, "")
+}
+
+func TestThatFailedRunsKeepTheLastSuccess(t *testing.T) {
+	is := is.New(t)
+	tracker := NewTracker("test")
+
+	tracker.Attempt()
+	tracker.Done(nil)
+	lastSuccess := tracker.Status().LastSuccess
+
+	tracker.Attempt()
+	tracker.Done(errors.New("401 unauthorized"))
+
+	status := tracker.Status()
+	is.Equal(status.LastSuccess, lastSuccess)
+	is.Equal(status.LastError, "401 unauthorized")
+	is.True(!status.Running)
+}
@@ -0,0 +1,109 @@
+package integrations
+
+import (
+	"cmp"
+	"strings"
+	"sync"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/jsonld"
+)
+
+// Brokers returns the context broker client for the tenant that an entity type of an
+// integration is written to. An empty entity type stands for the integration as a whole.
+type Brokers func(entityType string) client.ContextBrokerClient
+
+// SingleBroker writes every entity type with the same client
+func SingleBroker(broker client.ContextBrokerClient) Brokers {
+	return func(string) client.ContextBrokerClient { return broker }
+}
+
+// Tenants decides which tenant of the context broker each integration, and each of its
+// entity types, is written to, and creates a client for each tenant the first time it is
+// needed. The clients write and read entities with the JSON-LD context of the entity type,
+// see JSONLDContextFromConfig. The tenant is configured by the following keys, the most
+// specific one first:
+//
+//	<NAME>_<TYPE>_TENANT    the tenant of an entity type, e.g. FACILITIES_BEACHES_TENANT
+//	<NAME>_TENANT           the tenant of an integration
+//	CONTEXT_BROKER_TENANT   the tenant of everything else, if not the default tenant of the broker
+//
+// A nil *Tenants writes everything to the default tenant with a nil client, so that the
+// integrations can still be created and validated when the context broker is misconfigured.
+type Tenants struct {
+	cfg     Config
+	create  func(tenant string) client.ContextBrokerClient
+	clients map[string]client.ContextBrokerClient
+	ld      map[ldKey]client.ContextBrokerClient
+	mu      sync.Mutex
+}
+
+// ldKey identifies the clients of a tenant that write with a certain JSON-LD context
+type ldKey struct {
+	tenant  string
+	context jsonld.Context
+}
+
+// NewTenants returns tenants whose clients are created by create
+func NewTenants(cfg Config, create func(tenant string) client.ContextBrokerClient) *Tenants {
+	return &Tenants{
+		cfg:     cfg,
+		create:  create,
+		clients: map[string]client.ContextBrokerClient{},
+		ld:      map[ldKey]client.ContextBrokerClient{},
+	}
+}
+
+// Tenant returns the tenant that an entity type of the named integration is written to
+func (t *Tenants) Tenant(integration, entityType string) string {
+	if t == nil {
+		return entities.DefaultNGSITenant
+	}
+
+	prefix := strings.ToUpper(integration)
+
+	var byType string
+	if entityType != "" {
+		byType = t.cfg.Get(prefix + "_" + strings.ToUpper(entityType) + "_TENANT")
+	}
+
+	return cmp.Or(byType, t.cfg.Get(prefix+"_TENANT"), t.cfg.Get("CONTEXT_BROKER_TENANT"), entities.DefaultNGSITenant)
+}
+
+// Broker returns the client for the tenant that an entity type of the named integration
+// is written to
+func (t *Tenants) Broker(integration, entityType string) client.ContextBrokerClient {
+	if t == nil {
+		return nil
+	}
+
+	tenant := t.Tenant(integration, entityType)
+	// an invalid context is reported by New, before any entities are written
+	context, _ := JSONLDContextFromConfig(integration, entityType, t.cfg)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := ldKey{tenant: tenant, context: context}
+	if broker, ok := t.ld[key]; ok {
+		return broker
+	}
+
+	broker, ok := t.clients[tenant]
+	if !ok {
+		broker = t.create(tenant)
+		t.clients[tenant] = broker
+	}
+
+	t.ld[key] = jsonld.NewClient(broker, context)
+
+	return t.ld[key]
+}
+
+// For returns the brokers of the named integration
+func (t *Tenants) For(integration string) Brokers {
+	return func(entityType string) client.ContextBrokerClient {
+		return t.Broker(integration, entityType)
+	}
+}
@@ -0,0 +1,55 @@
+package integrations
+
+import (
+	"testing"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/matryer/is"
+)
+
+func TestThatTheMostSpecificTenantIsUsed(t *testing.T) {
+	is := is.New(t)
+
+	tenants := NewTenants(mapConfig{}, nil)
+	is.Equal(tenants.Tenant("facilities", "beaches"), entities.DefaultNGSITenant)
+
+	tenants = NewTenants(mapConfig{
+		"CONTEXT_BROKER_TENANT":     "sundsvall",
+		"FACILITIES_TENANT":         "facilities",
+		"FACILITIES_BEACHES_TENANT": "beaches",
+	}, nil)
+
+	is.Equal(tenants.Tenant("facilities", "beaches"), "beaches")
+	is.Equal(tenants.Tenant("facilities", "trails"), "facilities")
+	is.Equal(tenants.Tenant("facilities", ""), "facilities")
+	is.Equal(tenants.Tenant("citywork", ""), "sundsvall")
+}
+
+func TestThatEachTenantGetsAClientOfItsOwn(t *testing.T) {
+	is := is.New(t)
+
+	var created []string
+	tenants := NewTenants(mapConfig{"FACILITIES_BEACHES_TENANT": "beaches"}, func(tenant string) client.ContextBrokerClient {
+		created = append(created, tenant)
+		return &test.ContextBrokerClientMock{}
+	})
+
+	brokers := tenants.For("facilities")
+
+	beaches, trails := brokers("beaches"), brokers("trails")
+	is.True(beaches != trails)
+	is.Equal(brokers("sports"), trails)
+	is.Equal(tenants.Broker("citywork", ""), trails)
+	is.Equal(created, []string{"beaches", entities.DefaultNGSITenant})
+}
+
+func TestThatNilTenantsHaveNoClients(t *testing.T) {
+	is := is.New(t)
+
+	var tenants *Tenants
+
+	is.Equal(tenants.Tenant("facilities", "beaches"), entities.DefaultNGSITenant)
+	is.True(tenants.For("facilities")("beaches") == nil)
+}
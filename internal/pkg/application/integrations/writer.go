@@ -0,0 +1,475 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/batch"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/circuitbreaker"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// DefaultWorkers is the number of concurrent requests that a writer sends to the
+// broker unless BROKER_WORKERS says otherwise
+const DefaultWorkers int = 4
+
+// BrokerWorkers returns the number of concurrent writes per entity type that is
+// configured by BROKER_WORKERS. DefaultWorkers is returned along with any error.
+func BrokerWorkers(cfg Config) (int, error) {
+	value := cfg.Get("BROKER_WORKERS")
+	if value == "" {
+		return DefaultWorkers, nil
+	}
+
+	workers, err := strconv.Atoi(value)
+	if err != nil || workers < 1 {
+		return DefaultWorkers, fmt.Errorf("BROKER_WORKERS must be set to a positive number, not %q", value)
+	}
+
+	return workers, nil
+}
+
+// Writer sends the entities of a single entity type run to the context broker, using
+// a bounded number of concurrent requests. The outcome of every write is recorded on
+// the run. Rate limiting is left to the broker client.
+//
+// If the broker client is a batch.Writer, entities are collected and sent in batches
+// instead of one request, or two for new entities, per entity.
+type Writer struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	broker client.ContextBrokerClient
+	run    *EntityRun
+	outbox *Outbox
+	index  *Index
+	jobs   chan func()
+	wg     sync.WaitGroup
+
+	batches batch.Writer
+	upserts []pending
+	deletes []pending
+	mu      sync.Mutex
+}
+
+// pending is an entity that is waiting to be sent to the broker in a batch
+type pending struct {
+	entityID  string
+	entity    types.Entity
+	onSuccess []func()
+}
+
+var writeHeaders = map[string][]string{"Content-Type": {"application/ld+json"}}
+
+// WithIndex makes the writer decide locally whether an entity has to be created, merged
+// or deleted, based on the entities that were prefetched from the broker
+func WithIndex(ix *Index) func(*Writer) {
+	return func(w *Writer) {
+		w.index = ix
+	}
+}
+
+// NewWriter starts a writer with the given number of workers. Wait must be called
+// to stop the workers once all entities have been written. The writer gives up as
+// soon as a circuit breaker in the broker client has opened. Writes that fail, or that
+// are given up on, are added to the outbox of the context, if it has one.
+func NewWriter(ctx context.Context, broker client.ContextBrokerClient, run *EntityRun, workers int, options ...func(*Writer)) *Writer {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	w := &Writer{
+		ctx:    ctx,
+		cancel: cancel,
+		broker: broker,
+		run:    run,
+		outbox: outboxFromContext(ctx),
+		jobs:   make(chan func()),
+	}
+
+	if batches, ok := broker.(batch.Writer); ok && batches.BatchSize() > 0 {
+		w.batches = batches
+	}
+
+	for _, option := range options {
+		option(w)
+	}
+
+	for range max(workers, 1) {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for job := range w.jobs {
+				job()
+			}
+		}()
+	}
+
+	return w
+}
+
+// Upsert merges the attributes into an existing entity, or creates the entity if it
+// does not exist. The optional callbacks are called by a worker if the write succeeds.
+func (w *Writer) Upsert(entityID, entityType string, attributes []entities.EntityDecoratorFunc, onSuccess ...func()) {
+	w.upsert(entityID, entityType, attributes, attributes, onSuccess)
+}
+
+// UpsertChanges is like Upsert, but only merges the attributes that have changed since
+// the entity was last written according to the fingerprints. An entity without changes
+// is counted as skipped without sending anything to the broker. A missing entity is
+// still created with all of its attributes, and so is every entity in a batch.
+func (w *Writer) UpsertChanges(fingerprints *Fingerprints, entityID, entityType string, attributes []entities.EntityDecoratorFunc, onSuccess ...func()) {
+	if w.index != nil {
+		if existing, ok := w.index.Get(entityID); ok {
+			fingerprints.Seed(entityID, existing)
+		} else {
+			// the entity has to be created, whatever was written before
+			fingerprints.Forget(entityID)
+		}
+	}
+
+	changed, commit := fingerprints.Changes(entityID, entityType, attributes)
+	if len(changed) == 0 {
+		w.run.Skipped()
+		return
+	}
+
+	w.upsert(entityID, entityType, changed, attributes, append([]func(){commit}, onSuccess...))
+}
+
+// upsert merges the changed attributes into an existing entity, or creates the entity
+// with all attributes if it does not exist
+func (w *Writer) upsert(entityID, entityType string, changed, attributes []entities.EntityDecoratorFunc, onSuccess []func()) {
+	if w.batches != nil {
+		entity, err := entities.New(entityID, entityType, attributes...)
+		if err != nil {
+			logging.GetFromContext(w.ctx).Error("entities.New failed", "entityID", entityID, "err", err.Error())
+			w.run.Rejected(err)
+			return
+		}
+
+		w.enqueue(OperationUpsert, &w.upserts, pending{entityID: entityID, entity: entity, onSuccess: onSuccess}, w.upsertBatch)
+		return
+	}
+
+	entity, err := entities.New(entityID, entityType, attributes...)
+	if err != nil {
+		logging.GetFromContext(w.ctx).Error("entities.New failed", "entityID", entityID, "err", err.Error())
+		w.run.Rejected(err)
+		return
+	}
+
+	w.submit(func() {
+		logger := logging.GetFromContext(w.ctx)
+
+		var mergeErr error
+
+		// an entity that is known to be missing is created without trying to merge it first
+		if w.index == nil || w.index.Contains(entityID) {
+			fragment, _ := entities.NewFragment(changed...)
+
+			_, mergeErr = w.broker.MergeEntity(w.ctx, entityID, fragment, writeHeaders)
+			w.stopIfRefused(mergeErr)
+
+			if mergeErr == nil {
+				w.run.Merged()
+				w.succeeded(entityID, onSuccess)
+				return
+			}
+		}
+
+		if mergeErr != nil && !errors.Is(mergeErr, ngsierrors.ErrNotFound) {
+			logger.Error("failed to merge entity", "entityID", entityID, "err", mergeErr.Error())
+			w.failed(OperationUpsert, entityID, entity, mergeErr, onSuccess)
+			return
+		}
+
+		_, err := w.broker.CreateEntity(w.ctx, entity, writeHeaders)
+		w.stopIfRefused(err)
+
+		if err != nil {
+			logger.Error("failed to create entity", "entityID", entityID, "err", err.Error())
+			w.failed(OperationUpsert, entityID, entity, err, onSuccess)
+			return
+		}
+
+		logger.Info("created entity", "entityID", entityID)
+		w.run.Created()
+		w.succeeded(entityID, onSuccess)
+	}, func(err error) {
+		w.failed(OperationUpsert, entityID, entity, err, onSuccess)
+	})
+}
+
+// Merge merges the attributes into an existing entity without creating it if it does not
+// exist, in which case the write is counted as skipped. A merge that fails is not kept in
+// the outbox, since the entity could not be created from the attributes if it was missing
+// when the merge was replayed.
+func (w *Writer) Merge(entityID string, attributes []entities.EntityDecoratorFunc, onSuccess ...func()) {
+	w.submit(func() {
+		fragment, _ := entities.NewFragment(attributes...)
+
+		_, err := w.broker.MergeEntity(w.ctx, entityID, fragment, writeHeaders)
+		w.stopIfRefused(err)
+
+		switch {
+		case err == nil:
+			w.run.Merged()
+		case errors.Is(err, ngsierrors.ErrNotFound):
+			w.run.Skipped()
+		default:
+			logging.GetFromContext(w.ctx).Error("failed to merge entity", "entityID", entityID, "err", err.Error())
+			w.run.Failed(err)
+			return
+		}
+
+		w.succeeded(entityID, onSuccess)
+	}, w.run.Failed)
+}
+
+// Delete removes an entity from the context broker. An entity that does not exist is
+// counted as skipped.
+func (w *Writer) Delete(entityID string, onSuccess ...func()) {
+	if w.index != nil && !w.index.Contains(entityID) {
+		w.run.Skipped()
+		w.succeeded(entityID, onSuccess)
+		return
+	}
+
+	if w.batches != nil {
+		w.enqueue(OperationDelete, &w.deletes, pending{entityID: entityID, onSuccess: onSuccess}, w.deleteBatch)
+		return
+	}
+
+	w.submit(func() {
+		_, err := w.broker.DeleteEntity(w.ctx, entityID)
+		w.stopIfRefused(err)
+
+		switch {
+		case err == nil:
+			w.run.Deleted()
+		case errors.Is(err, ngsierrors.ErrNotFound):
+			w.run.Skipped()
+		default:
+			logging.GetFromContext(w.ctx).Info("could not delete entity", "entityID", entityID, "err", err.Error())
+			w.failed(OperationDelete, entityID, nil, err, onSuccess)
+			return
+		}
+
+		w.succeeded(entityID, onSuccess)
+	}, func(err error) {
+		w.failed(OperationDelete, entityID, nil, err, onSuccess)
+	})
+}
+
+// Err returns the reason that the writer has stopped writing, if it has
+func (w *Writer) Err() error {
+	if w.ctx.Err() != nil {
+		return context.Cause(w.ctx)
+	}
+	return nil
+}
+
+// Wait blocks until all submitted writes have completed and stops the workers. It
+// returns the reason that the writer gave up, if it did. The writer can not be used after
+// Wait has returned.
+func (w *Writer) Wait() error {
+	w.mu.Lock()
+	upserts, deletes := w.upserts, w.deletes
+	w.upserts, w.deletes = nil, nil
+	w.mu.Unlock()
+
+	if len(upserts) > 0 {
+		w.submitBatch(OperationUpsert, upserts, w.upsertBatch)
+	}
+
+	if len(deletes) > 0 {
+		w.submitBatch(OperationDelete, deletes, w.deleteBatch)
+	}
+
+	close(w.jobs)
+	w.wg.Wait()
+
+	err := w.Err()
+	w.cancel(nil)
+
+	return err
+}
+
+// stopIfRefused stops the writer if the broker client refused a request because its
+// circuit breaker is open, since every remaining write would be refused as well. The
+// refused write fails like any other.
+func (w *Writer) stopIfRefused(err error) {
+	if errors.Is(err, circuitbreaker.ErrOpen) {
+		w.cancel(err)
+	}
+}
+
+// submit hands the job to the next free worker. A job that is submitted after the writer
+// has stopped is not run, and is given up on with the reason that the writer stopped.
+func (w *Writer) submit(job func(), giveUp func(error)) {
+	select {
+	case w.jobs <- job:
+	case <-w.ctx.Done():
+		giveUp(context.Cause(w.ctx))
+	}
+}
+
+// submitBatch hands a batch to the next free worker, or fails every entity in it if the
+// writer has stopped
+func (w *Writer) submitBatch(op Operation, batch []pending, send func([]pending)) {
+	w.submit(func() { send(batch) }, func(err error) {
+		for _, p := range batch {
+			w.failed(op, p.entityID, p.entity, err, p.onSuccess)
+		}
+	})
+}
+
+// enqueue adds an entity to a batch, and hands the batch to a worker once it is full
+func (w *Writer) enqueue(op Operation, queue *[]pending, p pending, send func([]pending)) {
+	w.mu.Lock()
+	*queue = append(*queue, p)
+
+	var full []pending
+	if len(*queue) >= w.batches.BatchSize() {
+		full = *queue
+		*queue = nil
+	}
+	w.mu.Unlock()
+
+	if full != nil {
+		w.submitBatch(op, full, send)
+	}
+}
+
+func (w *Writer) upsertBatch(batch []pending) {
+	logger := logging.GetFromContext(w.ctx)
+
+	upserts := make([]types.Entity, 0, len(batch))
+	for _, p := range batch {
+		upserts = append(upserts, p.entity)
+	}
+
+	result, err := w.batches.UpsertEntities(w.ctx, upserts, writeHeaders)
+	w.stopIfRefused(err)
+
+	if err != nil {
+		logger.Error("failed to upsert batch of entities", "size", len(batch), "err", err.Error())
+		for _, p := range batch {
+			w.failed(OperationUpsert, p.entityID, p.entity, err, p.onSuccess)
+		}
+		return
+	}
+
+	answered := map[string]bool{}
+
+	w.recordSuccess(batch, result.Created, w.run.Created, answered)
+	w.recordSuccess(batch, result.Updated, w.run.Merged, answered)
+	w.recordSuccess(batch, result.Succeeded, w.run.Upserted, answered)
+
+	for entityID, err := range result.Errors {
+		logger.Error("failed to upsert entity", "entityID", entityID, "err", err.Error())
+
+		if i := slices.IndexFunc(batch, func(p pending) bool { return p.entityID == entityID }); i >= 0 {
+			answered[entityID] = true
+			w.failed(OperationUpsert, entityID, batch[i].entity, err, batch[i].onSuccess)
+		} else {
+			w.run.Failed(err)
+		}
+	}
+
+	for _, p := range unanswered(batch, answered) {
+		logger.Error("broker did not report the outcome of an upsert", "entityID", p.entityID)
+		w.failed(OperationUpsert, p.entityID, p.entity, errNoOutcome, p.onSuccess)
+	}
+}
+
+func (w *Writer) deleteBatch(batch []pending) {
+	logger := logging.GetFromContext(w.ctx)
+
+	ids := make([]string, 0, len(batch))
+	for _, p := range batch {
+		ids = append(ids, p.entityID)
+	}
+
+	result, err := w.batches.DeleteEntities(w.ctx, ids)
+	w.stopIfRefused(err)
+
+	if err != nil {
+		logger.Info("could not delete batch of entities", "size", len(batch), "err", err.Error())
+		for _, p := range batch {
+			w.failed(OperationDelete, p.entityID, nil, err, p.onSuccess)
+		}
+		return
+	}
+
+	answered := map[string]bool{}
+
+	w.recordSuccess(batch, slices.Concat(result.Updated, result.Succeeded), w.run.Deleted, answered)
+
+	notFound := []string{}
+	for entityID, err := range result.Errors {
+		if errors.Is(err, ngsierrors.ErrNotFound) {
+			notFound = append(notFound, entityID)
+			continue
+		}
+
+		logger.Info("could not delete entity", "entityID", entityID, "err", err.Error())
+
+		if i := slices.IndexFunc(batch, func(p pending) bool { return p.entityID == entityID }); i >= 0 {
+			answered[entityID] = true
+			w.failed(OperationDelete, entityID, nil, err, batch[i].onSuccess)
+		} else {
+			w.run.Failed(err)
+		}
+	}
+
+	// an entity that does not exist is as good as deleted, just like when it is deleted on its own
+	w.recordSuccess(batch, notFound, w.run.Skipped, answered)
+
+	for _, p := range unanswered(batch, answered) {
+		logger.Info("broker did not report the outcome of a delete", "entityID", p.entityID)
+		w.failed(OperationDelete, p.entityID, nil, errNoOutcome, p.onSuccess)
+	}
+}
+
+// errNoOutcome fails the entities of a batch that the broker left out of its response
+var errNoOutcome = errors.New("the broker did not report the outcome of the entity")
+
+// recordSuccess records the entities of a batch that were written successfully and calls their
+// callbacks. The entities are marked as answered, so that those left out can be told apart.
+func (w *Writer) recordSuccess(batch []pending, entityIDs []string, record func(), answered map[string]bool) {
+	for _, id := range entityIDs {
+		i := slices.IndexFunc(batch, func(p pending) bool { return p.entityID == id })
+		if i >= 0 {
+			answered[id] = true
+			record()
+			w.succeeded(id, batch[i].onSuccess)
+		}
+	}
+}
+
+// unanswered returns the entities of a batch whose outcome the broker did not report
+func unanswered(batch []pending, answered map[string]bool) []pending {
+	return slices.DeleteFunc(slices.Clone(batch), func(p pending) bool { return answered[p.entityID] })
+}
+
+func (w *Writer) succeeded(entityID string, callbacks []func()) {
+	w.outbox.done(entityID)
+
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// failed records a write that the broker did not accept and leaves it to the outbox to replay
+// it, along with the callbacks that the write would have called had it succeeded
+func (w *Writer) failed(op Operation, entityID string, entity types.Entity, err error, onSuccess []func()) {
+	w.run.Failed(err)
+	w.outbox.add(w.run, op, entityID, entity, err, onSuccess)
+}
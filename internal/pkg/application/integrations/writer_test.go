@@ -0,0 +1,272 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
+	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/batch"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/circuitbreaker"
+	"github.com/matryer/is"
+)
+
+func TestThatTheWriterCreatesEntitiesThatDoNotExist(t *testing.T) {
+	is := is.New(t)
+
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			if entityID == "urn:ngsi-ld:Beach:new" {
+				return nil, ngsierrors.ErrNotFound
+			}
+			return &ngsild.MergeEntityResult{}, nil
+		},
+		CreateEntityFunc: func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+			return ngsild.NewCreateEntityResult(""), nil
+		},
+		DeleteEntityFunc: func(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
+			return nil, ngsierrors.ErrNotFound
+		},
+	}
+
+	tracker := NewTracker("writertest")
+	run := tracker.BeginEntityType("beaches")
+	w := NewWriter(context.Background(), broker, run, 2)
+
+	attributes := []entities.EntityDecoratorFunc{decorators.Name("Stranden")}
+	succeeded := atomic.Int32{}
+
+	w.Upsert("urn:ngsi-ld:Beach:new", "Beach", attributes, func() { succeeded.Add(1) })
+	w.Upsert("urn:ngsi-ld:Beach:old", "Beach", attributes, func() { succeeded.Add(1) })
+	w.Delete("urn:ngsi-ld:Beach:gone")
+	w.Wait()
+
+	is.Equal(tracker.Status().EntityTypes["beaches"].Counters, Counters{Created: 1, Merged: 1, Skipped: 1})
+	is.Equal(succeeded.Load(), int32(2))
+	is.Equal(len(broker.CreateEntityCalls()), 1)
+}
+
+func TestThatMergeNeverCreatesEntities(t *testing.T) {
+	is := is.New(t)
+
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			if entityID == "urn:ngsi-ld:Beach:gone" {
+				return nil, ngsierrors.ErrNotFound
+			}
+			return &ngsild.MergeEntityResult{}, nil
+		},
+	}
+
+	tracker := NewTracker("writertest")
+	run := tracker.BeginEntityType("beaches")
+	w := NewWriter(context.Background(), broker, run, 2)
+
+	attributes := []entities.EntityDecoratorFunc{decorators.Status("closed")}
+	succeeded := atomic.Int32{}
+
+	w.Merge("urn:ngsi-ld:Beach:old", attributes, func() { succeeded.Add(1) })
+	w.Merge("urn:ngsi-ld:Beach:gone", attributes, func() { succeeded.Add(1) })
+	w.Wait()
+
+	is.Equal(tracker.Status().EntityTypes["beaches"].Counters, Counters{Merged: 1, Skipped: 1})
+	is.Equal(succeeded.Load(), int32(2))
+}
+
+func TestThatOneFailingEntityDoesNotStallTheOthers(t *testing.T) {
+	is := is.New(t)
+
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			if entityID == "slow" {
+				time.Sleep(200 * time.Millisecond)
+				return nil, errors.New("[code: 500] broker is unwell")
+			}
+			return &ngsild.MergeEntityResult{}, nil
+		},
+	}
+
+	tracker := NewTracker("writertest")
+	w := NewWriter(context.Background(), broker, tracker.BeginEntityType("trails"), 4)
+
+	started := time.Now()
+	w.Upsert("slow", "ExerciseTrail", nil)
+	for range 20 {
+		w.Upsert("fast", "ExerciseTrail", nil)
+	}
+	w.Wait()
+
+	is.True(time.Since(started) < time.Second)
+	is.Equal(tracker.Status().EntityTypes["trails"].Counters, Counters{Merged: 20, Failed: 1})
+}
+
+func TestThatTheWriterStopsWhenTheCircuitBreakerOpens(t *testing.T) {
+	is := is.New(t)
+
+	broker := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return nil, errors.New("[code: 503] broker is unwell")
+		},
+	}
+
+	tracker := NewTracker("writertest")
+	w := NewWriter(context.Background(), circuitbreaker.NewClient(broker, circuitbreaker.New(3, time.Minute)), tracker.BeginEntityType("trails"), 1)
+
+	for range 20 {
+		w.Upsert("trail", "ExerciseTrail", nil)
+	}
+	err := w.Wait()
+
+	is.True(errors.Is(err, circuitbreaker.ErrOpen))
+	is.Equal(len(broker.MergeEntityCalls()), 3)
+	// the writes that were refused or given up on fail as well
+	is.Equal(tracker.Status().EntityTypes["trails"].Counters, Counters{Failed: 20})
+}
+
+func TestThatEntitiesAreWrittenInBatchesIfTheBrokerSupportsIt(t *testing.T) {
+	is := is.New(t)
+
+	broker := &batchBroker{ContextBrokerClientMock: &test.ContextBrokerClientMock{}, size: 2}
+
+	tracker := NewTracker("writertest")
+	w := NewWriter(context.Background(), broker, tracker.BeginEntityType("beaches"), 2)
+
+	attributes := []entities.EntityDecoratorFunc{decorators.Name("Stranden")}
+	succeeded := atomic.Int32{}
+
+	for _, id := range []string{"urn:ngsi-ld:Beach:1", "urn:ngsi-ld:Beach:2", "urn:ngsi-ld:Beach:3"} {
+		w.Upsert(id, "Beach", attributes, func() { succeeded.Add(1) })
+	}
+	w.Delete("urn:ngsi-ld:Beach:4")
+	w.Wait()
+
+	is.Equal(broker.upserts.Load(), int32(2)) // three entities in batches of two
+	is.Equal(len(broker.MergeEntityCalls()), 0)
+	is.Equal(succeeded.Load(), int32(2))
+
+	counters := tracker.Status().EntityTypes["beaches"].Counters
+	is.Equal(counters, Counters{Upserted: 2, Skipped: 1, Failed: 1})
+}
+
+func TestThatBatchedDeletesOfMissingEntitiesSucceedAndUnreportedEntitiesFail(t *testing.T) {
+	is := is.New(t)
+
+	broker := &batchBroker{ContextBrokerClientMock: &test.ContextBrokerClientMock{}, size: 3, omit: "urn:ngsi-ld:Beach:3"}
+
+	tracker := NewTracker("writertest")
+	w := NewWriter(context.Background(), broker, tracker.BeginEntityType("beaches"), 1)
+
+	deleted := []string{}
+	for _, id := range []string{"urn:ngsi-ld:Beach:1", "urn:ngsi-ld:Beach:2", "urn:ngsi-ld:Beach:3"} {
+		w.Delete(id, func() { deleted = append(deleted, id) })
+	}
+	w.Wait()
+
+	is.Equal(len(deleted), 2) // the entity that the broker left out must not be forgotten
+	is.True(!slices.Contains(deleted, "urn:ngsi-ld:Beach:3"))
+
+	counters := tracker.Status().EntityTypes["beaches"].Counters
+	is.Equal(counters, Counters{Skipped: 2, Failed: 1})
+}
+
+// batchBroker fails every upsert of urn:ngsi-ld:Beach:2 and every delete with not found, and
+// leaves the entity omit out of every result
+type batchBroker struct {
+	*test.ContextBrokerClientMock
+	size    int
+	omit    string
+	upserts atomic.Int32
+}
+
+func (b *batchBroker) BatchSize() int { return b.size }
+
+func (b *batchBroker) UpsertEntities(ctx context.Context, upserts []types.Entity, headers map[string][]string) (batch.Result, error) {
+	b.upserts.Add(1)
+
+	result := batch.Result{Errors: map[string]error{}}
+	for _, e := range upserts {
+		if e.ID() == b.omit {
+			continue
+		}
+		if e.ID() == "urn:ngsi-ld:Beach:2" {
+			result.Errors[e.ID()] = ngsierrors.NewBadRequestDataError("invalid")
+		} else {
+			result.Succeeded = append(result.Succeeded, e.ID())
+		}
+	}
+
+	return result, nil
+}
+
+func (b *batchBroker) DeleteEntities(ctx context.Context, entityIDs []string) (batch.Result, error) {
+	result := batch.Result{Errors: map[string]error{}}
+	for _, id := range entityIDs {
+		if id != b.omit {
+			result.Errors[id] = ngsierrors.ErrNotFound
+		}
+	}
+	return result, nil
+}
+
+func TestThatThePrefetchedIndexDecidesHowEntitiesAreWritten(t *testing.T) {
+	is := is.New(t)
+
+	existing, _ := entities.New("urn:ngsi-ld:Beach:1", "Beach", decorators.Name("Stranden"))
+
+	broker := &test.ContextBrokerClientMock{
+		QueryEntitiesFunc: func(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+			result := ngsild.NewQueryEntitiesResult()
+			go func() {
+				result.Found <- existing
+				result.Found <- nil
+			}()
+			return result, nil
+		},
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return &ngsild.MergeEntityResult{}, nil
+		},
+		CreateEntityFunc: func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+			return ngsild.NewCreateEntityResult(""), nil
+		},
+	}
+
+	index, err := PrefetchEntities(context.Background(), broker, "Beach", "urn:ngsi-ld:Beach:")
+	is.NoErr(err)
+
+	fingerprints := NewFingerprints(time.Hour)
+	tracker := NewTracker("indextest")
+
+	w := NewWriter(context.Background(), broker, tracker.BeginEntityType("beaches"), 2, WithIndex(index))
+	w.UpsertChanges(fingerprints, "urn:ngsi-ld:Beach:1", "Beach", []entities.EntityDecoratorFunc{decorators.Name("Stranden")})
+	w.UpsertChanges(fingerprints, "urn:ngsi-ld:Beach:2", "Beach", []entities.EntityDecoratorFunc{decorators.Name("Viken")})
+	w.Delete("urn:ngsi-ld:Beach:3")
+	is.NoErr(w.Wait())
+
+	is.Equal(len(broker.MergeEntityCalls()), 0) // the first beach is already up to date
+	is.Equal(len(broker.CreateEntityCalls()), 1)
+	is.Equal(broker.CreateEntityCalls()[0].Entity.ID(), "urn:ngsi-ld:Beach:2")
+
+	beaches := tracker.Status().EntityTypes["beaches"]
+	is.Equal(beaches.Skipped, 2)
+	is.Equal(beaches.Created, 1)
+}
+
+func TestThatBrokerWorkersMustBePositive(t *testing.T) {
+	is := is.New(t)
+
+	workers, err := BrokerWorkers(mapConfig{"BROKER_WORKERS": "0"})
+	is.True(err != nil)
+	is.Equal(workers, DefaultWorkers)
+
+	workers, err = BrokerWorkers(mapConfig{"BROKER_WORKERS": "8"})
+	is.NoErr(err)
+	is.Equal(workers, 8)
+}
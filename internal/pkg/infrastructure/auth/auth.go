@@ -0,0 +1,168 @@
+// Package auth adds credentials to the requests that are sent to source systems and to the
+// context broker, either as a static API key or bearer token, as an OAuth2 access token
+// obtained with the client credentials grant, or as a client certificate, or a combination
+// of a certificate and one of the others.
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Authenticator adds credentials to a request before it is sent
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// invalidator is implemented by authenticators whose credentials can be replaced when the
+// server no longer accepts them, such as an access token that was revoked before it expired.
+// Invalidate is given the rejected request as it was sent.
+type invalidator interface {
+	Invalidate(req *http.Request)
+}
+
+// None sends requests without adding any credentials
+var None Authenticator = none{}
+
+type none struct{}
+
+func (none) Authenticate(*http.Request) error { return nil }
+
+type bearerToken struct {
+	token Secret
+}
+
+// BearerToken sends a token in the Authorization header of every request. The token is
+// read from the secret for every request, so that a token in a file can be replaced.
+func BearerToken(token Secret) Authenticator {
+	return bearerToken{token: token}
+}
+
+func (a bearerToken) Authenticate(req *http.Request) error {
+	token, err := a.token()
+	if err != nil {
+		return fmt.Errorf("failed to read the bearer token: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+type apiKey struct {
+	header string
+	key    string
+}
+
+// APIKey sends a static key in the given header of every request
+func APIKey(header, key string) Authenticator {
+	return apiKey{header: header, key: key}
+}
+
+func (a apiKey) Authenticate(req *http.Request) error {
+	req.Header.Set(a.header, a.key)
+	return nil
+}
+
+// Secret returns the current value of a credential
+type Secret func() (string, error)
+
+// StaticSecret returns a secret that never changes
+func StaticSecret(value string) Secret {
+	return func() (string, error) { return value, nil }
+}
+
+// SecretFile returns a secret that is read from a file, such as a mounted Kubernetes
+// secret, every time it is needed so that a rotated secret is picked up without a restart
+func SecretFile(path string) Secret {
+	return func() (string, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+}
+
+// Credentials describe how to authenticate against a source system
+type Credentials struct {
+	// Authenticator adds credentials to each request, or nil to add none
+	Authenticator Authenticator
+	// TLS holds the client certificate for mutual TLS, or nil to present none
+	TLS *tls.Config
+}
+
+// Transport returns a round tripper that authenticates every request
+func (c Credentials) Transport() http.RoundTripper {
+	return NewTransport(TLSTransport(c.TLS), c.Authenticator)
+}
+
+// TLSTransport returns a transport that presents the client certificate of cfg, or the
+// default transport if cfg is nil
+func TLSTransport(cfg *tls.Config) http.RoundTripper {
+	if cfg == nil {
+		return http.DefaultTransport
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	return t
+}
+
+type transport struct {
+	base          http.RoundTripper
+	authenticator Authenticator
+}
+
+// NewTransport returns a round tripper that authenticates every request before passing it
+// on to base. A request that is rejected with 401 Unauthorized is sent once more with new
+// credentials, if the authenticator can replace them.
+func NewTransport(base http.RoundTripper, a Authenticator) http.RoundTripper {
+	if a == nil {
+		a = None
+	}
+	return &transport{base: base, authenticator: a}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, sent, err := t.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, ok := t.authenticator.(invalidator)
+	if !ok || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+
+	inv.Invalidate(sent)
+	resp.Body.Close()
+
+	if req.GetBody != nil {
+		req = req.Clone(req.Context())
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, _, err = t.send(req)
+	return resp, err
+}
+
+// send authenticates a copy of the request, since a round tripper must not modify the
+// request it was given, and returns the response along with the request that was sent
+func (t *transport) send(req *http.Request) (*http.Response, *http.Request, error) {
+	authenticated := req.Clone(req.Context())
+
+	if err := t.authenticator.Authenticate(authenticated); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, nil, err
+	}
+
+	resp, err := t.base.RoundTrip(authenticated)
+	return resp, authenticated, err
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/auth/authtest"
+	"github.com/matryer/is"
+)
+
+func TestThatAnAPIKeyIsSentWithoutModifyingTheRequest(t *testing.T) {
+	is := is.New(t)
+
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("apikey")
+	}))
+	defer server.Close()
+
+	c := http.Client{Transport: Credentials{Authenticator: APIKey("apikey", "s3cr3t")}.Transport()}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := c.Do(req)
+	is.NoErr(err)
+	resp.Body.Close()
+
+	is.Equal(received, "s3cr3t")
+	is.Equal(req.Header.Get("apikey"), "")
+}
+
+func TestThatAccessTokensAreCachedUntilShortlyBeforeTheyExpire(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	ts := authtest.NewTokenServer("client", "secret", time.Hour)
+	defer ts.Close()
+
+	now := time.Now()
+	cc := NewClientCredentials(ts.TokenURL(), "client", StaticSecret("secret"), WithScopes("facilities", "read"))
+	cc.now = func() time.Time { return now }
+
+	first, err := cc.Token(ctx)
+	is.NoErr(err)
+	second, err := cc.Token(ctx)
+	is.NoErr(err)
+
+	is.Equal(first, second)
+	is.Equal(ts.Issued(), 1)
+	is.Equal(ts.Scope(), "facilities read")
+
+	now = now.Add(time.Hour - DefaultRefreshBefore)
+
+	third, err := cc.Token(ctx)
+	is.NoErr(err)
+	is.True(third != first)
+	is.Equal(ts.Issued(), 2)
+}
+
+func TestThatARejectedTokenIsReplaced(t *testing.T) {
+	is := is.New(t)
+
+	ts := authtest.NewTokenServer("client", "secret", time.Hour)
+	defer ts.Close()
+
+	source := httptest.NewServer(ts.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer source.Close()
+
+	cc := NewClientCredentials(ts.TokenURL(), "client", StaticSecret("secret"))
+	c := http.Client{Transport: Credentials{Authenticator: cc}.Transport()}
+
+	get := func() int {
+		resp, err := c.Get(source.URL)
+		is.NoErr(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	is.Equal(get(), http.StatusOK)
+
+	ts.RevokeAll()
+
+	is.Equal(get(), http.StatusOK)
+	is.Equal(ts.Issued(), 2)
+}
+
+func TestThatTheClientSecretIsReadFromItsFileForEveryToken(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	ts := authtest.NewTokenServer("client", "rotated", time.Hour)
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "secret")
+	is.NoErr(os.WriteFile(path, []byte("expired\n"), 0600))
+
+	cc := NewClientCredentials(ts.TokenURL(), "client", SecretFile(path))
+
+	_, err := cc.Token(ctx)
+	is.True(err != nil)
+
+	is.NoErr(os.WriteFile(path, []byte("rotated\n"), 0600))
+
+	_, err = cc.Token(ctx)
+	is.NoErr(err)
+}
+
+func TestThatAClientCertificateIsPresented(t *testing.T) {
+	is := is.New(t)
+
+	var presented []*x509.Certificate
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	writeClientCertificate(t, certFile, keyFile)
+	is.NoErr(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	cfg, err := ClientCertificate(certFile, keyFile, caFile)
+	is.NoErr(err)
+
+	c := http.Client{Transport: Credentials{TLS: cfg}.Transport()}
+
+	resp, err := c.Get(server.URL)
+	is.NoErr(err)
+	resp.Body.Close()
+
+	is.Equal(len(presented), 1)
+	is.Equal(presented[0].Subject.CommonName, "integration-cip-sdl")
+
+	_, err = ClientCertificate(filepath.Join(dir, "missing.crt"), keyFile, "")
+	is.True(err != nil)
+}
+
+func writeClientCertificate(t *testing.T, certFile, keyFile string) {
+	is := is.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.NoErr(err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "integration-cip-sdl"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	is.NoErr(err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	is.NoErr(err)
+
+	is.NoErr(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	is.NoErr(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
@@ -0,0 +1,133 @@
+// Package authtest provides a stand-in for an OAuth2 token endpoint, such as the one of
+// the municipal API gateway, for testing clients that use the client credentials grant.
+package authtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenServer issues access tokens at /token to a single client, and can protect a handler
+// so that it only accepts requests with a token that it has issued and that has not expired
+type TokenServer struct {
+	*httptest.Server
+
+	clientID     string
+	clientSecret string
+	expiresIn    time.Duration
+
+	tokens map[string]time.Time
+	issued int
+	scope  string
+	mu     sync.Mutex
+}
+
+// NewTokenServer starts a token server that issues tokens with the given lifetime. It
+// should be closed when the test is done.
+func NewTokenServer(clientID, clientSecret string, expiresIn time.Duration) *TokenServer {
+	s := &TokenServer{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		expiresIn:    expiresIn,
+		tokens:       map[string]time.Time{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", s.token)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// TokenURL returns the url of the token endpoint
+func (s *TokenServer) TokenURL() string {
+	return s.URL + "/token"
+}
+
+// Issued returns the number of tokens that have been issued
+func (s *TokenServer) Issued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// Scope returns the scope that was requested with the last token
+func (s *TokenServer) Scope() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scope
+}
+
+// RevokeAll makes every token that has been issued so far invalid
+func (s *TokenServer) RevokeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.tokens)
+}
+
+// Protect responds with 401 Unauthorized to requests without a valid bearer token
+func (s *TokenServer) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		s.mu.Lock()
+		expiry, ok := s.tokens[token]
+		s.mu.Unlock()
+
+		if !found || !ok || time.Now().After(expiry) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *TokenServer) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		// the credentials are form encoded before they are put in the header
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.PostFormValue("grant_type") != "client_credentials" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"unsupported_grant_type"}`))
+		return
+	}
+
+	if clientID != s.clientID || clientSecret != s.clientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid_client"}`))
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	s.tokens[token] = time.Now().Add(s.expiresIn)
+	s.issued++
+	s.scope = r.PostFormValue("scope")
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(s.expiresIn.Seconds()),
+	})
+}
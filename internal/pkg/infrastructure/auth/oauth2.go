@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// DefaultRefreshBefore is how long before it expires that an access token is replaced
+const DefaultRefreshBefore time.Duration = time.Minute
+
+// ClientCredentials authenticates requests with an OAuth2 access token that is obtained
+// through the client credentials grant. The token is cached, and replaced shortly before
+// it expires or as soon as the server rejects it.
+type ClientCredentials struct {
+	tokenURL      string
+	clientID      string
+	clientSecret  Secret
+	scopes        []string
+	refreshBefore time.Duration
+	httpClient    *http.Client
+
+	token  string
+	expiry time.Time
+	now    func() time.Time
+	mu     sync.Mutex
+}
+
+// WithScopes sets the scopes that are requested for the access token
+func WithScopes(scopes ...string) func(*ClientCredentials) {
+	return func(c *ClientCredentials) {
+		c.scopes = scopes
+	}
+}
+
+// WithTokenTransport sets the transport that requests tokens, e.g. to present the same
+// client certificate to the token endpoint as to the source
+func WithTokenTransport(rt http.RoundTripper) func(*ClientCredentials) {
+	return func(c *ClientCredentials) {
+		c.httpClient = &http.Client{Transport: otelhttp.NewTransport(rt), Timeout: c.httpClient.Timeout}
+	}
+}
+
+// WithRefreshBefore sets how long before it expires that an access token is replaced
+func WithRefreshBefore(d time.Duration) func(*ClientCredentials) {
+	return func(c *ClientCredentials) {
+		c.refreshBefore = d
+	}
+}
+
+// NewClientCredentials returns an authenticator that obtains access tokens from tokenURL
+func NewClientCredentials(tokenURL, clientID string, clientSecret Secret, options ...func(*ClientCredentials)) *ClientCredentials {
+	c := &ClientCredentials{
+		tokenURL:      tokenURL,
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		refreshBefore: DefaultRefreshBefore,
+		httpClient: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   30 * time.Second,
+		},
+		now: time.Now,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+func (c *ClientCredentials) Authenticate(req *http.Request) error {
+	token, err := c.Token(req.Context())
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Invalidate drops the cached token if it is the one that the request was sent with, so
+// that the next request obtains a new one
+func (c *ClientCredentials) Invalidate(req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.Header.Get("Authorization") == "Bearer "+c.token {
+		c.token = ""
+	}
+}
+
+// Token returns the cached access token, or obtains a new one if there is none or it is
+// about to expire. Concurrent callers wait for the same token request.
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && (c.expiry.IsZero() || c.now().Before(c.expiry)) {
+		return c.token, nil
+	}
+
+	token, lifetime, err := c.requestToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain an access token from %s: %w", c.tokenURL, err)
+	}
+
+	c.token = token
+	c.expiry = time.Time{}
+
+	// a token without a lifetime is used until the server rejects it
+	if lifetime > 0 {
+		c.expiry = c.now().Add(lifetime - min(c.refreshBefore, lifetime/2))
+	}
+
+	logging.GetFromContext(ctx).Debug("obtained a new access token", "expires_in", lifetime.String())
+
+	return c.token, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (c *ClientCredentials) requestToken(ctx context.Context) (string, time.Duration, error) {
+	secret, err := c.clientSecret()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read the client secret: %w", err)
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.scopes) > 0 {
+		form.Set("scope", strings.Join(c.scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(secret))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("unexpected status code %d (%s)", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	tr := tokenResponse{}
+	if err = json.Unmarshal(body, &tr); err != nil {
+		return "", 0, err
+	}
+
+	if tr.AccessToken == "" {
+		return "", 0, errors.New("the response did not contain an access token")
+	}
+
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type %q", tr.TokenType)
+	}
+
+	return tr.AccessToken, time.Duration(tr.ExpiresIn) * time.Second, nil
+}
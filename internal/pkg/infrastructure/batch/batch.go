@@ -0,0 +1,258 @@
+// Package batch writes entities to the context broker in batches through the NGSI-LD
+// entityOperations endpoints, which saves a round trip per entity compared to merging
+// and then creating each entity on its own.
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("context-broker-batch-client")
+
+// Result is the outcome of a batch operation for each of the entities in the batch
+type Result struct {
+	// Created and Updated are known when the broker reports the same outcome for the whole batch
+	Created []string
+	Updated []string
+	// Succeeded holds the entities of a partially successful batch, for which the broker
+	// does not tell whether they were created or updated
+	Succeeded []string
+	// Errors holds the error of every entity that failed, as an ngsi-ld error if possible
+	Errors map[string]error
+}
+
+// Writer is implemented by broker clients that can write many entities in a single request,
+// such as the Client of this package and the clients that wrap it
+type Writer interface {
+	BatchSize() int
+	UpsertEntities(ctx context.Context, entities []types.Entity, headers map[string][]string) (Result, error)
+	DeleteEntities(ctx context.Context, entityIDs []string) (Result, error)
+}
+
+// Client is a context broker client that can also write entities in batches
+type Client struct {
+	client.ContextBrokerClient
+
+	baseURL    string
+	tenant     string
+	size       int
+	httpClient http.Client
+	wait       func(context.Context) error
+	guard      func(context.Context, func() error) error
+}
+
+// Size sets the maximum number of entities that are sent in a single request
+func Size(size int) func(*Client) {
+	return func(c *Client) {
+		c.size = size
+	}
+}
+
+// Tenant sets the tenant that entities are written to
+func Tenant(tenant string) func(*Client) {
+	return func(c *Client) {
+		c.tenant = tenant
+	}
+}
+
+// Transport replaces the round tripper that is used to send batches
+func Transport(transport http.RoundTripper) func(*Client) {
+	return func(c *Client) {
+		c.httpClient.Transport = transport
+	}
+}
+
+// WaitFor makes the client call wait before every request, e.g. to respect a rate limit
+func WaitFor(wait func(context.Context) error) func(*Client) {
+	return func(c *Client) {
+		c.wait = wait
+	}
+}
+
+// Guard makes every request go through guard, e.g. to let a circuit breaker see its outcome
+func Guard(guard func(ctx context.Context, request func() error) error) func(*Client) {
+	return func(c *Client) {
+		c.guard = guard
+	}
+}
+
+// DefaultSize is the number of entities per batch unless Size says otherwise
+const DefaultSize int = 100
+
+// NewClient returns a client that sends batches to the broker at baseURL, and passes all
+// other requests on to the supplied broker client
+func NewClient(broker client.ContextBrokerClient, baseURL string, options ...func(*Client)) *Client {
+	c := &Client{
+		ContextBrokerClient: broker,
+		baseURL:             strings.TrimSuffix(baseURL, "/"),
+		tenant:              entities.DefaultNGSITenant,
+		size:                DefaultSize,
+		httpClient: http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+		wait:  func(context.Context) error { return nil },
+		guard: func(_ context.Context, request func() error) error { return request() },
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+var _ Writer = &Client{}
+
+// BatchSize returns the maximum number of entities that are sent in a single request
+func (c *Client) BatchSize() int {
+	return c.size
+}
+
+// UpsertEntities creates the entities that do not exist and updates the attributes of those
+// that do, leaving any other attributes of existing entities untouched. The entities are sent
+// as application/ld+json unless the headers, like those of a single entity write, say otherwise.
+func (c *Client) UpsertEntities(ctx context.Context, batch []types.Entity, headers map[string][]string) (result Result, err error) {
+	ctx, span := tracer.Start(ctx, "upsert-entities", trace.WithAttributes(attribute.Int("batch-size", len(batch))))
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	ids := make([]string, 0, len(batch))
+	for _, e := range batch {
+		ids = append(ids, e.ID())
+	}
+
+	if len(headers["Content-Type"]) == 0 {
+		headers = maps.Clone(headers)
+		if headers == nil {
+			headers = map[string][]string{}
+		}
+		headers["Content-Type"] = []string{"application/ld+json"}
+	}
+
+	return c.post(ctx, "/ngsi-ld/v1/entityOperations/upsert?options=update", headers, body, ids)
+}
+
+// DeleteEntities deletes the entities with the given ids
+func (c *Client) DeleteEntities(ctx context.Context, entityIDs []string) (result Result, err error) {
+	ctx, span := tracer.Start(ctx, "delete-entities", trace.WithAttributes(attribute.Int("batch-size", len(entityIDs))))
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	body, err := json.Marshal(entityIDs)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	return c.post(ctx, "/ngsi-ld/v1/entityOperations/delete", map[string][]string{"Content-Type": {"application/json"}}, body, entityIDs)
+}
+
+func (c *Client) post(ctx context.Context, path string, headers map[string][]string, body []byte, ids []string) (result Result, err error) {
+	err = c.guard(ctx, func() error {
+		if err := c.wait(ctx); err != nil {
+			return err
+		}
+
+		result, err = c.send(ctx, path, headers, body, ids)
+		return err
+	})
+
+	return result, err
+}
+
+func (c *Client) send(ctx context.Context, path string, headers map[string][]string, body []byte, ids []string) (Result, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to create request: %s (%w)", err.Error(), ngsierrors.ErrInternal)
+	}
+
+	for name, values := range headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if c.tenant != entities.DefaultNGSITenant {
+		req.Header.Set("NGSILD-Tenant", c.tenant)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to send request: %s (%w)", err.Error(), ngsierrors.ErrRequest)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read response body: %s (%w)", err.Error(), ngsierrors.ErrBadResponse)
+	}
+
+	return parseResponse(resp.StatusCode, resp.Header.Get("Content-Type"), respBody, ids)
+}
+
+// parseResponse interprets the response to a batch operation. A 201 means that every entity
+// was created and a 204 that every entity was updated or deleted. A 207 carries the outcome
+// for each entity.
+func parseResponse(statusCode int, contentType string, body []byte, ids []string) (Result, error) {
+	switch statusCode {
+	case http.StatusCreated:
+		return Result{Created: ids, Errors: map[string]error{}}, nil
+	case http.StatusNoContent, http.StatusOK:
+		return Result{Updated: ids, Errors: map[string]error{}}, nil
+	case http.StatusMultiStatus:
+		return parseMultiStatus(body)
+	}
+
+	if statusCode >= http.StatusBadRequest {
+		return Result{}, ngsierrors.NewErrorFromProblemReport(statusCode, contentType, body)
+	}
+
+	return Result{}, fmt.Errorf("unexpected response code %d (%w)", statusCode, ngsierrors.ErrBadResponse)
+}
+
+func parseMultiStatus(body []byte) (Result, error) {
+	response := struct {
+		Success []string `json:"success"`
+		Errors  []struct {
+			EntityID string          `json:"entityId"`
+			Error    json.RawMessage `json:"error"`
+		} `json:"errors"`
+	}{}
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Result{}, fmt.Errorf("failed to parse batch operation result: %s (%w)", err.Error(), ngsierrors.ErrBadResponse)
+	}
+
+	result := Result{Succeeded: response.Success, Errors: map[string]error{}}
+
+	for _, e := range response.Errors {
+		problem := struct {
+			Status int `json:"status"`
+		}{}
+		json.Unmarshal(e.Error, &problem)
+
+		// the problem details are mapped to the same errors as those of single entity requests
+		result.Errors[e.EntityID] = ngsierrors.NewErrorFromProblemReport(problem.Status, "application/json", e.Error)
+	}
+
+	return result, nil
+}
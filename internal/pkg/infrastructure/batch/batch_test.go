@@ -0,0 +1,97 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
+	"github.com/matryer/is"
+)
+
+func TestThatEntitiesAreUpsertedInASingleRequest(t *testing.T) {
+	is := is.New(t)
+
+	var received []map[string]any
+	var path, tenant, contentType string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.RequestURI()
+		tenant = r.Header.Get("NGSILD-Tenant")
+		contentType = r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	c := NewClient(nil, server.URL, Tenant("sundsvall"))
+
+	result, err := c.UpsertEntities(context.Background(), []types.Entity{
+		newEntity(t, "urn:ngsi-ld:Beach:1"), newEntity(t, "urn:ngsi-ld:Beach:2"),
+	}, nil)
+
+	is.NoErr(err)
+	is.Equal(path, "/ngsi-ld/v1/entityOperations/upsert?options=update")
+	is.Equal(tenant, "sundsvall")
+	is.Equal(contentType, "application/ld+json")
+	is.Equal(len(received), 2)
+	is.Equal(received[1]["name"].(map[string]any)["value"], "Stranden")
+	is.Equal(result.Created, []string{"urn:ngsi-ld:Beach:1", "urn:ngsi-ld:Beach:2"})
+}
+
+func TestThatFailuresAreReportedPerEntity(t *testing.T) {
+	is := is.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(multiStatus))
+	}))
+	defer server.Close()
+
+	c := NewClient(nil, server.URL)
+
+	result, err := c.DeleteEntities(context.Background(), []string{"urn:ngsi-ld:Beach:1", "urn:ngsi-ld:Beach:2", "urn:ngsi-ld:Beach:3"})
+
+	is.NoErr(err)
+	is.Equal(result.Succeeded, []string{"urn:ngsi-ld:Beach:1"})
+	is.True(errors.Is(result.Errors["urn:ngsi-ld:Beach:2"], ngsierrors.ErrNotFound))
+	is.True(errors.Is(result.Errors["urn:ngsi-ld:Beach:3"], ngsierrors.ErrBadRequest))
+}
+
+func TestThatAFailedBatchIsAnError(t *testing.T) {
+	is := is.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"type":"https://uri.etsi.org/ngsi-ld/errors/InternalError","title":"oops"}`))
+	}))
+	defer server.Close()
+
+	_, err := NewClient(nil, server.URL).DeleteEntities(context.Background(), []string{"urn:ngsi-ld:Beach:1"})
+	is.True(err != nil)
+}
+
+func newEntity(t *testing.T, id string) types.Entity {
+	e, err := entities.New(id, "Beach", decorators.Name("Stranden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+const multiStatus string = `{
+	"success": ["urn:ngsi-ld:Beach:1"],
+	"errors": [
+		{"entityId": "urn:ngsi-ld:Beach:2", "error": {"type": "https://uri.etsi.org/ngsi-ld/errors/ResourceNotFound", "title": "Entity not found", "status": 404}},
+		{"entityId": "urn:ngsi-ld:Beach:3", "error": {"type": "https://uri.etsi.org/ngsi-ld/errors/BadRequestData", "title": "Invalid id", "status": 400}}
+	]
+}`
@@ -0,0 +1,296 @@
+// Package broker is a client for the NGSI-LD api of the context broker whose requests are
+// sent through a round tripper of its own. Authentication and rate limiting are added to the
+// requests to the broker only, rather than to every http client in the process.
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("context-broker-client")
+
+// Client sends single entity requests to the context broker. It answers like the client of
+// the context broker package, so that the two can be used interchangeably.
+type Client struct {
+	baseURL    string
+	tenant     string
+	debug      bool
+	httpClient http.Client
+}
+
+// Tenant sets the tenant that entities are written to and read from
+func Tenant(tenant string) func(*Client) {
+	return func(c *Client) {
+		c.tenant = tenant
+	}
+}
+
+// Transport replaces the round tripper that requests are sent through
+func Transport(transport http.RoundTripper) func(*Client) {
+	return func(c *Client) {
+		c.httpClient.Transport = transport
+	}
+}
+
+// Debug makes the client log the requests that the broker rejects
+func Debug(enabled bool) func(*Client) {
+	return func(c *Client) {
+		c.debug = enabled
+	}
+}
+
+// NewClient returns a client for the context broker at baseURL
+func NewClient(baseURL string, options ...func(*Client)) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		tenant:  entities.DefaultNGSITenant,
+		httpClient: http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+var _ client.ContextBrokerClient = &Client{}
+
+func (c *Client) CreateEntity(ctx context.Context, entity types.Entity, headers map[string][]string) (result *ngsild.CreateEntityResult, err error) {
+	ctx, span := c.startSpan(ctx, "create-entity", entity.ID())
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	body, err := entity.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, respBody, err := c.do(ctx, http.MethodPost, "/ngsi-ld/v1/entities", body, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, statusError(resp, respBody)
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		location = "/ngsi-ld/v1/entities/" + url.QueryEscape(entity.ID())
+	}
+
+	return ngsild.NewCreateEntityResult(location), nil
+}
+
+func (c *Client) RetrieveEntity(ctx context.Context, entityID string, headers map[string][]string) (e types.Entity, err error) {
+	ctx, span := c.startSpan(ctx, "retrieve-entity", entityID)
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	resp, respBody, err := c.do(ctx, http.MethodGet, "/ngsi-ld/v1/entities/"+url.QueryEscape(entityID), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp, respBody)
+	}
+
+	return entities.NewFromJSON(respBody)
+}
+
+func (c *Client) MergeEntity(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (result *ngsild.MergeEntityResult, err error) {
+	ctx, span := c.startSpan(ctx, "merge-entity", entityID)
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	body, err := fragment.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, respBody, err := c.do(ctx, http.MethodPatch, "/ngsi-ld/v1/entities/"+url.QueryEscape(entityID), body, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusMultiStatus {
+		return nil, statusError(resp, respBody)
+	}
+
+	return ngsild.NewMergeEntityResult(respBody)
+}
+
+func (c *Client) UpdateEntityAttributes(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (result *ngsild.UpdateEntityAttributesResult, err error) {
+	ctx, span := c.startSpan(ctx, "update-entity-attributes", entityID)
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	body, err := fragment.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, respBody, err := c.do(ctx, http.MethodPatch, "/ngsi-ld/v1/entities/"+url.QueryEscape(entityID)+"/attrs/", body, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusMultiStatus {
+		return nil, statusError(resp, respBody)
+	}
+
+	return ngsild.NewUpdateEntityAttributesResult(respBody)
+}
+
+// QueryEntities sends the query as it is given, and ignores the entity types and attributes
+// just like the client of the context broker package does
+func (c *Client) QueryEntities(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (result *ngsild.QueryEntitiesResult, err error) {
+	ctx, span := c.startSpan(ctx, "query-entities", "")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	params, err := url.ParseQuery(query[strings.Index(query, "?")+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid query parameter (%w)", ngsierrors.ErrBadRequest)
+	}
+
+	resp, respBody, err := c.do(ctx, http.MethodGet, "/ngsi-ld/v1/entities?"+params.Encode(), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp, respBody)
+	}
+
+	found := []entities.EntityImpl{}
+	if err = json.Unmarshal(respBody, &found); err != nil {
+		return nil, fmt.Errorf("failed to parse query result: %s (%w)", err.Error(), ngsierrors.ErrBadResponse)
+	}
+
+	result = ngsild.NewQueryEntitiesResult()
+	result.Count = len(found)
+
+	if count, err := strconv.ParseInt(resp.Header.Get("NGSILD-Results-Count"), 10, 64); err == nil {
+		result.TotalCount = count
+	}
+
+	result.Offset, _ = strconv.Atoi(params.Get("offset"))
+	result.Limit, _ = strconv.Atoi(params.Get("limit"))
+	result.PartialResult = result.Count == result.Limit || result.Offset != 0
+
+	go func() {
+		for i := range found {
+			result.Found <- found[i]
+		}
+		result.Found <- nil
+	}()
+
+	return result, nil
+}
+
+func (c *Client) DeleteEntity(ctx context.Context, entityID string) (result *ngsild.DeleteEntityResult, err error) {
+	ctx, span := c.startSpan(ctx, "delete-entity", entityID)
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	resp, respBody, err := c.do(ctx, http.MethodDelete, "/ngsi-ld/v1/entities/"+url.QueryEscape(entityID), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		return nil, statusError(resp, respBody)
+	}
+
+	return ngsild.NewDeleteEntityResult(), nil
+}
+
+// QueryTemporalEvolutionOfEntities is not used by the integrations
+func (c *Client) QueryTemporalEvolutionOfEntities(ctx context.Context, headers map[string][]string, parameters ...client.RequestDecoratorFunc) (*ngsild.QueryTemporalEntitiesResult, error) {
+	return nil, fmt.Errorf("temporal queries are not supported (%w)", ngsierrors.ErrInternal)
+}
+
+// RetrieveTemporalEvolutionOfEntity is not used by the integrations
+func (c *Client) RetrieveTemporalEvolutionOfEntity(ctx context.Context, entityID string, headers map[string][]string, parameters ...client.RequestDecoratorFunc) (*ngsild.RetrieveTemporalEvolutionOfEntityResult, error) {
+	return nil, fmt.Errorf("temporal queries are not supported (%w)", ngsierrors.ErrInternal)
+}
+
+func (c *Client) startSpan(ctx context.Context, name, entityID string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{attribute.String(client.TraceAttributeNGSILDTenant, c.tenant)}
+	if entityID != "" {
+		attributes = append(attributes, attribute.String(client.TraceAttributeEntityID, entityID))
+	}
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// do sends a request to the broker and returns the response along with its body
+func (c *Client) do(ctx context.Context, method, path string, body []byte, headers map[string][]string) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %s (%w)", err.Error(), ngsierrors.ErrInternal)
+	}
+
+	for name, values := range headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if c.tenant != entities.DefaultNGSITenant {
+		req.Header.Set("NGSILD-Tenant", c.tenant)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send request: %s (%w)", err.Error(), ngsierrors.ErrRequest)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response body: %s (%w)", err.Error(), ngsierrors.ErrBadResponse)
+	}
+
+	if c.debug && resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusNotFound {
+		reqDump, _ := httputil.DumpRequest(req, false)
+		respDump, _ := httputil.DumpResponse(resp, false)
+		logging.GetFromContext(ctx).Error("request failed", "request", string(reqDump), "response", string(respDump))
+	}
+
+	return resp, respBody, nil
+}
+
+// statusError maps an unexpected response to the same errors as the client of the context
+// broker package, so that callers can tell a missing entity from a failed request
+func statusError(resp *http.Response, body []byte) error {
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode <= http.StatusInternalServerError {
+		return ngsierrors.NewErrorFromProblemReport(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+
+	return fmt.Errorf("unexpected response code %d (%w)", resp.StatusCode, ngsierrors.ErrBadResponse)
+}
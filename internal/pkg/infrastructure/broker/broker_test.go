@@ -0,0 +1,104 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
+	"github.com/matryer/is"
+)
+
+func TestThatRequestsAreSentThroughTheTransportOfTheClient(t *testing.T) {
+	is := is.New(t)
+
+	var method, path, tenant, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.RequestURI()
+		tenant = r.Header.Get("NGSILD-Tenant")
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	defaultTransport := http.DefaultTransport
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req.Header.Set("Authorization", "Bearer token")
+		return defaultTransport.RoundTrip(req)
+	})
+
+	c := NewClient(server.URL, Tenant("sundsvall"), Transport(transport))
+
+	e, err := entities.New("urn:ngsi-ld:Beach:1", "Beach", decorators.Name("Stranden"))
+	is.NoErr(err)
+
+	result, err := c.CreateEntity(context.Background(), e, nil)
+
+	is.NoErr(err)
+	is.Equal(result.Location(), "/ngsi-ld/v1/entities/urn%3Angsi-ld%3ABeach%3A1")
+	is.Equal(method, http.MethodPost)
+	is.Equal(path, "/ngsi-ld/v1/entities")
+	is.Equal(tenant, "sundsvall")
+	is.Equal(authorization, "Bearer token")
+	// the transport of other clients in the process is left alone
+	is.True(http.DefaultTransport == defaultTransport)
+}
+
+func TestThatAMissingEntityIsNotFound(t *testing.T) {
+	is := is.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"type":"https://uri.etsi.org/ngsi-ld/errors/ResourceNotFound","title":"not found"}`))
+	}))
+	defer server.Close()
+
+	fragment, err := entities.NewFragment(decorators.Name("Stranden"))
+	is.NoErr(err)
+
+	_, err = NewClient(server.URL).MergeEntity(context.Background(), "urn:ngsi-ld:Beach:1", fragment, nil)
+	is.True(errors.Is(err, ngsierrors.ErrNotFound))
+
+	_, err = NewClient(server.URL).DeleteEntity(context.Background(), "urn:ngsi-ld:Beach:1")
+	is.True(errors.Is(err, ngsierrors.ErrNotFound))
+}
+
+func TestThatQueriedEntitiesAreFound(t *testing.T) {
+	is := is.New(t)
+
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Header().Set("NGSILD-Results-Count", "3")
+		io.WriteString(w, `[{"@context":["`+entities.DefaultContextURL+`"],"id":"urn:ngsi-ld:Beach:1","type":"Beach"},{"@context":["`+entities.DefaultContextURL+`"],"id":"urn:ngsi-ld:Beach:2","type":"Beach"}]`)
+	}))
+	defer server.Close()
+
+	result, err := NewClient(server.URL).QueryEntities(context.Background(), nil, nil, "?type=Beach&limit=2&offset=0", nil)
+	is.NoErr(err)
+
+	ids := []string{}
+	for e := range result.Found {
+		if e == nil {
+			break
+		}
+		ids = append(ids, e.ID())
+	}
+
+	is.Equal(query, "limit=2&offset=0&type=Beach")
+	is.Equal(ids, []string{"urn:ngsi-ld:Beach:1", "urn:ngsi-ld:Beach:2"})
+	is.Equal(result.TotalCount, int64(3))
+	is.True(result.PartialResult)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
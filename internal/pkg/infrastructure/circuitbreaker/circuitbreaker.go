@@ -0,0 +1,168 @@
+// Package circuitbreaker stops requests from being sent to the context broker once it
+// has failed a number of times in a row, so that a run fails fast instead of working
+// its way through every entity against a broker that is unavailable.
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+)
+
+// ErrOpen is returned instead of sending a request while the circuit is open
+var ErrOpen error = errors.New("circuit breaker is open")
+
+type State string
+
+const (
+	// Closed lets all requests through
+	Closed State = "closed"
+	// Open rejects all requests until the cooldown has passed
+	Open State = "open"
+	// HalfOpen lets a single trial request through to find out if the broker has recovered
+	HalfOpen State = "half-open"
+)
+
+// Status is a snapshot of the state of a breaker
+type Status struct {
+	State               State     `json:"state"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	OpenedAt            time.Time `json:"openedAt,omitzero"`
+	RetryAt             time.Time `json:"retryAt,omitzero"`
+	LastError           string    `json:"lastError,omitempty"`
+}
+
+// Breaker opens after a number of consecutive failures and stays open for a cooldown
+// period, after which a single trial request decides whether to close it again
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	state     State
+	failures  int
+	openedAt  time.Time
+	trial     bool
+	lastError string
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New returns a breaker that opens after threshold consecutive failures and half-opens
+// after cooldown. A threshold of zero or less disables the breaker.
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     Closed,
+		now:       time.Now,
+	}
+}
+
+// Do calls fn unless the circuit is open, and records the outcome
+func (b *Breaker) Do(ctx context.Context, fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := fn()
+	b.record(ctx, err)
+
+	return err
+}
+
+// Status returns the current state of the breaker
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := Status{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+
+	if b.state != Closed {
+		status.OpenedAt = b.openedAt.UTC()
+		status.RetryAt = b.openedAt.Add(b.cooldown).UTC()
+	}
+
+	return status
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.state == Closed {
+		return nil
+	}
+
+	retryAt := b.openedAt.Add(b.cooldown)
+
+	if b.state == Open && !b.now().Before(retryAt) {
+		b.state = HalfOpen
+	}
+
+	// only one request at a time gets to find out if the broker has recovered
+	if b.state == HalfOpen && !b.trial {
+		b.trial = true
+		return nil
+	}
+
+	return fmt.Errorf("%w after %d consecutive failures, retrying at %s (last error: %s)",
+		ErrOpen, b.failures, retryAt.UTC().Format(time.RFC3339), b.lastError)
+}
+
+func (b *Breaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.trial = false
+
+	// a request that was cancelled says nothing about the broker
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	if !isFailure(err) {
+		b.state = Closed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		b.state = Open
+		b.openedAt = b.now()
+	}
+}
+
+// isFailure tells errors that suggest that the broker is unavailable from errors that are
+// caused by the request itself
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, target := range []error{
+		ngsierrors.ErrNotFound, ngsierrors.ErrAlreadyExists, ngsierrors.ErrBadRequest,
+		ngsierrors.ErrInvalidRequest, ngsierrors.ErrUnknownTenant,
+	} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,98 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/matryer/is"
+)
+
+var errUnavailable error = errors.New("[code: 503] service unavailable")
+
+func TestThatTheBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	b := New(3, time.Minute)
+	calls := 0
+	fail := func() error { calls++; return errUnavailable }
+
+	for range 3 {
+		is.Equal(b.Do(ctx, fail), errUnavailable)
+	}
+
+	err := b.Do(ctx, fail)
+	is.True(errors.Is(err, ErrOpen))
+	is.Equal(calls, 3)
+
+	status := b.Status()
+	is.Equal(status.State, Open)
+	is.Equal(status.ConsecutiveFailures, 3)
+	is.Equal(status.RetryAt, status.OpenedAt.Add(time.Minute))
+}
+
+func TestThatASuccessfulTrialClosesTheBreaker(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	b := New(1, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Do(ctx, func() error { return errUnavailable })
+	is.Equal(b.Status().State, Open)
+
+	now = now.Add(time.Minute)
+
+	is.NoErr(b.Do(ctx, func() error { return nil }))
+	is.Equal(b.Status(), Status{State: Closed, LastError: errUnavailable.Error()})
+}
+
+func TestThatAFailedTrialReopensTheBreaker(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	b := New(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Do(ctx, func() error { return errUnavailable })
+	b.Do(ctx, func() error { return errUnavailable })
+
+	now = now.Add(time.Minute)
+
+	b.Do(ctx, func() error { return errUnavailable })
+	is.Equal(b.Status().State, Open)
+	is.Equal(b.Status().OpenedAt, now.UTC())
+	is.True(errors.Is(b.Do(ctx, func() error { return nil }), ErrOpen))
+}
+
+func TestThatRequestErrorsDoNotOpenTheBreaker(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	b := New(1, time.Minute)
+
+	b.Do(ctx, func() error { return ngsierrors.ErrNotFound })
+	b.Do(ctx, func() error { return ngsierrors.NewBadRequestDataError("invalid") })
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	b.Do(cancelled, func() error { return cancelled.Err() })
+
+	is.Equal(b.Status().State, Closed)
+}
+
+func TestThatAThresholdOfZeroDisablesTheBreaker(t *testing.T) {
+	is := is.New(t)
+
+	b := New(0, time.Minute)
+	for range 10 {
+		is.Equal(b.Do(context.Background(), func() error { return errUnavailable }), errUnavailable)
+	}
+
+	is.Equal(b.Status().State, Closed)
+}
@@ -0,0 +1,76 @@
+package circuitbreaker
+
+import (
+	"context"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+)
+
+type breakerClient struct {
+	broker  client.ContextBrokerClient
+	breaker *Breaker
+}
+
+// NewClient returns a context broker client that sends its requests through the breaker
+func NewClient(broker client.ContextBrokerClient, breaker *Breaker) client.ContextBrokerClient {
+	return &breakerClient{broker: broker, breaker: breaker}
+}
+
+func call[T any](ctx context.Context, b *Breaker, fn func() (T, error)) (T, error) {
+	var result T
+	err := b.Do(ctx, func() (err error) {
+		result, err = fn()
+		return err
+	})
+	return result, err
+}
+
+func (c *breakerClient) CreateEntity(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+	return call(ctx, c.breaker, func() (*ngsild.CreateEntityResult, error) {
+		return c.broker.CreateEntity(ctx, entity, headers)
+	})
+}
+
+func (c *breakerClient) QueryEntities(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+	return call(ctx, c.breaker, func() (*ngsild.QueryEntitiesResult, error) {
+		return c.broker.QueryEntities(ctx, entityTypes, entityAttributes, query, headers)
+	})
+}
+
+func (c *breakerClient) RetrieveEntity(ctx context.Context, entityID string, headers map[string][]string) (types.Entity, error) {
+	return call(ctx, c.breaker, func() (types.Entity, error) {
+		return c.broker.RetrieveEntity(ctx, entityID, headers)
+	})
+}
+
+func (c *breakerClient) QueryTemporalEvolutionOfEntities(ctx context.Context, headers map[string][]string, parameters ...client.RequestDecoratorFunc) (*ngsild.QueryTemporalEntitiesResult, error) {
+	return call(ctx, c.breaker, func() (*ngsild.QueryTemporalEntitiesResult, error) {
+		return c.broker.QueryTemporalEvolutionOfEntities(ctx, headers, parameters...)
+	})
+}
+
+func (c *breakerClient) RetrieveTemporalEvolutionOfEntity(ctx context.Context, entityID string, headers map[string][]string, parameters ...client.RequestDecoratorFunc) (*ngsild.RetrieveTemporalEvolutionOfEntityResult, error) {
+	return call(ctx, c.breaker, func() (*ngsild.RetrieveTemporalEvolutionOfEntityResult, error) {
+		return c.broker.RetrieveTemporalEvolutionOfEntity(ctx, entityID, headers, parameters...)
+	})
+}
+
+func (c *breakerClient) MergeEntity(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+	return call(ctx, c.breaker, func() (*ngsild.MergeEntityResult, error) {
+		return c.broker.MergeEntity(ctx, entityID, fragment, headers)
+	})
+}
+
+func (c *breakerClient) UpdateEntityAttributes(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.UpdateEntityAttributesResult, error) {
+	return call(ctx, c.breaker, func() (*ngsild.UpdateEntityAttributesResult, error) {
+		return c.broker.UpdateEntityAttributes(ctx, entityID, fragment, headers)
+	})
+}
+
+func (c *breakerClient) DeleteEntity(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
+	return call(ctx, c.breaker, func() (*ngsild.DeleteEntityResult, error) {
+		return c.broker.DeleteEntity(ctx, entityID)
+	})
+}
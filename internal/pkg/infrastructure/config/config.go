@@ -0,0 +1,345 @@
+// Package config reads the optional configuration file of the service. The file is
+// translated into the environment variables that would otherwise be used to configure
+// the service, so that every setting can still be overridden from the environment.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File describes the schema of the configuration file, which can be written in
+// either YAML or JSON. The key that each setting translates into is given in
+// the comment of the field. <NAME> is the upper case name of an integration.
+type File struct {
+	ContextBroker struct {
+		URL       string `yaml:"url"`       // CONTEXT_BROKER_URL
+		Tenant    string `yaml:"tenant"`    // CONTEXT_BROKER_TENANT
+		RateLimit string `yaml:"rateLimit"` // BROKER_RATE_LIMIT
+		Workers   string `yaml:"workers"`   // BROKER_WORKERS
+		BatchSize string `yaml:"batchSize"` // BROKER_BATCH_SIZE
+		Debug     *bool  `yaml:"debug"`     // BROKER_DEBUG
+
+		// Auth decides how requests to the broker are authenticated
+		Auth Auth `yaml:"auth"`
+
+		// JSONLDContext is the context of entities whose integration does not say otherwise
+		JSONLDContext JSONLDContext `yaml:"jsonldContext"`
+
+		CircuitBreaker struct {
+			Threshold string `yaml:"threshold"` // BROKER_BREAKER_THRESHOLD
+			Cooldown  string `yaml:"cooldown"`  // BROKER_BREAKER_COOLDOWN
+		} `yaml:"circuitBreaker"`
+	} `yaml:"contextBroker"`
+
+	Service struct {
+		Port            string `yaml:"port"`            // SERVICE_PORT
+		AdminAPIKey     string `yaml:"adminApiKey"`     // ADMIN_API_KEY
+		AdminAPIKeyFile string `yaml:"adminApiKeyFile"` // ADMIN_API_KEY, read from a file
+		StateDir        string `yaml:"stateDir"`        // STATE_DIR
+	} `yaml:"service"`
+
+	Integrations map[string]Integration `yaml:"integrations"`
+}
+
+// Integration holds the settings of a single integration
+type Integration struct {
+	Enabled       *bool    `yaml:"enabled"`       // <NAME>_ENABLED
+	Schedule      string   `yaml:"schedule"`      // <NAME>_POLLING_INTERVAL
+	Jitter        string   `yaml:"jitter"`        // <NAME>_POLLING_JITTER
+	RetryInterval string   `yaml:"retryInterval"` // <NAME>_RETRY_INTERVAL
+	MaxSyncAge    string   `yaml:"maxSyncAge"`    // <NAME>_MAX_SYNC_AGE
+	EntityTypes   []string `yaml:"entityTypes"`   // <NAME>_ENTITY_TYPES, comma separated
+	Orphans       string   `yaml:"orphans"`       // <NAME>_ORPHANS, for integrations that look for orphaned entities
+	EndOfLife     string   `yaml:"endOfLife"`     // <NAME>_END_OF_LIFE, for integrations whose entities end
+	Tenant        string   `yaml:"tenant"`        // <NAME>_TENANT
+
+	// Tenants override the tenant per entity type, e.g. beaches becomes <NAME>_BEACHES_TENANT
+	Tenants map[string]string `yaml:"tenants"`
+
+	// JSONLDContext is the context of the entities of the integration, and JSONLDContexts
+	// override it per entity type, e.g. beaches becomes <NAME>_BEACHES_JSONLD_CONTEXT
+	JSONLDContext  JSONLDContext            `yaml:"jsonldContext"`
+	JSONLDContexts map[string]JSONLDContext `yaml:"jsonldContexts"`
+
+	// Safeguard protects the broker against mass deletions, for integrations that support it
+	Safeguard struct {
+		MaxFeatureDrop      string `yaml:"maxFeatureDrop"`      // <NAME>_MAX_FEATURE_DROP, in percent
+		DeleteConfirmations string `yaml:"deleteConfirmations"` // <NAME>_DELETE_CONFIRMATIONS
+		AcceptDropAfter     string `yaml:"acceptDropAfter"`     // <NAME>_ACCEPT_DROP_AFTER
+	} `yaml:"safeguard"`
+
+	Source struct {
+		URL        string `yaml:"url"`        // <NAME>_URL
+		APIKey     string `yaml:"apiKey"`     // <NAME>_API_KEY
+		APIKeyFile string `yaml:"apiKeyFile"` // <NAME>_API_KEY, read from a file
+
+		// Auth decides how requests to the source are authenticated, if not by apiKey alone
+		Auth Auth `yaml:"auth"`
+
+		FetchMode   string `yaml:"fetchMode"`   // <NAME>_FETCH_MODE, for sources that can be read incrementally
+		FullRefresh string `yaml:"fullRefresh"` // <NAME>_FULL_REFRESH
+		Timeout     string `yaml:"timeout"`     // <NAME>_FETCH_TIMEOUT
+		MaxBodySize string `yaml:"maxBodySize"` // <NAME>_MAX_BODY_SIZE
+
+		Retry struct {
+			Attempts   string `yaml:"attempts"`   // <NAME>_FETCH_ATTEMPTS
+			Backoff    string `yaml:"backoff"`    // <NAME>_FETCH_BACKOFF
+			MaxBackoff string `yaml:"maxBackoff"` // <NAME>_FETCH_MAX_BACKOFF
+		} `yaml:"retry"`
+	} `yaml:"source"`
+
+	// Mappings override the mapping data that is built into an integration. Each
+	// mapping is passed on as JSON, e.g. seeAlsoRefs becomes <NAME>_SEE_ALSO_REFS.
+	Mappings map[string]any `yaml:"mappings"`
+}
+
+// Auth holds the credentials for a source system, whose keys start with <NAME>, or for
+// the context broker, whose keys start with BROKER
+type Auth struct {
+	Type             string   `yaml:"type"`             // <NAME>_AUTH, none, apikey, bearer, oauth2 or mtls
+	Header           string   `yaml:"header"`           // <NAME>_API_KEY_HEADER
+	Token            string   `yaml:"token"`            // <NAME>_BEARER_TOKEN
+	TokenFile        string   `yaml:"tokenFile"`        // <NAME>_BEARER_TOKEN_FILE, read for every request
+	TokenURL         string   `yaml:"tokenURL"`         // <NAME>_OAUTH2_TOKEN_URL
+	ClientID         string   `yaml:"clientID"`         // <NAME>_OAUTH2_CLIENT_ID
+	ClientSecret     string   `yaml:"clientSecret"`     // <NAME>_OAUTH2_CLIENT_SECRET
+	ClientSecretFile string   `yaml:"clientSecretFile"` // <NAME>_OAUTH2_CLIENT_SECRET_FILE, read for every new token
+	Scopes           []string `yaml:"scopes"`           // <NAME>_OAUTH2_SCOPES
+	CertFile         string   `yaml:"certFile"`         // <NAME>_TLS_CERT_FILE
+	KeyFile          string   `yaml:"keyFile"`          // <NAME>_TLS_KEY_FILE
+	CAFile           string   `yaml:"caFile"`           // <NAME>_TLS_CA_FILE
+}
+
+// JSONLDContext is the JSON-LD @context that entities are written with, whose keys start
+// with BROKER, <NAME> or <NAME>_<TYPE>
+type JSONLDContext struct {
+	URL  string `yaml:"url"`  // <NAME>_JSONLD_CONTEXT
+	Mode string `yaml:"mode"` // <NAME>_JSONLD_CONTEXT_MODE, inline or link
+}
+
+// Config provides configuration values keyed by environment variable names. Values
+// that are set in the environment take precedence over values from the file.
+type Config struct {
+	values       map[string]string
+	integrations []string
+	getenv       func(string) string
+}
+
+// Get returns the value of a key from the environment, or from the file if the
+// environment variable is empty or not set
+func (c *Config) Get(key string) string {
+	if v := c.getenv(key); v != "" {
+		return v
+	}
+	return c.values[key]
+}
+
+// Integrations returns the names of the integrations that are configured in the file
+func (c *Config) Integrations() []string {
+	return c.integrations
+}
+
+// Load reads the configuration file at path. An empty path results in a configuration
+// that is read from the environment only. All problems with the file are reported
+// together rather than one at a time.
+func Load(path string) (*Config, error) {
+	cfg := &Config{values: map[string]string{}, getenv: os.Getenv}
+
+	if path == "" {
+		return cfg, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	return cfg, cfg.read(f, path)
+}
+
+func (c *Config) read(r io.Reader, name string) error {
+	file := File{}
+
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	errs := []error{}
+
+	// type errors do not stop the decoder, so the rest of the file can still be checked
+	err := dec.Decode(&file)
+	if err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		for _, e := range typeErr.Errors {
+			errs = append(errs, fmt.Errorf("%s: %s", name, e))
+		}
+	}
+
+	set := func(key, value string) {
+		if value != "" {
+			c.values[key] = value
+		}
+	}
+
+	secret := func(key, field, value, valueFile string) {
+		if value != "" && valueFile != "" {
+			errs = append(errs, fmt.Errorf("%s: %s and %sFile can not both be set", name, field, field))
+			return
+		}
+
+		if valueFile != "" {
+			b, err := os.ReadFile(valueFile)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: failed to read %sFile: %w", name, field, err))
+				return
+			}
+			value = strings.TrimSpace(string(b))
+		}
+
+		set(key, value)
+	}
+
+	setAuth := func(prefix string, a Auth) {
+		set(prefix+"AUTH", a.Type)
+		set(prefix+"API_KEY_HEADER", a.Header)
+		set(prefix+"BEARER_TOKEN", a.Token)
+		set(prefix+"BEARER_TOKEN_FILE", a.TokenFile)
+		set(prefix+"OAUTH2_TOKEN_URL", a.TokenURL)
+		set(prefix+"OAUTH2_CLIENT_ID", a.ClientID)
+		set(prefix+"OAUTH2_CLIENT_SECRET", a.ClientSecret)
+		set(prefix+"OAUTH2_CLIENT_SECRET_FILE", a.ClientSecretFile)
+		set(prefix+"OAUTH2_SCOPES", strings.Join(a.Scopes, " "))
+		set(prefix+"TLS_CERT_FILE", a.CertFile)
+		set(prefix+"TLS_KEY_FILE", a.KeyFile)
+		set(prefix+"TLS_CA_FILE", a.CAFile)
+	}
+
+	setJSONLDContext := func(prefix string, c JSONLDContext) {
+		set(prefix+"JSONLD_CONTEXT", c.URL)
+		set(prefix+"JSONLD_CONTEXT_MODE", c.Mode)
+	}
+
+	set("CONTEXT_BROKER_URL", file.ContextBroker.URL)
+	set("CONTEXT_BROKER_TENANT", file.ContextBroker.Tenant)
+	set("BROKER_RATE_LIMIT", file.ContextBroker.RateLimit)
+	set("BROKER_WORKERS", file.ContextBroker.Workers)
+	set("BROKER_BATCH_SIZE", file.ContextBroker.BatchSize)
+	setAuth("BROKER_", file.ContextBroker.Auth)
+	setJSONLDContext("BROKER_", file.ContextBroker.JSONLDContext)
+
+	if file.ContextBroker.Debug != nil {
+		set("BROKER_DEBUG", strconv.FormatBool(*file.ContextBroker.Debug))
+	}
+	set("BROKER_BREAKER_THRESHOLD", file.ContextBroker.CircuitBreaker.Threshold)
+	set("BROKER_BREAKER_COOLDOWN", file.ContextBroker.CircuitBreaker.Cooldown)
+	set("SERVICE_PORT", file.Service.Port)
+	set("STATE_DIR", file.Service.StateDir)
+	secret("ADMIN_API_KEY", "service.adminApiKey", file.Service.AdminAPIKey, file.Service.AdminAPIKeyFile)
+
+	for integration, settings := range file.Integrations {
+		c.integrations = append(c.integrations, integration)
+
+		prefix := strings.ToUpper(integration) + "_"
+		field := "integrations." + integration + "."
+
+		if settings.Enabled != nil {
+			set(prefix+"ENABLED", strconv.FormatBool(*settings.Enabled))
+		}
+
+		set(prefix+"POLLING_INTERVAL", settings.Schedule)
+		set(prefix+"POLLING_JITTER", settings.Jitter)
+		set(prefix+"RETRY_INTERVAL", settings.RetryInterval)
+		set(prefix+"MAX_SYNC_AGE", settings.MaxSyncAge)
+		set(prefix+"ENTITY_TYPES", strings.Join(settings.EntityTypes, ","))
+		set(prefix+"ORPHANS", settings.Orphans)
+		set(prefix+"END_OF_LIFE", settings.EndOfLife)
+		set(prefix+"TENANT", settings.Tenant)
+		set(prefix+"MAX_FEATURE_DROP", settings.Safeguard.MaxFeatureDrop)
+		set(prefix+"DELETE_CONFIRMATIONS", settings.Safeguard.DeleteConfirmations)
+		set(prefix+"ACCEPT_DROP_AFTER", settings.Safeguard.AcceptDropAfter)
+		set(prefix+"URL", settings.Source.URL)
+		secret(prefix+"API_KEY", field+"source.apiKey", settings.Source.APIKey, settings.Source.APIKeyFile)
+		setAuth(prefix, settings.Source.Auth)
+		set(prefix+"FETCH_MODE", settings.Source.FetchMode)
+		set(prefix+"FULL_REFRESH", settings.Source.FullRefresh)
+		set(prefix+"FETCH_TIMEOUT", settings.Source.Timeout)
+		set(prefix+"MAX_BODY_SIZE", settings.Source.MaxBodySize)
+		set(prefix+"FETCH_ATTEMPTS", settings.Source.Retry.Attempts)
+		set(prefix+"FETCH_BACKOFF", settings.Source.Retry.Backoff)
+		set(prefix+"FETCH_MAX_BACKOFF", settings.Source.Retry.MaxBackoff)
+
+		for entityType, tenant := range settings.Tenants {
+			set(prefix+strings.ToUpper(entityType)+"_TENANT", tenant)
+		}
+
+		setJSONLDContext(prefix, settings.JSONLDContext)
+		for entityType, context := range settings.JSONLDContexts {
+			setJSONLDContext(prefix+strings.ToUpper(entityType)+"_", context)
+		}
+
+		for mapping, value := range settings.Mappings {
+			b, err := json.Marshal(stringKeys(value))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %smappings.%s: %w", name, field, mapping, err))
+				continue
+			}
+			set(prefix+screamingSnakeCase(mapping), string(b))
+		}
+	}
+
+	slices.Sort(c.integrations)
+
+	return errors.Join(errs...)
+}
+
+// stringKeys converts the maps with non string keys that YAML allows, such as a mapping
+// keyed by feature ids, into maps that can be marshalled to JSON
+func stringKeys(value any) any {
+	switch v := value.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = stringKeys(e)
+		}
+		return m
+	case map[string]any:
+		for k, e := range v {
+			v[k] = stringKeys(e)
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = stringKeys(e)
+		}
+		return v
+	}
+	return value
+}
+
+// screamingSnakeCase turns a camel cased name such as seeAlsoRefs into SEE_ALSO_REFS
+func screamingSnakeCase(name string) string {
+	sb := strings.Builder{}
+
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			sb.WriteRune('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+
+	return sb.String()
+}
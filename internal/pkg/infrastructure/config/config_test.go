@@ -0,0 +1,127 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const configYAML string = `
+contextBroker:
+  url: http://orion:1026
+  tenant: sundsvall
+  debug: false
+  auth:
+    type: bearer
+    tokenFile: /run/secrets/broker-token
+integrations:
+  facilities:
+    enabled: true
+    tenant: facilities
+    tenants:
+      beaches: beaches
+    jsonldContexts:
+      beaches: {url: "https://example.com/context.jsonld", mode: link}
+    schedule: "*/30 6-22 * * *"
+    maxSyncAge: 3h
+    entityTypes: [beaches, trails]
+    source:
+      url: https://api.sundsvall.se/facilities/2.1
+      apiKeyFile: %s
+      maxBodySize: 16MiB
+      auth:
+        scopes: [facilities, read]
+    mappings:
+      seeAlsoRefs:
+        283: {nuts: SE0712281000003473, wikidata: Q10671745}
+`
+
+func TestThatTheFileIsTranslatedIntoKeys(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+
+	secret := filepath.Join(dir, "apikey")
+	is.NoErr(os.WriteFile(secret, []byte("s3cr3t\n"), 0600))
+
+	cfg := testConfig(nil)
+	is.NoErr(cfg.read(strings.NewReader(strings.Replace(configYAML, "%s", secret, 1)), "config.yaml"))
+
+	is.Equal(cfg.Get("CONTEXT_BROKER_URL"), "http://orion:1026")
+	is.Equal(cfg.Get("CONTEXT_BROKER_TENANT"), "sundsvall")
+	is.Equal(cfg.Get("BROKER_DEBUG"), "false")
+	is.Equal(cfg.Get("BROKER_AUTH"), "bearer")
+	is.Equal(cfg.Get("BROKER_BEARER_TOKEN_FILE"), "/run/secrets/broker-token")
+	is.Equal(cfg.Get("FACILITIES_ENABLED"), "true")
+	is.Equal(cfg.Get("FACILITIES_TENANT"), "facilities")
+	is.Equal(cfg.Get("FACILITIES_BEACHES_TENANT"), "beaches")
+	is.Equal(cfg.Get("FACILITIES_BEACHES_JSONLD_CONTEXT"), "https://example.com/context.jsonld")
+	is.Equal(cfg.Get("FACILITIES_BEACHES_JSONLD_CONTEXT_MODE"), "link")
+	is.Equal(cfg.Get("FACILITIES_POLLING_INTERVAL"), "*/30 6-22 * * *")
+	is.Equal(cfg.Get("FACILITIES_ENTITY_TYPES"), "beaches,trails")
+	is.Equal(cfg.Get("FACILITIES_API_KEY"), "s3cr3t")
+	is.Equal(cfg.Get("FACILITIES_MAX_BODY_SIZE"), "16MiB")
+	is.Equal(cfg.Get("FACILITIES_OAUTH2_SCOPES"), "facilities read")
+	is.Equal(cfg.Get("FACILITIES_SEE_ALSO_REFS"), `{"283":{"nuts":"SE0712281000003473","wikidata":"Q10671745"}}`)
+	is.Equal(cfg.Integrations(), []string{"facilities"})
+}
+
+func TestThatTheEnvironmentOverridesTheFile(t *testing.T) {
+	is := is.New(t)
+
+	cfg := testConfig(map[string]string{"CONTEXT_BROKER_URL": "http://localhost:1026"})
+	is.NoErr(cfg.read(strings.NewReader("contextBroker:\n  url: http://orion:1026\n  tenant: sundsvall\n"), "config.yaml"))
+
+	is.Equal(cfg.Get("CONTEXT_BROKER_URL"), "http://localhost:1026")
+	is.Equal(cfg.Get("CONTEXT_BROKER_TENANT"), "sundsvall")
+}
+
+func TestThatJSONIsAccepted(t *testing.T) {
+	is := is.New(t)
+
+	cfg := testConfig(nil)
+	is.NoErr(cfg.read(strings.NewReader(`{"integrations": {"citywork": {"enabled": false, "schedule": 59}}}`), "config.json"))
+
+	is.Equal(cfg.Get("CITYWORK_ENABLED"), "false")
+	is.Equal(cfg.Get("CITYWORK_POLLING_INTERVAL"), "59")
+}
+
+func TestThatAllErrorsAreReported(t *testing.T) {
+	is := is.New(t)
+
+	cfg := testConfig(nil)
+	err := cfg.read(strings.NewReader(`
+contextBroker:
+  adress: http://orion:1026
+integrations:
+  facilities:
+    enabled: maybe
+    source:
+      apiKey: abc
+      apiKeyFile: /does/not/exist
+`), "config.yaml")
+
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "adress"))
+	is.True(strings.Contains(err.Error(), "maybe"))
+	is.True(strings.Contains(err.Error(), "source.apiKey and integrations.facilities.source.apiKeyFile"))
+}
+
+func TestThatSecretsCanNotBeGivenTwice(t *testing.T) {
+	is := is.New(t)
+
+	cfg := testConfig(nil)
+	err := cfg.read(strings.NewReader("service:\n  adminApiKey: abc\n  adminApiKeyFile: /run/secrets/key\n"), "config.yaml")
+
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "service.adminApiKey and service.adminApiKeyFile"))
+}
+
+func testConfig(env map[string]string) *Config {
+	return &Config{
+		values: map[string]string{},
+		getenv: func(key string) string { return env[key] },
+	}
+}
@@ -0,0 +1,317 @@
+// Package dryrun provides a context broker client that records writes instead of
+// sending them, so that the outcome of a mapping change can be reviewed before it
+// is deployed.
+package dryrun
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/jsonld"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// Operations that are recorded by the dry run client
+const (
+	OperationCreate string = "create"
+	OperationMerge  string = "merge"
+	OperationUpdate string = "update"
+	OperationDelete string = "delete"
+)
+
+// The outcome of comparing a write with the current state of the broker
+const (
+	StatusNew       string = "new"
+	StatusChanged   string = "changed"
+	StatusUnchanged string = "unchanged"
+)
+
+// Record describes a single write that would have been sent to the context broker
+type Record struct {
+	Time       time.Time       `json:"time"`
+	Operation  string          `json:"operation"`
+	EntityID   string          `json:"id"`
+	EntityType string          `json:"type,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	// Status and Changes are only set when the write could be compared with the broker
+	Status       string   `json:"status,omitempty"`
+	Changes      []Change `json:"changes,omitempty"`
+	CompareError string   `json:"compareError,omitempty"`
+}
+
+// Change describes an attribute whose value differs from the one in the broker
+type Change struct {
+	Attribute string          `json:"attribute"`
+	Current   json.RawMessage `json:"current,omitempty"`
+	Proposed  json.RawMessage `json:"proposed,omitempty"`
+}
+
+type dryRunClient struct {
+	sink   Sink
+	broker client.ContextBrokerClient
+}
+
+// CompareWith makes the client read from, and compare every write with, the given broker.
+// Nothing is ever written to it.
+func CompareWith(broker client.ContextBrokerClient) func(*dryRunClient) {
+	return func(c *dryRunClient) {
+		c.broker = broker
+	}
+}
+
+// New returns a context broker client that passes every create, merge, update and delete
+// to the sink instead of the broker
+func New(sink Sink, options ...func(*dryRunClient)) client.ContextBrokerClient {
+	c := &dryRunClient{sink: sink}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+func (c *dryRunClient) CreateEntity(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+	err := c.record(ctx, OperationCreate, entity.ID(), entity.Type(), entity, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return ngsild.NewCreateEntityResult("/ngsi-ld/v1/entities/" + entity.ID()), nil
+}
+
+func (c *dryRunClient) MergeEntity(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+	err := c.record(ctx, OperationMerge, entityID, "", fragment, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ngsild.MergeEntityResult{}, nil
+}
+
+func (c *dryRunClient) UpdateEntityAttributes(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.UpdateEntityAttributesResult, error) {
+	err := c.record(ctx, OperationUpdate, entityID, "", fragment, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ngsild.UpdateEntityAttributesResult{}, nil
+}
+
+func (c *dryRunClient) DeleteEntity(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
+	err := c.record(ctx, OperationDelete, entityID, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return ngsild.NewDeleteEntityResult(), nil
+}
+
+// record compares a write with the broker, if there is one, and passes it on to the sink.
+// Writes that the broker would have rejected, because the entity already exists or does
+// not exist, are not recorded and fail with the same error as the broker would return.
+func (c *dryRunClient) record(ctx context.Context, operation, entityID, entityType string, payload types.EntityFragment, headers map[string][]string) error {
+	r := Record{
+		Time:       time.Now().UTC(),
+		Operation:  operation,
+		EntityID:   entityID,
+		EntityType: entityType,
+	}
+
+	if payload != nil {
+		b, err := payload.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload for %s: %w", entityID, err)
+		}
+		r.Payload = b
+	}
+
+	if c.broker != nil {
+		c.compare(ctx, &r, headers)
+	}
+
+	if r.Status != "" {
+		exists := r.Status != StatusNew
+		if operation == OperationCreate && exists {
+			return fmt.Errorf("%w: %s", ngsierrors.ErrAlreadyExists, entityID)
+		}
+		if operation != OperationCreate && !exists {
+			return fmt.Errorf("%w: %s", ngsierrors.ErrNotFound, entityID)
+		}
+	}
+
+	err := c.sink.Write(r)
+	if err != nil {
+		return fmt.Errorf("failed to record %s of %s: %w", operation, entityID, err)
+	}
+
+	return nil
+}
+
+// compare retrieves the entity with the same JSON-LD context as the write, so that the names
+// of its attributes are compacted in the same way as those of the payload
+func (c *dryRunClient) compare(ctx context.Context, r *Record, headers map[string][]string) {
+	retrieveHeaders := map[string][]string{"Accept": {"application/ld+json"}}
+	if link := contextLink(r.Payload, headers); link != "" {
+		retrieveHeaders["Link"] = []string{link}
+	}
+
+	current, err := c.broker.RetrieveEntity(ctx, r.EntityID, retrieveHeaders)
+	if err != nil {
+		if errors.Is(err, ngsierrors.ErrNotFound) {
+			r.Status = StatusNew
+			return
+		}
+
+		logging.GetFromContext(ctx).Warn("failed to compare with context broker", "entityID", r.EntityID, "err", err.Error())
+		r.CompareError = err.Error()
+		return
+	}
+
+	if r.EntityType == "" {
+		r.EntityType = current.Type()
+	}
+
+	if r.Operation == OperationDelete {
+		r.Status = StatusChanged
+		return
+	}
+
+	r.Changes, err = diff(current, r.Payload)
+	if err != nil {
+		r.CompareError = err.Error()
+		return
+	}
+
+	r.Status = StatusUnchanged
+	if len(r.Changes) > 0 {
+		r.Status = StatusChanged
+	}
+}
+
+// contextLink returns the Link header that the write refers to its context with, or one that
+// refers to the inline context of the payload if it consists of a single url
+func contextLink(payload json.RawMessage, headers map[string][]string) string {
+	if link := headers["Link"]; len(link) > 0 {
+		return link[0]
+	}
+
+	contents := struct {
+		Context json.RawMessage `json:"@context"`
+	}{}
+	if len(payload) == 0 || json.Unmarshal(payload, &contents) != nil {
+		return ""
+	}
+
+	var urls []string
+	if json.Unmarshal(contents.Context, &urls) != nil {
+		var url string
+		if json.Unmarshal(contents.Context, &url) != nil {
+			return ""
+		}
+		urls = []string{url}
+	}
+
+	if len(urls) != 1 {
+		return ""
+	}
+
+	return jsonld.Context{URL: urls[0]}.LinkHeader()
+}
+
+// diff returns the attributes in the payload whose values differ from the current entity.
+// Attributes that are only present in the broker are left alone by a merge and are not
+// reported.
+func diff(current types.Entity, payload json.RawMessage) ([]Change, error) {
+	b, err := current.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	before, after := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	if err = json.Unmarshal(b, &before); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(payload, &after); err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+
+	for name, proposed := range after {
+		if name == "@context" || name == "id" || name == "type" {
+			continue
+		}
+
+		if !jsonEqual(before[name], proposed) {
+			changes = append(changes, Change{Attribute: name, Current: before[name], Proposed: proposed})
+		}
+	}
+
+	slices.SortFunc(changes, func(a, b Change) int { return cmp.Compare(a.Attribute, b.Attribute) })
+
+	return changes, nil
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(va, vb)
+}
+
+func (c *dryRunClient) QueryEntities(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+	if c.broker != nil {
+		return c.broker.QueryEntities(ctx, entityTypes, entityAttributes, query, headers)
+	}
+
+	result := ngsild.NewQueryEntitiesResult()
+	result.TotalCount = 0
+	close(result.Found)
+
+	return result, nil
+}
+
+func (c *dryRunClient) RetrieveEntity(ctx context.Context, entityID string, headers map[string][]string) (types.Entity, error) {
+	if c.broker != nil {
+		return c.broker.RetrieveEntity(ctx, entityID, headers)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ngsierrors.ErrNotFound, entityID)
+}
+
+func (c *dryRunClient) QueryTemporalEvolutionOfEntities(ctx context.Context, headers map[string][]string, parameters ...client.RequestDecoratorFunc) (*ngsild.QueryTemporalEntitiesResult, error) {
+	if c.broker != nil {
+		return c.broker.QueryTemporalEvolutionOfEntities(ctx, headers, parameters...)
+	}
+
+	result := ngsild.NewQueryTemporalEntitiesResult()
+	result.TotalCount = 0
+	close(result.Found)
+
+	return result, nil
+}
+
+func (c *dryRunClient) RetrieveTemporalEvolutionOfEntity(ctx context.Context, entityID string, headers map[string][]string, parameters ...client.RequestDecoratorFunc) (*ngsild.RetrieveTemporalEvolutionOfEntityResult, error) {
+	if c.broker != nil {
+		return c.broker.RetrieveTemporalEvolutionOfEntity(ctx, entityID, headers, parameters...)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ngsierrors.ErrNotFound, entityID)
+}
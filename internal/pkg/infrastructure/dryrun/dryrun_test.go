@@ -0,0 +1,139 @@
+package dryrun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
+	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/matryer/is"
+)
+
+const beachID string = "urn:ngsi-ld:Beach:se:sundsvall:facilities:283"
+
+func TestThatWritesAreRecordedAsNDJSON(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	buf := &bytes.Buffer{}
+
+	c := New(NDJSON(nopCloser{buf}))
+
+	beach, _ := entities.New(beachID, "Beach", decorators.Name("Stranden"))
+	_, err := c.CreateEntity(ctx, beach, nil)
+	is.NoErr(err)
+
+	fragment, _ := entities.NewFragment(decorators.Description("En strand"))
+	_, err = c.MergeEntity(ctx, beachID, fragment, nil)
+	is.NoErr(err)
+
+	_, err = c.DeleteEntity(ctx, beachID)
+	is.NoErr(err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	is.Equal(len(lines), 3)
+
+	records := make([]Record, len(lines))
+	for i, l := range lines {
+		is.NoErr(json.Unmarshal([]byte(l), &records[i]))
+	}
+
+	is.Equal(records[0].Operation, OperationCreate)
+	is.Equal(records[0].EntityType, "Beach")
+	is.True(strings.Contains(string(records[0].Payload), "Stranden"))
+	is.Equal(records[1].Operation, OperationMerge)
+	is.Equal(records[2].Operation, OperationDelete)
+	is.Equal(records[2].Status, "") // nothing to compare with
+}
+
+func TestThatMergesAreComparedWithTheBroker(t *testing.T) {
+	is := is.New(t)
+	buf := &bytes.Buffer{}
+
+	c := New(NDJSON(nopCloser{buf}), CompareWith(brokerWith(
+		entityOrDie(beachID, "Beach", decorators.Name("Stranden"), decorators.Description("En strand")),
+	)))
+
+	fragment, _ := entities.NewFragment(decorators.Name("Stranden"), decorators.Description("En sandstrand"))
+	_, err := c.MergeEntity(context.Background(), beachID, fragment, nil)
+	is.NoErr(err)
+
+	r := Record{}
+	is.NoErr(json.Unmarshal(buf.Bytes(), &r))
+	is.Equal(r.Status, StatusChanged)
+	is.Equal(r.EntityType, "Beach")
+	is.Equal(len(r.Changes), 1)
+	is.Equal(r.Changes[0].Attribute, "description")
+}
+
+func TestThatMergesOfMissingEntitiesFailLikeTheBroker(t *testing.T) {
+	is := is.New(t)
+	buf := &bytes.Buffer{}
+
+	c := New(NDJSON(nopCloser{buf}), CompareWith(brokerWith()))
+
+	fragment, _ := entities.NewFragment(decorators.Name("Stranden"))
+	_, err := c.MergeEntity(context.Background(), beachID, fragment, nil)
+	is.True(errors.Is(err, ngsierrors.ErrNotFound))
+
+	beach, _ := entities.New(beachID, "Beach", decorators.Name("Stranden"))
+	_, err = c.CreateEntity(context.Background(), beach, nil)
+	is.NoErr(err)
+
+	r := Record{}
+	is.NoErr(json.Unmarshal(buf.Bytes(), &r)) // only the create should have been recorded
+	is.Equal(r.Operation, OperationCreate)
+	is.Equal(r.Status, StatusNew)
+}
+
+func TestThatTheDirectorySinkKeepsTheLatestRecordPerEntity(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+
+	sink, err := NewSink(filepath.Join(dir, "entities"))
+	is.NoErr(err)
+
+	c := New(sink)
+
+	fragment, _ := entities.NewFragment(decorators.Name("Stranden"))
+	_, err = c.MergeEntity(context.Background(), beachID, fragment, nil)
+	is.NoErr(err)
+	_, err = c.DeleteEntity(context.Background(), beachID)
+	is.NoErr(err)
+
+	b, err := os.ReadFile(filepath.Join(dir, "entities", "urn_ngsi-ld_Beach_se_sundsvall_facilities_283.json"))
+	is.NoErr(err)
+
+	r := Record{}
+	is.NoErr(json.Unmarshal(b, &r))
+	is.Equal(r.Operation, OperationDelete)
+}
+
+func brokerWith(known ...types.Entity) *test.ContextBrokerClientMock {
+	return &test.ContextBrokerClientMock{
+		RetrieveEntityFunc: func(ctx context.Context, entityID string, headers map[string][]string) (types.Entity, error) {
+			for _, e := range known {
+				if e.ID() == entityID {
+					return e, nil
+				}
+			}
+			return nil, ngsierrors.ErrNotFound
+		},
+	}
+}
+
+func entityOrDie(entityID, entityType string, decorators ...entities.EntityDecoratorFunc) types.Entity {
+	e, err := entities.New(entityID, entityType, decorators...)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
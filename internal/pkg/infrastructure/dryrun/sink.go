@@ -0,0 +1,103 @@
+package dryrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Sink receives the writes that would have been sent to the context broker
+type Sink interface {
+	Write(r Record) error
+	Close() error
+}
+
+// NewSink creates a sink from an output description. "-" writes NDJSON to stdout, a
+// path ending in .ndjson or .jsonl appends NDJSON to that file, and any other path is
+// treated as a directory in which the latest record of each entity is kept in a file
+// of its own.
+func NewSink(output string) (Sink, error) {
+	if output == "-" {
+		return NDJSON(nopCloser{os.Stdout}), nil
+	}
+
+	switch filepath.Ext(output) {
+	case ".ndjson", ".jsonl":
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open dry run output %s: %w", output, err)
+		}
+		return NDJSON(f), nil
+	}
+
+	return Directory(output)
+}
+
+type ndjsonSink struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+// NDJSON writes every record as a single line of JSON
+func NDJSON(w io.WriteCloser) Sink {
+	return &ndjsonSink{w: w, enc: json.NewEncoder(w)}
+}
+
+func (s *ndjsonSink) Write(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enc.Encode(r)
+}
+
+func (s *ndjsonSink) Close() error {
+	return s.w.Close()
+}
+
+type directorySink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Directory keeps the latest record of every entity in a file named after the entity id
+func Directory(path string) (Sink, error) {
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dry run directory %s: %w", path, err)
+	}
+
+	return &directorySink{path: path}, nil
+}
+
+var unsafeFileNameChars = strings.NewReplacer(":", "_", "/", "_", "\\", "_")
+
+func (s *directorySink) Write(r Record) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(s.path, fileName(r.EntityID)), append(b, '\n'), 0644)
+}
+
+func fileName(entityID string) string {
+	return unsafeFileNameChars.Replace(entityID) + ".json"
+}
+
+func (s *directorySink) Close() error {
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,104 @@
+// Package geojson reads GeoJSON feature collections as a stream, one feature at a time,
+// so that a large collection never has to be held in memory as text as well as decoded.
+package geojson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Decode reads a feature collection from r and calls feature with each feature as soon as
+// it has been decoded. Any other members of the collection, such as its type, are decoded
+// into collection when the whole collection has been read, unless collection is nil.
+//
+// Decode stops at the first error returned by feature, or by r.
+func Decode[F any](r io.Reader, collection any, feature func(F) error) error {
+	dec := json.NewDecoder(r)
+
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if t != json.Delim('{') {
+		return fmt.Errorf("expected a feature collection, got %v", t)
+	}
+
+	members := map[string]json.RawMessage{}
+
+	for dec.More() {
+		t, err = dec.Token()
+		if err != nil {
+			return err
+		}
+
+		name, _ := t.(string)
+
+		if name == "features" {
+			err = decodeFeatures(dec, feature)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return err
+		}
+		members[name] = value
+	}
+
+	// the end of the collection
+	if _, err = dec.Token(); err != nil {
+		return err
+	}
+
+	if t, err = dec.Token(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("unexpected %v after the feature collection", t)
+	}
+
+	if collection == nil || len(members) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, collection)
+}
+
+func decodeFeatures[F any](dec *json.Decoder, feature func(F) error) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	if t != json.Delim('[') {
+		return fmt.Errorf("expected an array of features, got %v", t)
+	}
+
+	for i := 0; dec.More(); i++ {
+		var f F
+		if err = dec.Decode(&f); err != nil {
+			return fmt.Errorf("failed to decode feature %d: %w", i, err)
+		}
+
+		if err = feature(f); err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.Token()
+	return err
+}
@@ -0,0 +1,73 @@
+package geojson
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+type feature struct {
+	ID int `json:"id"`
+}
+
+type collection struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+func TestThatFeaturesAreDecodedOneAtATime(t *testing.T) {
+	is := is.New(t)
+
+	body := `{"type":"FeatureCollection","features":[{"id":1},{"id":2},{"id":3}],"error":"none"}`
+
+	ids := []int{}
+	fc := collection{}
+
+	err := Decode(strings.NewReader(body), &fc, func(f feature) error {
+		ids = append(ids, f.ID)
+		return nil
+	})
+
+	is.NoErr(err)
+	is.Equal(ids, []int{1, 2, 3})
+	is.Equal(fc.Type, "FeatureCollection")
+	is.Equal(fc.Error, "none")
+}
+
+func TestThatDecodingStopsAtTheFirstError(t *testing.T) {
+	is := is.New(t)
+
+	stop := errors.New("stop")
+	decoded := 0
+
+	err := Decode(strings.NewReader(`{"features":[{"id":1},{"id":2}]}`), nil, func(f feature) error {
+		decoded++
+		return stop
+	})
+	is.True(errors.Is(err, stop))
+	is.Equal(decoded, 1)
+
+	// a truncated collection fails after the features that could be read
+	decoded = 0
+	err = Decode(strings.NewReader(`{"features":[{"id":1},{"id":`), nil, func(f feature) error {
+		decoded++
+		return nil
+	})
+	is.True(errors.Is(err, io.ErrUnexpectedEOF))
+	is.Equal(decoded, 1)
+}
+
+func TestThatOnlyFeatureCollectionsAreDecoded(t *testing.T) {
+	is := is.New(t)
+
+	none := func(f feature) error { return nil }
+
+	is.NoErr(Decode(strings.NewReader(`{"type":"FeatureCollection","features":null}`), nil, none))
+	is.True(Decode(strings.NewReader(`[{"id":1}]`), nil, none) != nil)
+	is.True(Decode(strings.NewReader(`{"features":{"id":1}}`), nil, none) != nil)
+	is.True(Decode(strings.NewReader(`{"features":[]}}`), nil, none) != nil)
+	is.True(Decode(strings.NewReader(`{"features":[]} {}`), nil, none) != nil)
+}
@@ -0,0 +1,164 @@
+// Package jsonld decides which JSON-LD @context the entities that are written to the context
+// broker are expanded with, and whether it is sent inline or in a Link header. The context is
+// also sent with every query, so that types and attribute names expand to the same IRIs when
+// entities are read back as when they were written.
+package jsonld
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/batch"
+)
+
+// Context is the JSON-LD context of an entity type
+type Context struct {
+	// URL is the location of the context document
+	URL string
+	// Link sends the context in a Link header along with application/json, instead of
+	// inline in the body of application/ld+json
+	Link bool
+}
+
+// Default is the context that entities are written with unless configured otherwise
+var Default = Context{URL: entities.DefaultContextURL}
+
+// LinkHeader returns a Link header that refers to the context
+func (c Context) LinkHeader() string {
+	return fmt.Sprintf(`<%s>; rel="http://www.w3.org/ns/json-ld#context"; type="application/ld+json"`, c.URL)
+}
+
+// WriteHeaders returns the headers of a request that writes entities with the context
+func (c Context) WriteHeaders(headers map[string][]string) map[string][]string {
+	h := c.ReadHeaders(headers)
+
+	if c.Link {
+		h["Content-Type"] = []string{"application/json"}
+	} else {
+		h["Content-Type"] = []string{"application/ld+json"}
+		delete(h, "Link")
+	}
+
+	return h
+}
+
+// ReadHeaders returns the headers of a request that reads entities with the context. A
+// query can only refer to its context through a Link header, however it was written.
+func (c Context) ReadHeaders(headers map[string][]string) map[string][]string {
+	h := maps.Clone(headers)
+	if h == nil {
+		h = map[string][]string{}
+	}
+
+	h["Link"] = []string{c.LinkHeader()}
+
+	return h
+}
+
+// Marshal returns the JSON of an entity or fragment with the context inline, or without a
+// context if it is sent in a Link header, whatever context the entity was created with
+func (c Context) Marshal(e types.EntityFragment) ([]byte, error) {
+	b, err := e.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	contents := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &contents); err != nil {
+		return nil, err
+	}
+
+	if c.Link {
+		delete(contents, "@context")
+	} else {
+		contents["@context"], _ = json.Marshal([]string{c.URL})
+	}
+
+	return json.Marshal(contents)
+}
+
+// entity is marshalled with the context of the client that writes it
+type entity struct {
+	types.Entity
+	context Context
+}
+
+func (e entity) MarshalJSON() ([]byte, error) {
+	return e.context.Marshal(e.Entity)
+}
+
+type fragment struct {
+	types.EntityFragment
+	context Context
+}
+
+func (f fragment) MarshalJSON() ([]byte, error) {
+	return f.context.Marshal(f.EntityFragment)
+}
+
+type ldClient struct {
+	client.ContextBrokerClient
+	context Context
+}
+
+type ldBatchClient struct {
+	*ldClient
+	batches batch.Writer
+}
+
+// NewClient returns a context broker client that writes and reads entities with the context.
+// Batches are written with the context as well if the broker client supports them.
+func NewClient(broker client.ContextBrokerClient, c Context) client.ContextBrokerClient {
+	ld := &ldClient{ContextBrokerClient: broker, context: c}
+
+	if batches, ok := broker.(batch.Writer); ok {
+		return &ldBatchClient{ldClient: ld, batches: batches}
+	}
+
+	return ld
+}
+
+func (c *ldClient) CreateEntity(ctx context.Context, e types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+	return c.ContextBrokerClient.CreateEntity(ctx, entity{Entity: e, context: c.context}, c.context.WriteHeaders(headers))
+}
+
+func (c *ldClient) MergeEntity(ctx context.Context, entityID string, f types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+	return c.ContextBrokerClient.MergeEntity(ctx, entityID, fragment{EntityFragment: f, context: c.context}, c.context.WriteHeaders(headers))
+}
+
+func (c *ldClient) UpdateEntityAttributes(ctx context.Context, entityID string, f types.EntityFragment, headers map[string][]string) (*ngsild.UpdateEntityAttributesResult, error) {
+	return c.ContextBrokerClient.UpdateEntityAttributes(ctx, entityID, fragment{EntityFragment: f, context: c.context}, c.context.WriteHeaders(headers))
+}
+
+func (c *ldClient) QueryEntities(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+	return c.ContextBrokerClient.QueryEntities(ctx, entityTypes, entityAttributes, query, c.context.ReadHeaders(headers))
+}
+
+func (c *ldClient) RetrieveEntity(ctx context.Context, entityID string, headers map[string][]string) (types.Entity, error) {
+	return c.ContextBrokerClient.RetrieveEntity(ctx, entityID, c.context.ReadHeaders(headers))
+}
+
+var _ batch.Writer = &ldBatchClient{}
+
+func (c *ldBatchClient) BatchSize() int {
+	return c.batches.BatchSize()
+}
+
+func (c *ldBatchClient) UpsertEntities(ctx context.Context, upserts []types.Entity, headers map[string][]string) (batch.Result, error) {
+	withContext := make([]types.Entity, 0, len(upserts))
+	for _, e := range upserts {
+		withContext = append(withContext, entity{Entity: e, context: c.context})
+	}
+
+	return c.batches.UpsertEntities(ctx, withContext, c.context.WriteHeaders(headers))
+}
+
+func (c *ldBatchClient) DeleteEntities(ctx context.Context, entityIDs []string) (batch.Result, error) {
+	return c.batches.DeleteEntities(ctx, entityIDs)
+}
@@ -0,0 +1,138 @@
+package jsonld
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities"
+	"github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
+	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/batch"
+	"github.com/matryer/is"
+)
+
+const contextURL string = "https://example.com/ngsi-ld/context.jsonld"
+
+func TestThatTheContextIsSentInline(t *testing.T) {
+	is := is.New(t)
+
+	broker, body, headers := recordingBroker(t)
+	c := NewClient(broker, Context{URL: contextURL})
+
+	_, err := c.CreateEntity(context.Background(), newBeach(t), map[string][]string{"Content-Type": {"application/ld+json"}})
+	is.NoErr(err)
+
+	is.Equal((*headers)["Content-Type"], []string{"application/ld+json"})
+	is.Equal(len((*headers)["Link"]), 0)
+	is.Equal((*body)["@context"], []any{contextURL})
+	is.Equal((*body)["name"].(map[string]any)["value"], "Stranden")
+}
+
+func TestThatTheContextCanBeSentInALinkHeader(t *testing.T) {
+	is := is.New(t)
+
+	broker, body, headers := recordingBroker(t)
+	c := NewClient(broker, Context{URL: contextURL, Link: true})
+
+	fragment, _ := entities.NewFragment(decorators.Name("Stranden"))
+	_, err := c.MergeEntity(context.Background(), "urn:ngsi-ld:Beach:1", fragment, map[string][]string{"Content-Type": {"application/ld+json"}})
+	is.NoErr(err)
+
+	is.Equal((*headers)["Content-Type"], []string{"application/json"})
+	is.Equal((*headers)["Link"], []string{`<` + contextURL + `>; rel="http://www.w3.org/ns/json-ld#context"; type="application/ld+json"`})
+
+	_, found := (*body)["@context"]
+	is.True(!found)
+}
+
+func TestThatQueriesReferToTheContext(t *testing.T) {
+	is := is.New(t)
+
+	var link []string
+	broker := &test.ContextBrokerClientMock{
+		QueryEntitiesFunc: func(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+			link = headers["Link"]
+			return ngsild.NewQueryEntitiesResult(), nil
+		},
+	}
+
+	_, err := NewClient(broker, Context{URL: contextURL}).QueryEntities(context.Background(), []string{"Beach"}, nil, "?type=Beach", nil)
+	is.NoErr(err)
+	is.Equal(link, []string{Context{URL: contextURL}.LinkHeader()})
+}
+
+func TestThatBatchesAreWrittenWithTheContext(t *testing.T) {
+	is := is.New(t)
+
+	b := &batches{}
+	c := NewClient(b, Context{URL: contextURL, Link: true})
+
+	writer, ok := c.(interface {
+		UpsertEntities(context.Context, []types.Entity, map[string][]string) (batch.Result, error)
+	})
+	is.True(ok)
+
+	_, err := writer.UpsertEntities(context.Background(), []types.Entity{newBeach(t)}, nil)
+	is.NoErr(err)
+
+	is.Equal(b.headers["Content-Type"], []string{"application/json"})
+
+	payload, _ := json.Marshal(b.upserts)
+	is.Equal(string(payload), `[{"id":"urn:ngsi-ld:Beach:1","name":{"type":"Property","value":"Stranden"},"type":"Beach"}]`)
+}
+
+type batches struct {
+	*test.ContextBrokerClientMock
+	upserts []types.Entity
+	headers map[string][]string
+}
+
+func (b *batches) BatchSize() int { return 10 }
+
+func (b *batches) UpsertEntities(ctx context.Context, upserts []types.Entity, headers map[string][]string) (batch.Result, error) {
+	b.upserts, b.headers = upserts, headers
+	return batch.Result{}, nil
+}
+
+func (b *batches) DeleteEntities(ctx context.Context, entityIDs []string) (batch.Result, error) {
+	return batch.Result{}, nil
+}
+
+// recordingBroker returns a broker that keeps the body and headers of the last write
+func recordingBroker(t *testing.T) (*test.ContextBrokerClientMock, *map[string]any, *map[string][]string) {
+	body, headers := map[string]any{}, map[string][]string{}
+
+	record := func(e types.EntityFragment, h map[string][]string) {
+		b, err := e.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clear(body)
+		json.Unmarshal(b, &body)
+		headers = h
+	}
+
+	broker := &test.ContextBrokerClientMock{
+		CreateEntityFunc: func(ctx context.Context, entity types.Entity, h map[string][]string) (*ngsild.CreateEntityResult, error) {
+			record(entity, h)
+			return ngsild.NewCreateEntityResult(entity.ID()), nil
+		},
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, h map[string][]string) (*ngsild.MergeEntityResult, error) {
+			record(fragment, h)
+			return &ngsild.MergeEntityResult{}, nil
+		},
+	}
+
+	return broker, &body, &headers
+}
+
+func newBeach(t *testing.T) types.Entity {
+	e, err := entities.New("urn:ngsi-ld:Beach:1", "Beach", decorators.Name("Stranden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
@@ -0,0 +1,42 @@
+package lifecycle
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultDrainPeriod is how long an in-flight run is allowed to continue after
+// shutdown has been requested. It is kept below the default termination grace
+// period in Kubernetes (30s) so that we get to exit on our own terms.
+const DefaultDrainPeriod time.Duration = 20 * time.Second
+
+// WithDrainPeriod returns a context that is not cancelled together with its parent,
+// but at most drainPeriod after the parent has been cancelled. This lets a run that
+// is already in progress finish what it is doing when the service is shutting down.
+func WithDrainPeriod(parent context.Context, drainPeriod time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+
+	stop := context.AfterFunc(parent, func() {
+		timer := time.AfterFunc(drainPeriod, cancel)
+		context.AfterFunc(ctx, func() { timer.Stop() })
+	})
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Sleep pauses the current goroutine for at least the duration d, or until the
+// context is cancelled. It returns the context error if the sleep was cut short.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
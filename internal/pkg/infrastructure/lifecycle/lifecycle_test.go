@@ -0,0 +1,42 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatDrainContextOutlivesItsParent(t *testing.T) {
+	is := is.New(t)
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := WithDrainPeriod(parent, 50*time.Millisecond)
+	defer cancel()
+
+	cancelParent()
+	is.NoErr(ctx.Err())
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("drain context was not cancelled after the drain period")
+	}
+
+	is.True(errors.Is(ctx.Err(), context.Canceled))
+}
+
+func TestThatSleepReturnsEarlyWhenCancelled(t *testing.T) {
+	is := is.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	err := Sleep(ctx, time.Minute)
+
+	is.True(errors.Is(err, context.Canceled))
+	is.True(time.Since(start) < time.Second)
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	"github.com/diwise/context-broker/pkg/ngsild/client"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+)
+
+type limitedClient struct {
+	broker  client.ContextBrokerClient
+	limiter *Limiter
+}
+
+// NewClient returns a context broker client that waits for the limiter before every request
+func NewClient(broker client.ContextBrokerClient, limiter *Limiter) client.ContextBrokerClient {
+	return &limitedClient{broker: broker, limiter: limiter}
+}
+
+func (c *limitedClient) CreateEntity(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.broker.CreateEntity(ctx, entity, headers)
+}
+
+func (c *limitedClient) QueryEntities(ctx context.Context, entityTypes, entityAttributes []string, query string, headers map[string][]string) (*ngsild.QueryEntitiesResult, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.broker.QueryEntities(ctx, entityTypes, entityAttributes, query, headers)
+}
+
+func (c *limitedClient) RetrieveEntity(ctx context.Context, entityID string, headers map[string][]string) (types.Entity, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.broker.RetrieveEntity(ctx, entityID, headers)
+}
+
+func (c *limitedClient) QueryTemporalEvolutionOfEntities(ctx context.Context, headers map[string][]string, parameters ...client.RequestDecoratorFunc) (*ngsild.QueryTemporalEntitiesResult, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.broker.QueryTemporalEvolutionOfEntities(ctx, headers, parameters...)
+}
+
+func (c *limitedClient) RetrieveTemporalEvolutionOfEntity(ctx context.Context, entityID string, headers map[string][]string, parameters ...client.RequestDecoratorFunc) (*ngsild.RetrieveTemporalEvolutionOfEntityResult, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.broker.RetrieveTemporalEvolutionOfEntity(ctx, entityID, headers, parameters...)
+}
+
+func (c *limitedClient) MergeEntity(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.broker.MergeEntity(ctx, entityID, fragment, headers)
+}
+
+func (c *limitedClient) UpdateEntityAttributes(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.UpdateEntityAttributesResult, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.broker.UpdateEntityAttributes(ctx, entityID, fragment, headers)
+}
+
+func (c *limitedClient) DeleteEntity(ctx context.Context, entityID string) (*ngsild.DeleteEntityResult, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.broker.DeleteEntity(ctx, entityID)
+}
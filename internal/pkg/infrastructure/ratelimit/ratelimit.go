@@ -0,0 +1,150 @@
+// Package ratelimit limits the rate of requests that are sent to the context broker.
+// The limit is shared by all integrations and is lowered temporarily when the broker
+// responds that it is overloaded.
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/lifecycle"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/retry"
+)
+
+// defaultBackoff is how long requests are paused after an overloaded response that
+// does not say when to retry
+const defaultBackoff time.Duration = 5 * time.Second
+
+// Limiter is a token bucket that allows a configured number of requests per second
+// on average. When the broker signals that it is overloaded the rate is halved and
+// all requests are paused for the requested period, after which the rate recovers
+// gradually with every successful request.
+type Limiter struct {
+	limit   float64
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	resume  time.Time
+	now     func() time.Time
+	backoff time.Duration
+	mu      sync.Mutex
+}
+
+// NewLimiter returns a limiter that allows perSecond requests per second with bursts of
+// up to burst requests. A limit of zero or less disables rate limiting, but requests are
+// still paused when the broker is overloaded.
+func NewLimiter(perSecond float64, burst int) *Limiter {
+	return &Limiter{
+		limit:   perSecond,
+		rate:    perSecond,
+		burst:   float64(max(burst, 1)),
+		tokens:  float64(max(burst, 1)),
+		now:     time.Now,
+		backoff: defaultBackoff,
+	}
+}
+
+// Rate returns the number of requests per second that are currently allowed
+func (l *Limiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rate
+}
+
+// Wait blocks until a request may be sent, or until the context is cancelled
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		delay := l.reserve()
+		if delay == 0 {
+			return nil
+		}
+
+		if err := lifecycle.Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a token and returns zero, or returns how long to wait before trying again
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	if now.Before(l.resume) {
+		return l.resume.Sub(now)
+	}
+
+	if l.limit <= 0 {
+		return 0
+	}
+
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Throttle pauses all requests for the given period, or a default period if it is zero,
+// and halves the allowed rate
+func (l *Limiter) Throttle(retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if retryAfter <= 0 {
+		retryAfter = l.backoff
+	}
+
+	if resume := l.now().Add(retryAfter); resume.After(l.resume) {
+		l.resume = resume
+	}
+
+	// never go below one request every ten seconds
+	l.rate = max(l.rate/2, min(l.limit, 0.1))
+	l.tokens = 0
+}
+
+// Success lets the allowed rate recover towards the configured limit
+func (l *Limiter) Success() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = min(l.limit, l.rate+l.limit/20)
+}
+
+// Transport returns a round tripper that reports the responses of the broker to the
+// limiter, so that it can slow down when the broker responds with 429 or 503
+func (l *Limiter) Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			l.Throttle(retry.ParseRetryAfter(resp.Header.Get("Retry-After"), l.now()))
+		} else if resp.StatusCode < http.StatusInternalServerError {
+			l.Success()
+		}
+
+		return resp, nil
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatTheLimiterAllowsABurstAndThenWaits(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	l := NewLimiter(10, 2)
+	l.now = func() time.Time { return now }
+
+	is.Equal(l.reserve(), time.Duration(0))
+	is.Equal(l.reserve(), time.Duration(0))
+	is.Equal(l.reserve(), 100*time.Millisecond)
+
+	now = now.Add(100 * time.Millisecond)
+	is.Equal(l.reserve(), time.Duration(0))
+}
+
+func TestThatOverloadedResponsesPauseAndSlowDownRequests(t *testing.T) {
+	is := is.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	l := NewLimiter(10, 1)
+	l.now = func() time.Time { return now }
+
+	c := http.Client{Transport: l.Transport(http.DefaultTransport)}
+	resp, err := c.Get(server.URL)
+	is.NoErr(err)
+	resp.Body.Close()
+
+	is.Equal(l.Rate(), 5.0)
+	is.Equal(l.reserve(), 3*time.Second)
+
+	for range 20 {
+		l.Success()
+	}
+	is.Equal(l.Rate(), 10.0)
+}
+
+func TestThatWaitIsCancelledWithItsContext(t *testing.T) {
+	is := is.New(t)
+
+	l := NewLimiter(1, 1)
+	l.Throttle(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	is.Equal(l.Wait(ctx), context.DeadlineExceeded)
+}
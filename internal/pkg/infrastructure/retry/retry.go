@@ -0,0 +1,125 @@
+// Package retry repeats requests to source systems that fail for reasons that are
+// likely to go away, such as network errors and overloaded servers, with an
+// exponentially increasing and randomised delay between the attempts.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/lifecycle"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// Policy decides how many times, and how often, a request is attempted
+type Policy struct {
+	// Attempts is the total number of attempts, including the first one
+	Attempts int
+	// InitialDelay is the upper bound of the delay before the first retry, and is doubled for every retry
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between two attempts, unless the server asks for a longer pause
+	MaxDelay time.Duration
+}
+
+// DefaultPolicy is used by source clients unless they are configured otherwise
+var DefaultPolicy = Policy{Attempts: 4, InitialDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// StatusError is returned for a response with an unexpected status code
+type StatusError struct {
+	StatusCode int
+	// RetryAfter is the pause requested by the server, or zero if it did not ask for one
+	RetryAfter time.Duration
+}
+
+// NewStatusError returns an error describing the status and Retry-After header of the response
+func NewStatusError(resp *http.Response) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d (%s)", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Permanent tells if the error is caused by the request itself, e.g. an invalid API key,
+// and will not go away by sending the request again
+func (e *StatusError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// IsPermanent tells if err, or any error that it wraps, is an error that will not go away
+// by retrying the request, such as a StatusError for a 4xx response
+func IsPermanent(err error) bool {
+	var permanent interface{ Permanent() bool }
+	return errors.As(err, &permanent) && permanent.Permanent()
+}
+
+// Do calls fn until it succeeds, fails permanently, or the attempts of the policy have been
+// used up. Other errors, such as network errors, are retried. The error
+// of the last attempt is returned.
+func Do(ctx context.Context, p Policy, fn func() error) error {
+	logger := logging.GetFromContext(ctx)
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || ctx.Err() != nil || IsPermanent(err) || attempt >= p.Attempts {
+			return err
+		}
+
+		delay := p.Backoff(attempt)
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			delay = max(delay, statusErr.RetryAfter)
+		}
+
+		logger.Warn("request failed, retrying", "attempt", attempt, "retry_in", delay.String(), "err", err.Error())
+
+		if lifecycle.Sleep(ctx, delay) != nil {
+			return err
+		}
+	}
+}
+
+// Backoff returns a random delay of up to InitialDelay * 2^(attempt-1), capped by MaxDelay
+func (p Policy) Backoff(attempt int) time.Duration {
+	limit := p.InitialDelay << min(attempt-1, 30)
+	if limit <= 0 || (p.MaxDelay > 0 && limit > p.MaxDelay) {
+		limit = p.MaxDelay
+	}
+
+	if limit <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int64N(int64(limit)) + 1)
+}
+
+// ParseRetryAfter understands both forms of the Retry-After header, a number of
+// seconds or an http date, and returns zero if the header is missing or invalid
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+
+	return 0
+}
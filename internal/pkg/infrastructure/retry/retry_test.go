@@ -0,0 +1,96 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+var quickly = Policy{Attempts: 4, InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestThatTransientErrorsAreRetried(t *testing.T) {
+	is := is.New(t)
+
+	attempts := 0
+	err := Do(context.Background(), quickly, func() error {
+		attempts++
+		if attempts < 3 {
+			return &StatusError{StatusCode: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+
+	is.NoErr(err)
+	is.Equal(attempts, 3)
+}
+
+func TestThatTheLastErrorIsReturnedWhenAttemptsAreUsedUp(t *testing.T) {
+	is := is.New(t)
+
+	attempts := 0
+	err := Do(context.Background(), quickly, func() error {
+		attempts++
+		return fmt.Errorf("attempt %d: connection refused", attempts)
+	})
+
+	is.Equal(err.Error(), "attempt 4: connection refused")
+}
+
+func TestThatClientErrorsArePermanent(t *testing.T) {
+	is := is.New(t)
+
+	attempts := 0
+	err := Do(context.Background(), quickly, func() error {
+		attempts++
+		return fmt.Errorf("failed to fetch: %w", &StatusError{StatusCode: http.StatusUnauthorized})
+	})
+
+	is.True(IsPermanent(err))
+	is.Equal(attempts, 1)
+	is.True(!IsPermanent(&StatusError{StatusCode: http.StatusTooManyRequests}))
+	is.True(!IsPermanent(errors.New("connection reset by peer")))
+}
+
+func TestThatRetryAfterIsHonoured(t *testing.T) {
+	is := is.New(t)
+
+	attempts := 0
+	started := time.Now()
+	Do(context.Background(), quickly, func() error {
+		attempts++
+		if attempts == 1 {
+			return &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 100 * time.Millisecond}
+		}
+		return nil
+	})
+
+	is.True(time.Since(started) >= 100*time.Millisecond)
+}
+
+func TestThatBackoffIsCappedAndRandomised(t *testing.T) {
+	is := is.New(t)
+
+	p := Policy{Attempts: 10, InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	is.True(p.Backoff(1) <= time.Second)
+	is.True(p.Backoff(2) <= 2*time.Second)
+
+	for attempt := 1; attempt < 100; attempt++ {
+		delay := p.Backoff(attempt)
+		is.True(delay > 0)
+		is.True(delay <= 5*time.Second)
+	}
+}
+
+func TestRetryAfterCanBeAnHTTPDate(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	is.Equal(ParseRetryAfter("Sat, 01 Jun 2024 12:00:30 GMT", now), 30*time.Second)
+	is.Equal(ParseRetryAfter("soon", now), time.Duration(0))
+}
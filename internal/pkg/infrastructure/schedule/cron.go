@@ -0,0 +1,161 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type field uint64
+
+func (f field) has(value int) bool {
+	return f&(1<<uint(value)) != 0
+}
+
+type cron struct {
+	minute, hour, dom, month, dow field
+	// cron matches a day if either the day of month or the day of week matches
+	// when both are restricted, and requires both to match otherwise
+	domRestricted, dowRestricted bool
+}
+
+type bounds struct {
+	name     string
+	min, max int
+}
+
+func parseCron(spec string) (*cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression, but found %d", len(fields))
+	}
+
+	c := &cron{}
+	var err error
+
+	if c.minute, err = parseField(fields[0], bounds{"minute", 0, 59}); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseField(fields[1], bounds{"hour", 0, 23}); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseField(fields[2], bounds{"day of month", 1, 31}); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseField(fields[3], bounds{"month", 1, 12}); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseField(fields[4], bounds{"day of week", 0, 7}); err != nil {
+		return nil, err
+	}
+
+	// both 0 and 7 mean sunday
+	if c.dow.has(7) {
+		c.dow |= 1
+	}
+
+	c.domRestricted = !strings.HasPrefix(fields[2], "*")
+	c.dowRestricted = !strings.HasPrefix(fields[4], "*")
+
+	if c.Next(time.Now()).IsZero() {
+		return nil, errors.New("cron expression never activates")
+	}
+
+	return c, nil
+}
+
+func parseField(value string, b bounds) (field, error) {
+	var f field
+
+	for _, part := range strings.Split(value, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			s, err := strconv.Atoi(stepStr)
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step \"%s\" in %s field", stepStr, b.name)
+			}
+			step = s
+		}
+
+		start, end := b.min, b.max
+
+		if rng != "*" {
+			lo, hi, isRange := strings.Cut(rng, "-")
+
+			var err error
+			if start, err = strconv.Atoi(lo); err != nil {
+				return 0, fmt.Errorf("invalid value \"%s\" in %s field", lo, b.name)
+			}
+
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(hi); err != nil {
+					return 0, fmt.Errorf("invalid value \"%s\" in %s field", hi, b.name)
+				}
+			} else if hasStep {
+				end = b.max
+			}
+		}
+
+		if start < b.min || end > b.max || start > end {
+			return 0, fmt.Errorf("%s field value \"%s\" is out of range (%d-%d)", b.name, part, b.min, b.max)
+		}
+
+		for v := start; v <= end; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+
+	return f, nil
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	domMatch := c.dom.has(t.Day())
+	dowMatch := c.dow.has(int(t.Weekday()))
+
+	if c.domRestricted && c.dowRestricted {
+		return domMatch || dowMatch
+	}
+
+	return domMatch && dowMatch
+}
+
+func (c *cron) Next(t time.Time) time.Time {
+	loc := time.Local
+	t = t.In(loc).Truncate(time.Minute).Add(time.Minute)
+
+	// give up if nothing matches within a few years (e.g. february 30th)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		y, m, d := t.Date()
+
+		if !c.month.has(int(m)) {
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !c.dayMatches(t) {
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !c.hour.has(t.Hour()) {
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+
+		if !c.minute.has(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
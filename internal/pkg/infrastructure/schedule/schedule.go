@@ -0,0 +1,115 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when something should happen next
+type Schedule interface {
+	// Next returns the first activation time after t, or the zero time if there is none
+	Next(t time.Time) time.Time
+}
+
+// Parse creates a schedule from a specification. The following formats are supported:
+//
+//	58            a plain integer is treated as a number of minutes between activations
+//	1h30m         a time.Duration string, i.e. the time between activations
+//	*/10 5-21 * * *  a five field cron expression (minute, hour, day of month, month, day of week)
+//	@daily        one of the descriptors @hourly, @daily or @midnight
+//
+// Several specifications can be combined by separating them with a semicolon, such
+// as "*/10 5-21 * * *; 0 3 * * *", in which case the earliest activation wins.
+// Cron expressions are evaluated in the local time zone.
+func Parse(spec string) (Schedule, error) {
+	parts := strings.Split(spec, ";")
+	schedules := make(union, 0, len(parts))
+
+	for _, part := range parts {
+		s, err := parseOne(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule \"%s\": %w", spec, err)
+		}
+		schedules = append(schedules, s)
+	}
+
+	if len(schedules) == 1 {
+		return schedules[0], nil
+	}
+
+	return schedules, nil
+}
+
+// ParseDuration parses a duration that is either a plain integer number of minutes,
+// or a string accepted by time.ParseDuration.
+func ParseDuration(value string) (time.Duration, error) {
+	if minutes, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(minutes) * time.Minute, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// Every returns a schedule that activates at a fixed interval
+func Every(d time.Duration) Schedule {
+	return interval(d)
+}
+
+// Jitter returns a random duration in the half open interval [0, max)
+func Jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(max)))
+}
+
+func parseOne(spec string) (Schedule, error) {
+	if spec == "" {
+		return nil, errors.New("empty schedule")
+	}
+
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	}
+
+	if len(strings.Fields(spec)) == 1 {
+		d, err := ParseDuration(spec)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, errors.New("interval must be positive")
+		}
+		return Every(d), nil
+	}
+
+	return parseCron(spec)
+}
+
+type interval time.Duration
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+type union []Schedule
+
+func (u union) Next(t time.Time) time.Time {
+	next := time.Time{}
+
+	for _, s := range u {
+		n := s.Next(t)
+		if !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,83 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatIntegersAreTreatedAsMinutes(t *testing.T) {
+	is := is.New(t)
+
+	s, err := Parse("58")
+	is.NoErr(err)
+
+	now := time.Now()
+	is.Equal(s.Next(now), now.Add(58*time.Minute))
+}
+
+func TestThatDurationsAreSupported(t *testing.T) {
+	is := is.New(t)
+
+	s, err := Parse("1h30m")
+	is.NoErr(err)
+
+	now := time.Now()
+	is.Equal(s.Next(now), now.Add(90*time.Minute))
+}
+
+func TestCronWithinOpeningHours(t *testing.T) {
+	is := is.New(t)
+
+	s, err := Parse("*/10 5-21 * * *")
+	is.NoErr(err)
+
+	is.Equal(s.Next(local(2024, 5, 1, 12, 3)), local(2024, 5, 1, 12, 10))
+	is.Equal(s.Next(local(2024, 5, 1, 21, 50)), local(2024, 5, 2, 5, 0))
+	is.Equal(s.Next(local(2024, 12, 31, 23, 59)), local(2025, 1, 1, 5, 0))
+}
+
+func TestCombinedSchedules(t *testing.T) {
+	is := is.New(t)
+
+	s, err := Parse("*/10 5-21 * * *; 0 3 * * *")
+	is.NoErr(err)
+
+	is.Equal(s.Next(local(2024, 5, 1, 22, 0)), local(2024, 5, 2, 3, 0))
+	is.Equal(s.Next(local(2024, 5, 2, 3, 0)), local(2024, 5, 2, 5, 0))
+}
+
+func TestCronWithDayOfWeek(t *testing.T) {
+	is := is.New(t)
+
+	s, err := Parse("30 6 * * 1-5")
+	is.NoErr(err)
+
+	// 2024-05-04 is a saturday
+	is.Equal(s.Next(local(2024, 5, 4, 8, 0)), local(2024, 5, 6, 6, 30))
+}
+
+func TestInvalidSchedules(t *testing.T) {
+	is := is.New(t)
+
+	for _, spec := range []string{"", "-5", "soon", "* * * *", "60 * * * *", "*/0 * * * *", "0 0 30 2 *", "5-1 * * * *"} {
+		_, err := Parse(spec)
+		is.True(err != nil) // spec should not be valid
+	}
+}
+
+func TestJitterIsWithinBounds(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(Jitter(0), time.Duration(0))
+
+	for range 100 {
+		j := Jitter(time.Minute)
+		is.True(j >= 0 && j < time.Minute)
+	}
+}
+
+func local(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.Local)
+}
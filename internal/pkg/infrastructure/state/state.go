@@ -0,0 +1,118 @@
+// Package state persists the bookkeeping of integrations, such as features that have
+// already been deleted, so that it survives a restart of the service.
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrNotFound error = errors.New("no state has been saved")
+
+// Store saves opaque state documents under a name, one per integration
+type Store interface {
+	// Load returns the document saved under name, or ErrNotFound if there is none
+	Load(name string) ([]byte, error)
+	Save(name string, data []byte) error
+}
+
+// New returns a store that keeps its documents in the directory dir, or in
+// memory only if dir is empty
+func New(dir string) (Store, error) {
+	if dir == "" {
+		return NewMemoryStore(), nil
+	}
+	return NewFileStore(dir)
+}
+
+type fileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore returns a store that writes each document to a JSON file in dir,
+// creating the directory if it does not exist
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) Load(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+// Save replaces the document atomically, so that a crash while saving leaves the
+// previous document intact
+func (s *fileStore) Save(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path(name))
+}
+
+func (s *fileStore) path(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(name)
+	return filepath.Join(s.dir, name+".json")
+}
+
+type memoryStore struct {
+	documents map[string][]byte
+	mu        sync.Mutex
+}
+
+// NewMemoryStore returns a store that does not survive a restart
+func NewMemoryStore() Store {
+	return &memoryStore{documents: map[string][]byte{}}
+}
+
+func (s *memoryStore) Load(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.documents[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return data, nil
+}
+
+func (s *memoryStore) Save(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.documents[name] = data
+	return nil
+}
@@ -0,0 +1,46 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatTheFileStoreSurvivesARestart(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	is.NoErr(err)
+
+	_, err = store.Load("facilities")
+	is.True(errors.Is(err, ErrNotFound))
+
+	is.NoErr(store.Save("facilities", []byte(`{"deleted":{}}`)))
+	is.NoErr(store.Save("facilities", []byte(`{"deleted":{"1":"2024-01-01T00:00:00Z"}}`)))
+
+	store, err = NewFileStore(dir)
+	is.NoErr(err)
+
+	data, err := store.Load("facilities")
+	is.NoErr(err)
+	is.Equal(string(data), `{"deleted":{"1":"2024-01-01T00:00:00Z"}}`)
+
+	files, _ := os.ReadDir(dir)
+	is.Equal(len(files), 1) // temporary files should have been removed
+}
+
+func TestThatAnEmptyDirectoryGivesAMemoryStore(t *testing.T) {
+	is := is.New(t)
+
+	store, err := New("")
+	is.NoErr(err)
+
+	is.NoErr(store.Save("citywork", []byte("{}")))
+
+	data, err := store.Load("citywork")
+	is.NoErr(err)
+	is.Equal(string(data), "{}")
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/cors"
+)
+
+// New creates the http handler for the service. The admin endpoints are only
+// enabled if an admin api key is supplied.
+func New(ctx context.Context, m *integrations.Manager, adminAPIKey string) http.Handler {
+	r := chi.NewRouter()
+	r.Use(cors.New(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+		Debug:            false,
+	}).Handler)
+
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r.Get("/status", statusHandler(m))
+	r.Get("/readyz", statusHandler(m))
+	r.Handle("/metrics", promhttp.Handler())
+
+	logger := logging.GetFromContext(ctx)
+
+	if adminAPIKey == "" {
+		logger.Warn("no admin api key configured, admin endpoints are disabled")
+		return r
+	}
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(requireAPIKey(adminAPIKey))
+
+		r.Post("/integrations/{integration}/sync", triggerSyncHandler(m))
+		r.Get("/runs/{id}", getRunHandler(m))
+
+		if outbox := m.Outbox(); outbox != nil {
+			r.Get("/outbox", listOutboxHandler(outbox))
+			r.Post("/outbox/replay", replayOutboxHandler(outbox))
+			r.Post("/outbox/{entityID}/replay", replayOutboxHandler(outbox))
+			r.Delete("/outbox/{entityID}", discardOutboxHandler(outbox))
+		}
+	})
+
+	return r
+}
+
+// requireAPIKey only lets requests with a matching bearer token through
+func requireAPIKey(apiKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(token), []byte(apiKey)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// triggerSyncHandler starts a run of an integration. The run can be limited to one or
+// more entity types using the type query parameter, e.g. ?type=beaches&type=trails. A
+// trigger that arrives while a run is already queued returns that run.
+func triggerSyncHandler(m *integrations.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "integration")
+		entityTypes := r.URL.Query()["type"]
+
+		info, err := m.Trigger(name, entityTypes...)
+		if err != nil {
+			logging.GetFromContext(r.Context()).Error("failed to trigger sync", "integration", name, "err", err.Error())
+
+			status := http.StatusInternalServerError
+			if errors.Is(err, integrations.ErrNotFound) {
+				status = http.StatusNotFound
+			} else if errors.Is(err, integrations.ErrUnsupportedType) {
+				status = http.StatusBadRequest
+			} else if errors.Is(err, context.Canceled) {
+				status = http.StatusServiceUnavailable
+			}
+
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		w.Header().Set("Location", "/admin/runs/"+info.ID)
+		writeJSON(w, http.StatusAccepted, info)
+	}
+}
+
+func getRunHandler(m *integrations.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, ok := m.Run(chi.URLParam(r, "id"))
+		if !ok {
+			http.Error(w, "no such run", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, info)
+	}
+}
+
+func listOutboxHandler(outbox *integrations.Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, outbox.Entries())
+	}
+}
+
+// replayOutboxHandler replays the pending write of a single entity, or all pending writes,
+// without waiting for them to become due
+func replayOutboxHandler(outbox *integrations.Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var filter func(integrations.OutboxEntry) bool
+
+		if entityID := chi.URLParam(r, "entityID"); entityID != "" {
+			if !slices.ContainsFunc(outbox.Entries(), func(e integrations.OutboxEntry) bool { return e.EntityID == entityID }) {
+				http.Error(w, "no pending write of "+entityID, http.StatusNotFound)
+				return
+			}
+			filter = func(e integrations.OutboxEntry) bool { return e.EntityID == entityID }
+		}
+
+		replayed, failed := outbox.Replay(r.Context(), filter)
+
+		writeJSON(w, http.StatusOK, map[string]int{"replayed": replayed, "failed": failed})
+	}
+}
+
+func discardOutboxHandler(outbox *integrations.Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entityID := chi.URLParam(r, "entityID")
+
+		if err := outbox.Discard(entityID); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, integrations.ErrNotFound) {
+				status = http.StatusNotFound
+			}
+
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		logging.GetFromContext(r.Context()).Warn("discarded pending write", "entityID", entityID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// statusHandler reports the sync status of every integration, and responds with
+// 503 Service Unavailable if any of them is unhealthy
+func statusHandler(m *integrations.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := m.Report()
+
+		statusCode := http.StatusOK
+		if !report.Healthy {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, statusCode, report)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(b)
+}
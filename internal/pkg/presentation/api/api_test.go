@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-cip-sdl/internal/pkg/application/integrations"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/schedule"
+	"github.com/diwise/integration-cip-sdl/internal/pkg/infrastructure/state"
+	"github.com/matryer/is"
+)
+
+func TestThatAdminEndpointsRequireAnAPIKey(t *testing.T) {
+	is, server, _ := testSetup(t)
+
+	resp, err := http.Post(server.URL+"/admin/integrations/fake/sync", "application/json", nil)
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusUnauthorized)
+}
+
+func TestThatAFacilityTypeCanBeSynced(t *testing.T) {
+	is, server, fake := testSetup(t)
+
+	resp, err := doRequest(http.MethodPost, server.URL+"/admin/integrations/fake/sync?type=beaches")
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusAccepted)
+
+	info := integrations.RunInfo{}
+	json.NewDecoder(resp.Body).Decode(&info)
+	is.Equal(resp.Header.Get("Location"), "/admin/runs/"+info.ID)
+
+	<-fake.done
+
+	// the run state is updated right after the integration returns, so we may need to wait a bit
+	for range 10 {
+		resp, err = doRequest(http.MethodGet, server.URL+"/admin/runs/"+info.ID)
+		is.NoErr(err)
+		is.Equal(resp.StatusCode, http.StatusOK)
+
+		json.NewDecoder(resp.Body).Decode(&info)
+		if info.State == integrations.RunSucceeded {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	is.Equal(info.State, integrations.RunSucceeded)
+	is.Equal(info.EntityTypes, []string{"beaches"})
+	is.Equal(fake.types, []string{"beaches"})
+}
+
+func TestThatUnknownTypesAreRejected(t *testing.T) {
+	is, server, _ := testSetup(t)
+
+	resp, err := doRequest(http.MethodPost, server.URL+"/admin/integrations/fake/sync?type=spaceports")
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusBadRequest)
+}
+
+func TestThatUnknownIntegrationsAreNotFound(t *testing.T) {
+	is, server, _ := testSetup(t)
+
+	resp, err := doRequest(http.MethodPost, server.URL+"/admin/integrations/unknown/sync")
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func TestThatStatusIsUnhealthyWhenSyncIsTooOld(t *testing.T) {
+	is, server, fake := testSetup(t)
+
+	resp, err := http.Get(server.URL + "/status")
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	fake.lastSuccess = time.Now().Add(-3 * time.Hour)
+
+	resp, err = http.Get(server.URL + "/readyz")
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusServiceUnavailable)
+
+	report := integrations.Report{}
+	json.NewDecoder(resp.Body).Decode(&report)
+	is.True(!report.Healthy)
+	is.Equal(report.Integrations[0].Name, "fake")
+}
+
+func TestThatMetricsCanBeScraped(t *testing.T) {
+	is, server, _ := testSetup(t)
+	integrations.ObserveFetch("fake", time.Now(), http.StatusOK, nil)
+
+	resp, err := http.Get(server.URL + "/metrics")
+	is.NoErr(err)
+	defer resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	body, _ := io.ReadAll(resp.Body)
+	is.True(strings.Contains(string(body), `cip_sdl_source_fetch_duration_seconds_count{integration="fake",status="200"} 1`))
+}
+
+const apiKey string = "secret"
+
+func doRequest(method, url string) (*http.Response, error) {
+	req, _ := http.NewRequest(method, url, nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	return http.DefaultClient.Do(req)
+}
+
+func TestThatPendingWritesCanBeListedAndDiscarded(t *testing.T) {
+	store := state.NewMemoryStore()
+	store.Save("outbox", []byte(`[{"entityId":"urn:ngsi-ld:Beach:1","integration":"fake","entityType":"beaches","operation":"delete"}]`))
+
+	is, server, _ := testSetup(t, integrations.WithOutbox(integrations.NewOutbox(nil, store)))
+
+	resp, err := doRequest(http.MethodGet, server.URL+"/admin/outbox")
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	entries := []integrations.OutboxEntry{}
+	json.NewDecoder(resp.Body).Decode(&entries)
+	is.Equal(len(entries), 1)
+	is.Equal(entries[0].Operation, integrations.OperationDelete)
+
+	resp, err = doRequest(http.MethodDelete, server.URL+"/admin/outbox/urn:ngsi-ld:Beach:1")
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusNoContent)
+
+	resp, err = doRequest(http.MethodPost, server.URL+"/admin/outbox/urn:ngsi-ld:Beach:1/replay")
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusNotFound)
+
+	data, _ := store.Load("outbox")
+	is.Equal(string(data), "[]")
+}
+
+func testSetup(t *testing.T, options ...func(*integrations.Manager)) (*is.I, *httptest.Server, *fakeIntegration) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	fake := &fakeIntegration{done: make(chan struct{}, 10)}
+
+	m := integrations.NewManager(options...)
+	m.Add(fake, integrations.Settings{Schedule: schedule.Every(time.Hour), MaxSyncAge: 2 * time.Hour})
+	m.Start(ctx)
+
+	// wait for the initial scheduled run to complete
+	<-fake.done
+
+	server := httptest.NewServer(New(ctx, m, apiKey))
+	t.Cleanup(server.Close)
+
+	return is, server, fake
+}
+
+type fakeIntegration struct {
+	types       []string
+	lastSuccess time.Time
+	done        chan struct{}
+}
+
+func (f *fakeIntegration) Name() string                       { return "fake" }
+func (f *fakeIntegration) Validate(ctx context.Context) error { return nil }
+func (f *fakeIntegration) EntityTypes() []string              { return []string{"beaches", "trails"} }
+
+func (f *fakeIntegration) Status() integrations.Status {
+	return integrations.Status{Name: f.Name(), LastSuccess: f.lastSuccess}
+}
+
+func (f *fakeIntegration) Run(ctx context.Context, types ...string) error {
+	f.types = types
+	f.done <- struct{}{}
+	return nil
+}